@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ImportReport is what the server did with an import
+type ImportReport struct {
+	Mode    string           `json:"mode"`
+	Applied bool             `json:"applied"`
+	Rows    int              `json:"rows"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Deleted int              `json:"deleted"`
+	Errors  []ImportRowError `json:"errors"`
+}
+
+// ImportRowError explains why one row of an import was rejected. Rows count from 1.
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// BackupInfo describes a backup kept by the server
+type BackupInfo struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// Export writes the whole league to w in format, such as json or csv
+func (c *Client) Export(ctx context.Context, format string, w io.Writer) error {
+	path := "/admin/export?" + url.Values{"format": {format}}.Encode()
+	response, err := c.do(ctx, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+// Import loads a league in format (json or csv) with mode merge, replace or
+// dry-run. When rows are rejected the report lists them alongside the error.
+func (c *Client) Import(ctx context.Context, league io.Reader, format, mode string) (ImportReport, error) {
+	var report ImportReport
+	body, err := io.ReadAll(league)
+	if err != nil {
+		return report, err
+	}
+
+	path := "/admin/import?" + url.Values{"format": {format}, "mode": {mode}}.Encode()
+	response, err := c.do(ctx, http.MethodPost, path, body, nil)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+		json.Unmarshal([]byte(apiErr.Message), &report)
+		return report, err
+	}
+	if err != nil {
+		return report, err
+	}
+	defer response.Body.Close()
+
+	err = json.NewDecoder(response.Body).Decode(&report)
+	if err != nil {
+		return report, fmt.Errorf("problem parsing import report, %v", err)
+	}
+	return report, nil
+}
+
+// Backup asks the server to take a backup now
+func (c *Client) Backup(ctx context.Context) (BackupInfo, error) {
+	var backup BackupInfo
+	response, err := c.do(ctx, http.MethodPost, "/admin/backup", nil, nil)
+	if err != nil {
+		return backup, err
+	}
+	defer response.Body.Close()
+
+	err = json.NewDecoder(response.Body).Decode(&backup)
+	if err != nil {
+		return backup, fmt.Errorf("problem parsing backup, %v", err)
+	}
+	return backup, nil
+}
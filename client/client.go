@@ -0,0 +1,268 @@
+// Package client talks to a league server started with httpserver.NewPlayerServer
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Player is a name with a number of wins, as the server sends it
+type Player struct {
+	Name string
+	Wins int
+}
+
+// maxErrorBody is as much of an error response as is kept for Error.Message
+const maxErrorBody = 64 << 10
+
+// Client calls the player API. It is safe to use from several goroutines.
+type Client struct {
+	// BaseURL is where the server is, e.g. http://localhost:5000
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// Retries is how many more times a failed request is tried
+	Retries int
+	// RetryBase is the wait before the first retry, it doubles each time up to RetryMax
+	RetryBase time.Duration
+	RetryMax  time.Duration
+
+	mu    sync.RWMutex
+	token string
+}
+
+// New creates a Client for the server at baseURL with the default retries
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		Retries:   3,
+		RetryBase: 100 * time.Millisecond,
+		RetryMax:  2 * time.Second,
+	}
+}
+
+// Token returns the bearer token sent with each request
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// SetToken sets the bearer token sent with each request, "" sends none
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// Login swaps a username and password for a token, which is sent with every
+// request from then on
+func (c *Client) Login(ctx context.Context, username, password string) (string, error) {
+	response, err := c.do(ctx, http.MethodPost, "/login", nil, func(r *http.Request) {
+		r.SetBasicAuth(username, password)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(string(body), "Bearer "))
+	if token == "" {
+		return "", fmt.Errorf("server sent no token")
+	}
+	c.SetToken(token)
+	return token, nil
+}
+
+// Logout stops the token working on the server, and stops sending it
+func (c *Client) Logout(ctx context.Context) error {
+	err := c.discard(c.do(ctx, http.MethodPost, "/logout", nil, nil))
+	if err != nil {
+		return err
+	}
+	c.SetToken("")
+	return nil
+}
+
+// Ping checks the server is answering
+func (c *Client) Ping(ctx context.Context) error {
+	return c.discard(c.do(ctx, http.MethodGet, "/ping", nil, nil))
+}
+
+// GetScore returns a player's wins. Players with no wins are ErrNotFound.
+func (c *Client) GetScore(ctx context.Context, name string) (int, error) {
+	response, err := c.do(ctx, http.MethodGet, playerPath(name), nil, acceptJSON)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var player Player
+	err = json.NewDecoder(response.Body).Decode(&player)
+	if err != nil {
+		return 0, fmt.Errorf("problem parsing score for %s, %v", name, err)
+	}
+	return player.Wins, nil
+}
+
+// RecordWin gives a player one more win, creating them if the server allows it
+func (c *Client) RecordWin(ctx context.Context, name string) error {
+	return c.discard(c.do(ctx, http.MethodPost, playerPath(name), nil, nil))
+}
+
+// PutPlayers sets the wins of each player, creating any that don't exist
+func (c *Client) PutPlayers(ctx context.Context, players ...Player) error {
+	if players == nil {
+		players = []Player{}
+	}
+	body, err := json.Marshal(players)
+	if err != nil {
+		return err
+	}
+	return c.discard(c.do(ctx, http.MethodPut, "/store/", body, nil))
+}
+
+// Delete removes a player
+func (c *Client) Delete(ctx context.Context, name string) error {
+	return c.discard(c.do(ctx, http.MethodDelete, playerPath(name), nil, nil))
+}
+
+// League returns every player, most wins first
+func (c *Client) League(ctx context.Context) ([]Player, error) {
+	response, err := c.do(ctx, http.MethodGet, "/list", nil, acceptJSON)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var league []Player
+	err = json.NewDecoder(response.Body).Decode(&league)
+	if err != nil {
+		return nil, fmt.Errorf("problem parsing league, %v", err)
+	}
+	return league, nil
+}
+
+func playerPath(name string) string {
+	return "/store/" + url.PathEscape(name)
+}
+
+func acceptJSON(r *http.Request) {
+	r.Header.Set("Accept", "application/json")
+}
+
+// discard closes the body of a response that only matters for its status
+func (c *Client) discard(response *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, response.Body)
+	return response.Body.Close()
+}
+
+// do sends a request, retrying while it fails in a way that might pass.
+// Requests that aren't idempotent are only sent again when the server
+// refused them outright. Any response other than a 2xx is an *Error.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, prepare func(*http.Request)) (*http.Response, error) {
+	idempotent := method != http.MethodPost
+	for attempt := 0; ; attempt++ {
+		response, err := c.send(ctx, method, path, body, prepare)
+		if err == nil {
+			return response, nil
+		}
+		if attempt >= c.Retries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := c.backoff(attempt)
+		if apiErr, ok := err.(*Error); ok {
+			if apiErr.Rule != "" || !apiErr.temporary() || (!idempotent && !apiErr.refused()) {
+				return nil, err
+			}
+			if apiErr.RetryAfter > wait {
+				wait = apiErr.RetryAfter
+			}
+		} else if !idempotent {
+			// the request may have reached the server before the connection failed
+			return nil, err
+		}
+		if c.RetryMax > 0 && wait > c.RetryMax {
+			wait = c.RetryMax
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.RetryBase << uint(attempt)
+	if wait <= 0 || (c.RetryMax > 0 && wait > c.RetryMax) {
+		return c.RetryMax
+	}
+	return wait
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body []byte, prepare func(*http.Request)) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token := c.Token(); token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	if prepare != nil {
+		prepare(request)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+
+	defer response.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+	apiErr := &Error{
+		Method:     method,
+		Path:       path,
+		StatusCode: response.StatusCode,
+		Message:    strings.TrimSpace(string(message)),
+		Rule:       response.Header.Get("X-Blocked-By-Rule"),
+	}
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return nil, apiErr
+}
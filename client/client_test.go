@@ -0,0 +1,302 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hello/client"
+	"hello/httpserver"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer runs a real PlayerServer, with wrap given the chance to sit in front of it
+func newTestServer(t testing.TB, wrap func(http.Handler) http.Handler) (*client.Client, *httpserver.PlayerServer) {
+	t.Helper()
+	store, err := httpserver.NewEventSourcedPlayerStore(&httpserver.MemoryEventLog{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httpserver.NewPlayerServer(store)
+
+	var handler http.Handler = server
+	if wrap != nil {
+		handler = wrap(server)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	c := client.New(ts.URL)
+	c.RetryBase = time.Millisecond
+	c.RetryMax = 10 * time.Millisecond
+	return c, server
+}
+
+// failFirst answers the first n requests with status before letting the rest through
+func failFirst(n int32, status int, header http.Header, attempts *int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(attempts, 1) <= n {
+				for key, values := range header {
+					w.Header()[key] = values
+				}
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func assertNoError(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("didn't expect an error but got one, %v", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("records wins and reads them back", func(t *testing.T) {
+		c, _ := newTestServer(t, nil)
+
+		assertNoError(t, c.RecordWin(ctx, "Pepper"))
+		assertNoError(t, c.RecordWin(ctx, "Pepper"))
+		assertNoError(t, c.RecordWin(ctx, "Floyd Mayweather"))
+
+		score, err := c.GetScore(ctx, "Pepper")
+		assertNoError(t, err)
+		if score != 2 {
+			t.Errorf("got score %d want 2", score)
+		}
+
+		league, err := c.League(ctx)
+		assertNoError(t, err)
+		want := []client.Player{{"Pepper", 2}, {"Floyd Mayweather", 1}}
+		if !reflect.DeepEqual(league, want) {
+			t.Errorf("got %v want %v", league, want)
+		}
+	})
+
+	t.Run("puts and deletes players", func(t *testing.T) {
+		c, _ := newTestServer(t, nil)
+
+		assertNoError(t, c.PutPlayers(ctx, client.Player{"Cleo", 10}, client.Player{"Chris", 33}))
+		assertNoError(t, c.Delete(ctx, "Cleo"))
+
+		league, err := c.League(ctx)
+		assertNoError(t, err)
+		if !reflect.DeepEqual(league, []client.Player{{"Chris", 33}}) {
+			t.Errorf("got %v want only Chris", league)
+		}
+	})
+
+	t.Run("returns typed errors", func(t *testing.T) {
+		c, _ := newTestServer(t, nil)
+
+		_, err := c.GetScore(ctx, "Nobody")
+		if !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("got %v want ErrNotFound", err)
+		}
+
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Method != http.MethodGet {
+			t.Errorf("got %#v want a GET 404 *client.Error", err)
+		}
+	})
+
+	t.Run("names the rule that blocked a request", func(t *testing.T) {
+		c, server := newTestServer(t, nil)
+		server.Rules = []httpserver.Rule{httpserver.ExistingPlayersOnly{}}
+
+		err := c.RecordWin(ctx, "Nobody")
+		if !errors.Is(err, client.ErrBlocked) || !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("got %v want it blocked and not found", err)
+		}
+		var apiErr *client.Error
+		errors.As(err, &apiErr)
+		if apiErr.Rule != "existing-players-only" {
+			t.Errorf("got rule %q want existing-players-only", apiErr.Rule)
+		}
+	})
+
+	t.Run("pings", func(t *testing.T) {
+		c, _ := newTestServer(t, nil)
+		assertNoError(t, c.Ping(ctx))
+	})
+}
+
+func TestClientLogin(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("sends the token with later requests", func(t *testing.T) {
+		var authorization atomic.Value
+		c, _ := newTestServer(t, func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization.Store(r.Header.Get("Authorization"))
+				next.ServeHTTP(w, r)
+			})
+		})
+
+		token, err := c.Login(ctx, "user_a", "passwordA")
+		assertNoError(t, err)
+		if token == "" || c.Token() != token {
+			t.Fatalf("got token %q and client token %q", token, c.Token())
+		}
+
+		assertNoError(t, c.Ping(ctx))
+		if got := authorization.Load(); got != "Bearer "+token {
+			t.Errorf("got Authorization %q want the bearer token", got)
+		}
+	})
+
+	t.Run("logs out", func(t *testing.T) {
+		c, _ := newTestServer(t, nil)
+		token, err := c.Login(ctx, "user_a", "passwordA")
+		assertNoError(t, err)
+
+		assertNoError(t, c.Logout(ctx))
+		if c.Token() != "" {
+			t.Errorf("got token %q want none", c.Token())
+		}
+
+		c.SetToken(token)
+		if err := c.Logout(ctx); !errors.Is(err, client.ErrUnauthorized) {
+			t.Errorf("got %v want the old token refused", err)
+		}
+	})
+
+	t.Run("reports bad passwords", func(t *testing.T) {
+		c, _ := newTestServer(t, nil)
+
+		_, err := c.Login(ctx, "user_a", "wrong")
+		if !errors.Is(err, client.ErrUnauthorized) {
+			t.Errorf("got %v want ErrUnauthorized", err)
+		}
+		if c.Token() != "" {
+			t.Errorf("got token %q want none", c.Token())
+		}
+	})
+}
+
+func TestClientRetries(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retries reads while the server is unavailable", func(t *testing.T) {
+		var attempts int32
+		c, _ := newTestServer(t, failFirst(2, http.StatusBadGateway, nil, &attempts))
+
+		_, err := c.League(ctx)
+		assertNoError(t, err)
+		if attempts != 3 {
+			t.Errorf("got %d attempts want 3", attempts)
+		}
+	})
+
+	t.Run("gives up after Retries", func(t *testing.T) {
+		var attempts int32
+		c, _ := newTestServer(t, failFirst(10, http.StatusServiceUnavailable, nil, &attempts))
+		c.Retries = 2
+
+		err := c.Ping(ctx)
+		if !errors.Is(err, client.ErrUnavailable) {
+			t.Errorf("got %v want ErrUnavailable", err)
+		}
+		if attempts != 3 {
+			t.Errorf("got %d attempts want 3", attempts)
+		}
+	})
+
+	t.Run("retries wins the server refused", func(t *testing.T) {
+		var attempts int32
+		c, server := newTestServer(t, failFirst(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}}, &attempts))
+
+		start := time.Now()
+		assertNoError(t, c.RecordWin(ctx, "Pepper"))
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("waited %v, Retry-After should be capped by RetryMax", elapsed)
+		}
+		if score := server.Store.GetPlayerScore("Pepper"); score != 1 {
+			t.Errorf("got score %d want exactly one win", score)
+		}
+	})
+
+	t.Run("doesn't repeat wins that may have been recorded", func(t *testing.T) {
+		var attempts int32
+		c, _ := newTestServer(t, failFirst(1, http.StatusBadGateway, nil, &attempts))
+
+		err := c.RecordWin(ctx, "Pepper")
+		if err == nil || attempts != 1 {
+			t.Errorf("got %v after %d attempts want a single failed attempt", err, attempts)
+		}
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		var attempts int32
+		c, _ := newTestServer(t, failFirst(10, http.StatusServiceUnavailable, nil, &attempts))
+		c.RetryBase = time.Hour
+		c.RetryMax = time.Hour
+
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		err := c.Ping(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v want the context deadline", err)
+		}
+	})
+}
+
+func TestClientAdmin(t *testing.T) {
+	ctx := context.Background()
+	newAdminServer := func(t testing.TB, wrap func(http.Handler) http.Handler) (*client.Client, *httpserver.PlayerServer) {
+		c, server := newTestServer(t, wrap)
+		_, err := c.Login(ctx, "admin", "Password1")
+		assertNoError(t, err)
+		return c, server
+	}
+
+	t.Run("imports and exports", func(t *testing.T) {
+		c, _ := newAdminServer(t, nil)
+
+		report, err := c.Import(ctx, strings.NewReader("name,wins\nCleo,10\n"), "csv", "merge")
+		assertNoError(t, err)
+		if !report.Applied || report.Created != 1 {
+			t.Errorf("got %+v want Cleo created", report)
+		}
+
+		var exported bytes.Buffer
+		assertNoError(t, c.Export(ctx, "csv", &exported))
+		if !strings.Contains(exported.String(), "Cleo,10") {
+			t.Errorf("got export %q want Cleo in it", exported.String())
+		}
+	})
+
+	t.Run("returns the report when rows are rejected", func(t *testing.T) {
+		c, _ := newAdminServer(t, nil)
+
+		report, err := c.Import(ctx, strings.NewReader(`[{"Name": "Cleo", "Wins": -1}]`), "json", "merge")
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("got %v want a 422", err)
+		}
+		if len(report.Errors) != 1 || report.Errors[0].Row != 1 {
+			t.Errorf("got %+v want row 1 rejected", report)
+		}
+	})
+
+	t.Run("reports backups that aren't configured", func(t *testing.T) {
+		c, _ := newAdminServer(t, nil)
+
+		_, err := c.Backup(ctx)
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotImplemented {
+			t.Errorf("got %v want a 501", err)
+		}
+	})
+}
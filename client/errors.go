@@ -0,0 +1,72 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors an *Error can be matched against with errors.Is
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrBlocked      = errors.New("blocked by a rule")
+	ErrUnavailable  = errors.New("server unavailable")
+)
+
+// Error is a response from the server that wasn't a success
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Message is the body the server sent, usually a one line reason
+	Message string
+	// Rule names the server rule that turned the request away, if one did
+	Rule string
+	// RetryAfter is how long the server asked the client to wait, if it did
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Rule != "" {
+		return fmt.Sprintf("%s %s: %d %s (rule %s)", e.Method, e.Path, e.StatusCode, e.Message, e.Rule)
+	}
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// Is matches e against the Err values above
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrBlocked:
+		return e.Rule != ""
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// temporary reports whether trying again later might succeed
+func (e *Error) temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// refused reports whether the server turned the request away without acting
+// on it, so even a request that isn't idempotent can be sent again
+func (e *Error) refused() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+}
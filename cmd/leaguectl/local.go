@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"hello/client"
+	"hello/httpserver"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// localAddr is the RemoteAddr requests from handlerTransport come from
+const localAddr = "127.0.0.1:0"
+
+// handlerTransport answers requests by calling a handler in this process
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.RemoteAddr = localAddr
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, r)
+	return recorder.Result(), nil
+}
+
+// quietLog writes log lines to w, apart from the line the server logs for
+// every request, which is noise on a command line
+type quietLog struct {
+	w io.Writer
+}
+
+func (l quietLog) Write(line []byte) (int, error) {
+	if strings.HasSuffix(string(line), " "+localAddr+"\n") {
+		return len(line), nil
+	}
+	return l.w.Write(line)
+}
+
+// localClient runs a server on the -db file inside this process, so commands
+// work the same way as they would over HTTP. The rules are the ones set by
+// -name-pattern, -auto-create and -max-wins-per-hour, which need to match the
+// server's flags, and -max-wins-per-hour only counts wins in this command.
+// Webhook events are queued in the webhooks file for the server to send when
+// it next starts. The file is locked while it is open, so this fails if the
+// server is running.
+func (o *options) localClient() (*client.Client, error) {
+	var pattern *regexp.Regexp
+	if o.namePattern != "" {
+		var err error
+		pattern, err = regexp.Compile(o.namePattern)
+		if err != nil {
+			return nil, fmt.Errorf("problem with -name-pattern %q, %v", o.namePattern, err)
+		}
+	}
+	// stale lock, migration and write warnings from the store go to stderr
+	log.SetOutput(quietLog{o.stderr})
+	log.SetFlags(0)
+
+	key, err := httpserver.LoadDatabaseKey(o.dbKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(o.db, os.O_RDWR, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("problem opening %s, %v", o.db, err)
+	}
+	store, err := httpserver.NewEncryptedFileSystemPlayerStore(file, key)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("problem opening %s, %v", o.db, err)
+	}
+	o.closeStore = store.Close
+
+	server := httpserver.NewPlayerServer(store)
+	server.Rules = httpserver.StandardRules(pattern, o.autoCreate, o.maxWinsPerHour)
+	server.Backups = httpserver.NewBackupManager(o.backupDir, store)
+	// deleted players go in the same trash bin the server uses
+	server.Journal, err = httpserver.NewEncryptedJournal(filepath.Join(filepath.Dir(o.db), "journal.json"), key)
+	if err != nil {
+		return nil, err
+	}
+	webhooksFile := o.webhooksFile
+	if webhooksFile == "" {
+		webhooksFile = filepath.Join(filepath.Dir(o.db), "webhooks.json")
+	}
+	if webhooksFile != "-" {
+		// not started, so nothing is sent from here
+		server.Webhooks, err = httpserver.NewEncryptedWebhookDispatcher(webhooksFile, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// whoever can open the database file can already change anything in it
+	token, err := server.IssueToken("admin")
+	if err != nil {
+		return nil, err
+	}
+
+	c := client.New("http://" + filepath.Base(o.db))
+	c.HTTPClient = &http.Client{Transport: handlerTransport{server}}
+	c.Retries = 0
+	c.SetToken(token)
+	return c, nil
+}
@@ -0,0 +1,432 @@
+// leaguectl manages a league from the command line, through a running server
+// or, when the server is down, directly on its database file.
+//
+//	leaguectl [-server URL | -db game.db.json] <command> [arguments]
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hello/client"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: leaguectl [flags] <command> [arguments]
+
+commands:
+  ping                          check the server is answering
+  score NAME                    show a player's wins
+  win NAME...                   record a win for each player
+  add NAME WINS [NAME WINS]...  set players' wins, creating them if needed
+  delete NAME...                delete players
+  list [-o table|json|csv]      show the league
+  login USER [PASSWORD]         log in and keep the token for later commands
+  import [-mode M] [-format F] FILE
+                                load players from a json or csv file, - for stdin
+  export [-format F] [-o FILE]  write the league out, json by default
+  backup                        take a backup
+
+flags:
+`
+
+// Environment variables used when the matching flag isn't given
+const (
+	serverEnv = "LEAGUE_SERVER"
+	tokenEnv  = "LEAGUE_TOKEN"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// options are the flags that come before the command
+type options struct {
+	server    string
+	db        string
+	dbKeyFile string
+	backupDir string
+	// the server's rule and webhook settings, for -db
+	namePattern    string
+	autoCreate     bool
+	maxWinsPerHour int
+	webhooksFile   string
+	token          string
+	tokenFile      string
+	timeout        time.Duration
+	stdin          io.Reader
+	stdout         io.Writer
+	stderr         io.Writer
+	closeStore     func() error
+}
+
+// run carries out one command and returns the exit status: 0 when it worked,
+// 1 when it failed and 2 when it was used wrongly
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	o := &options{stdin: stdin, stdout: stdout, stderr: stderr}
+	flags := flag.NewFlagSet("leaguectl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&o.server, "server", envOr(serverEnv, "http://localhost:5000"), "URL of the server, or $"+serverEnv)
+	flags.StringVar(&o.db, "db", "", "work on this database file directly instead of a server, e.g. game.db.json")
+	flags.StringVar(&o.dbKeyFile, "db-key-file", "", "key the -db file is encrypted with, otherwise $LEAGUE_DB_KEY is used if set")
+	flags.StringVar(&o.backupDir, "backup-dir", "backups", "directory backups go in when using -db")
+	flags.StringVar(&o.namePattern, "name-pattern", "", "regular expression player names must match when using -db, as the server's -name-pattern, empty allows any name")
+	flags.BoolVar(&o.autoCreate, "auto-create", true, "create players the first time they win when using -db, as the server's -auto-create")
+	flags.IntVar(&o.maxWinsPerHour, "max-wins-per-hour", 0, "most wins a player can be given in an hour when using -db. Only wins in this command count, the server's are unknown.")
+	flags.StringVar(&o.webhooksFile, "webhooks-file", "", "where webhook events are queued for the server to send when using -db, webhooks.json next to the -db file by default, - for nowhere")
+	flags.StringVar(&o.token, "token", os.Getenv(tokenEnv), "bearer token to send, or $"+tokenEnv+", otherwise the one saved by login")
+	flags.StringVar(&o.tokenFile, "token-file", defaultTokenFile(), "where login keeps the token")
+	flags.DurationVar(&o.timeout, "timeout", 10*time.Second, "how long a command may take")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	c, err := o.client()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if o.closeStore != nil {
+		defer func() {
+			if err := o.closeStore(); err != nil {
+				fmt.Fprintln(stderr, err)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	err = o.dispatch(ctx, c, command, rest)
+	var usageErr usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(stderr, "leaguectl %s: %v\n", command, err)
+		return 2
+	default:
+		fmt.Fprintf(stderr, "leaguectl %s: %v\n", command, o.explain(err))
+		return 1
+	}
+}
+
+// usageError is a command used with the wrong arguments
+type usageError string
+
+func (u usageError) Error() string { return string(u) }
+
+func (o *options) dispatch(ctx context.Context, c *client.Client, command string, args []string) error {
+	switch command {
+	case "ping":
+		return o.ping(ctx, c)
+	case "score":
+		return o.score(ctx, c, args)
+	case "win":
+		return o.win(ctx, c, args)
+	case "add":
+		return o.add(ctx, c, args)
+	case "delete":
+		return o.remove(ctx, c, args)
+	case "list":
+		return o.list(ctx, c, args)
+	case "login":
+		return o.login(ctx, c, args)
+	case "import":
+		return o.importLeague(ctx, c, args)
+	case "export":
+		return o.export(ctx, c, args)
+	case "backup":
+		return o.backup(ctx, c)
+	}
+	return usageError(fmt.Sprintf("unknown command %q, run leaguectl -h for the list", command))
+}
+
+// client talks to the server, or to a server run in this process on the -db file
+func (o *options) client() (*client.Client, error) {
+	if o.db != "" {
+		return o.localClient()
+	}
+
+	c := client.New(o.server)
+	token := o.token
+	if token == "" {
+		saved, err := os.ReadFile(o.tokenFile)
+		if err == nil {
+			token = strings.TrimSpace(string(saved))
+		}
+	}
+	c.SetToken(token)
+	return c, nil
+}
+
+// explain adds a hint to errors that mean the server isn't there
+func (o *options) explain(err error) error {
+	var apiErr *client.Error
+	if o.db != "" || errors.As(err, &apiErr) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%v\ncan't reach %s, if the server is down use -db to work on its database file", err, o.server)
+}
+
+func (o *options) ping(ctx context.Context, c *client.Client) error {
+	start := time.Now()
+	err := c.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.stdout, "pong from %s in %v\n", o.target(), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func (o *options) target() string {
+	if o.db != "" {
+		return o.db
+	}
+	return o.server
+}
+
+func (o *options) score(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return usageError("give one player name")
+	}
+	wins, err := c.GetScore(ctx, args[0])
+	if errors.Is(err, client.ErrNotFound) {
+		return fmt.Errorf("player %s has no wins", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(o.stdout, wins)
+	return nil
+}
+
+func (o *options) win(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) == 0 {
+		return usageError("give at least one player name")
+	}
+	for _, name := range args {
+		if err := c.RecordWin(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *options) add(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return usageError("give pairs of player name and wins")
+	}
+	players := make([]client.Player, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		wins, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return usageError(fmt.Sprintf("wins for %s must be a number, not %q", args[i], args[i+1]))
+		}
+		players = append(players, client.Player{Name: args[i], Wins: wins})
+	}
+	return c.PutPlayers(ctx, players...)
+}
+
+func (o *options) remove(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) == 0 {
+		return usageError("give at least one player name")
+	}
+	for _, name := range args {
+		if err := c.Delete(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *options) list(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.SetOutput(o.stderr)
+	output := flags.String("o", "table", "output format: table, json or csv")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if *output != "table" && *output != "json" && *output != "csv" {
+		return usageError(fmt.Sprintf("unknown output %q, use table, json or csv", *output))
+	}
+
+	league, err := c.League(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case "table":
+		w := tabwriter.NewWriter(o.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "RANK\tNAME\tWINS")
+		for i, player := range league {
+			fmt.Fprintf(w, "%d\t%s\t%d\n", i+1, player.Name, player.Wins)
+		}
+		return w.Flush()
+	case "json":
+		encoder := json.NewEncoder(o.stdout)
+		encoder.SetIndent("", "  ")
+		if league == nil {
+			league = []client.Player{}
+		}
+		return encoder.Encode(league)
+	case "csv":
+		w := csv.NewWriter(o.stdout)
+		w.Write([]string{"name", "wins"})
+		for _, player := range league {
+			w.Write([]string{player.Name, strconv.Itoa(player.Wins)})
+		}
+		w.Flush()
+		return w.Error()
+	}
+	return nil
+}
+
+func (o *options) login(ctx context.Context, c *client.Client, args []string) error {
+	if o.db != "" {
+		return usageError("login needs a server, not -db")
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return usageError("give a username and optionally a password")
+	}
+
+	password := ""
+	if len(args) == 2 {
+		password = args[1]
+	} else {
+		fmt.Fprint(o.stderr, "password: ")
+		line, err := bufio.NewReader(o.stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("problem reading password, %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	token, err := c.Login(ctx, args[0], password)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(o.tokenFile, []byte(token+"\n"), 0600)
+	if err != nil {
+		return fmt.Errorf("logged in but couldn't save the token, %v", err)
+	}
+	fmt.Fprintf(o.stdout, "logged in as %s, token saved in %s\n", args[0], o.tokenFile)
+	return nil
+}
+
+func (o *options) importLeague(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(o.stderr)
+	mode := flags.String("mode", "merge", "merge, replace or dry-run")
+	format := flags.String("format", "", "json or csv, from the file name when not given")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if flags.NArg() != 1 {
+		return usageError("give one file to import, or - for stdin")
+	}
+
+	path := flags.Arg(0)
+	var in io.Reader = o.stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	if *format == "" {
+		*format = "json"
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			*format = "csv"
+		}
+	}
+
+	report, err := c.Import(ctx, in, *format, *mode)
+	for _, rowErr := range report.Errors {
+		fmt.Fprintf(o.stderr, "row %d %s: %s\n", rowErr.Row, rowErr.Name, rowErr.Error)
+	}
+	if err != nil {
+		if len(report.Errors) > 0 {
+			return fmt.Errorf("nothing imported, %d of %d rows were rejected", len(report.Errors), report.Rows)
+		}
+		return err
+	}
+
+	verb := "imported"
+	if !report.Applied {
+		verb = "would import"
+	}
+	fmt.Fprintf(o.stdout, "%s %d rows: %d created, %d updated, %d deleted\n", verb, report.Rows, report.Created, report.Updated, report.Deleted)
+	return nil
+}
+
+func (o *options) export(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(o.stderr)
+	format := flags.String("format", "json", "json, csv, xml, ndjson, text or html")
+	output := flags.String("o", "-", "file to write, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+
+	if *output == "-" {
+		return c.Export(ctx, *format, o.stdout)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = c.Export(ctx, *format, file)
+	closeErr := file.Close()
+	if err != nil {
+		os.Remove(*output)
+		return err
+	}
+	return closeErr
+}
+
+func (o *options) backup(ctx context.Context, c *client.Client) error {
+	backup, err := c.Backup(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.stdout, "backed up to %s (%d bytes)\n", backup.Name, backup.Size)
+	return nil
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func defaultTokenFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".leaguectl-token"
+	}
+	return filepath.Join(home, ".leaguectl-token")
+}
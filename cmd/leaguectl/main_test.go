@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"hello/httpserver"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// leaguectl runs a command and returns its exit status and output
+func leaguectl(t testing.TB, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	status := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func newTestServer(t testing.TB, wrap func(http.Handler) http.Handler) (string, *httpserver.PlayerServer) {
+	t.Helper()
+	store, err := httpserver.NewEventSourcedPlayerStore(&httpserver.MemoryEventLog{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httpserver.NewPlayerServer(store)
+
+	var handler http.Handler = server
+	if wrap != nil {
+		handler = wrap(server)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts.URL, server
+}
+
+func newTestDatabase(t testing.TB, league string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "game.db.json")
+	if err := os.WriteFile(path, []byte(league), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func assertExit(t testing.TB, got, want int, stderr string) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got exit status %d want %d, stderr: %s", got, want, stderr)
+	}
+}
+
+func TestOverHTTP(t *testing.T) {
+	url, server := newTestServer(t, nil)
+	flags := []string{"-server", url, "-token-file", filepath.Join(t.TempDir(), "token")}
+	ctl := func(args ...string) (int, string, string) {
+		return leaguectl(t, "", append(flags, args...)...)
+	}
+
+	status, _, stderr := ctl("add", "Cleo", "10", "Chris", "33")
+	assertExit(t, status, 0, stderr)
+	status, _, stderr = ctl("win", "Cleo", "Pepper")
+	assertExit(t, status, 0, stderr)
+
+	status, stdout, stderr := ctl("score", "Cleo")
+	assertExit(t, status, 0, stderr)
+	if stdout != "11\n" {
+		t.Errorf("got score %q want 11", stdout)
+	}
+
+	status, stdout, stderr = ctl("list", "-o", "csv")
+	assertExit(t, status, 0, stderr)
+	if want := "name,wins\nChris,33\nCleo,11\nPepper,1\n"; stdout != want {
+		t.Errorf("got csv %q want %q", stdout, want)
+	}
+
+	status, _, stderr = ctl("delete", "Pepper")
+	assertExit(t, status, 0, stderr)
+	if score := server.Store.GetPlayerScore("Pepper"); score != 0 {
+		t.Errorf("got Pepper's score %d want them deleted", score)
+	}
+
+	status, stdout, stderr = ctl("list")
+	assertExit(t, status, 0, stderr)
+	if !strings.Contains(stdout, "RANK") || !strings.Contains(stdout, "Chris") {
+		t.Errorf("got table %q", stdout)
+	}
+
+	status, _, stderr = ctl("score", "Nobody")
+	assertExit(t, status, 1, stderr)
+	if !strings.Contains(stderr, "Nobody has no wins") {
+		t.Errorf("got %q want it to say Nobody has no wins", stderr)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	url, server := newTestServer(t, nil)
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	status, _, stderr := leaguectl(t, "Password1\n", "-server", url, "-token-file", tokenFile, "login", "admin")
+	assertExit(t, status, 0, stderr)
+
+	csvFile := filepath.Join(dir, "league.csv")
+	os.WriteFile(csvFile, []byte("name,wins\nCleo,10\nChris,33\n"), 0666)
+	status, stdout, stderr := leaguectl(t, "", "-server", url, "-token-file", tokenFile, "import", csvFile)
+	assertExit(t, status, 0, stderr)
+	if !strings.Contains(stdout, "2 created") {
+		t.Errorf("got %q want 2 players created", stdout)
+	}
+
+	exported := filepath.Join(dir, "out.json")
+	status, _, stderr = leaguectl(t, "", "-server", url, "-token-file", tokenFile, "export", "-o", exported)
+	assertExit(t, status, 0, stderr)
+	raw, _ := os.ReadFile(exported)
+	if !strings.Contains(string(raw), `"Chris"`) {
+		t.Errorf("got export %s want Chris in it", raw)
+	}
+
+	status, _, stderr = leaguectl(t, `[{"Name": "", "Wins": 3}]`, "-server", url, "-token-file", tokenFile, "import", "-")
+	assertExit(t, status, 1, stderr)
+	if !strings.Contains(stderr, "row 1") || !strings.Contains(stderr, "rejected") {
+		t.Errorf("got %q want the bad row reported", stderr)
+	}
+	if league := server.Store.GetLeague(); len(league) != 2 {
+		t.Errorf("got %v want the league unchanged", league)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	var authorization string
+	url, _ := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			next.ServeHTTP(w, r)
+		})
+	})
+	tokenFile := filepath.Join(t.TempDir(), "token")
+
+	status, _, stderr := leaguectl(t, "passwordA\n", "-server", url, "-token-file", tokenFile, "login", "user_a")
+	assertExit(t, status, 0, stderr)
+	saved, _ := os.ReadFile(tokenFile)
+	token := strings.TrimSpace(string(saved))
+	if token == "" {
+		t.Fatal("expected the token to be saved")
+	}
+
+	status, _, stderr = leaguectl(t, "", "-server", url, "-token-file", tokenFile, "ping")
+	assertExit(t, status, 0, stderr)
+	if authorization != "Bearer "+token {
+		t.Errorf("got Authorization %q want the saved token", authorization)
+	}
+
+	status, _, stderr = leaguectl(t, "", "-server", url, "-token-file", tokenFile, "login", "user_a", "wrong")
+	assertExit(t, status, 1, stderr)
+}
+
+func TestOnTheDatabaseFile(t *testing.T) {
+	t.Run("works without a server", func(t *testing.T) {
+		db := newTestDatabase(t, `[{"Name": "Cleo", "Wins": 10}]`)
+		backups := filepath.Join(filepath.Dir(db), "backups")
+		ctl := func(args ...string) (int, string, string) {
+			return leaguectl(t, "", append([]string{"-db", db, "-backup-dir", backups}, args...)...)
+		}
+
+		status, _, stderr := ctl("win", "Cleo")
+		assertExit(t, status, 0, stderr)
+		status, _, stderr = ctl("delete", "Cleo")
+		assertExit(t, status, 0, stderr)
+		status, _, stderr = ctl("add", "Chris", "33")
+		assertExit(t, status, 0, stderr)
+
+		status, stdout, stderr := ctl("list", "-o", "json")
+		assertExit(t, status, 0, stderr)
+		if !strings.Contains(stdout, `"Name": "Chris"`) || strings.Contains(stdout, "Cleo") {
+			t.Errorf("got %s want only Chris", stdout)
+		}
+
+		status, stdout, stderr = ctl("backup")
+		assertExit(t, status, 0, stderr)
+		entries, _ := os.ReadDir(backups)
+		if len(entries) != 1 || !strings.Contains(stdout, entries[0].Name()) {
+			t.Errorf("got %q and backups %v want one backup named", stdout, entries)
+		}
+
+		journal, err := httpserver.NewJournal(filepath.Join(filepath.Dir(db), "journal.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trash := journal.Trash(); len(trash) != 1 || trash[0].Wins != 11 {
+			t.Errorf("got trash %+v want Cleo with 11 wins", trash)
+		}
+	})
+
+	t.Run("applies the server's rules", func(t *testing.T) {
+		db := newTestDatabase(t, `[]`)
+
+		status, _, stderr := leaguectl(t, "", "-db", db, "add", "Cleo", "-1")
+		assertExit(t, status, 1, stderr)
+		if !strings.Contains(stderr, "non-negative") {
+			t.Errorf("got %q want the rule named", stderr)
+		}
+
+		status, _, stderr = leaguectl(t, "", "-db", db, "-auto-create=false", "win", "Cleo")
+		assertExit(t, status, 1, stderr)
+		status, _, stderr = leaguectl(t, "", "-db", db, "-name-pattern", "^[a-z]+$", "add", "Cleo", "1")
+		assertExit(t, status, 1, stderr)
+		if !strings.Contains(stderr, "name-policy") {
+			t.Errorf("got %q want the rule named", stderr)
+		}
+	})
+
+	t.Run("queues webhook events for the server", func(t *testing.T) {
+		db := newTestDatabase(t, `[]`)
+		webhooks, err := httpserver.NewWebhookDispatcher(filepath.Join(filepath.Dir(db), "webhooks.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := webhooks.Register(httpserver.Webhook{URL: "https://example.com/hook"}); err != nil {
+			t.Fatal(err)
+		}
+
+		status, _, stderr := leaguectl(t, "", "-db", db, "win", "Cleo")
+		assertExit(t, status, 0, stderr)
+
+		webhooks, err = httpserver.NewWebhookDispatcher(filepath.Join(filepath.Dir(db), "webhooks.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending, _ := webhooks.Deliveries(10); len(pending) == 0 {
+			t.Error("got no deliveries queued for the win")
+		}
+	})
+
+	t.Run("shows the store's warnings but not each request", func(t *testing.T) {
+		db := newTestDatabase(t, `[{"Name": "Cleo", "Wins": 10}]`)
+
+		status, _, stderr := leaguectl(t, "", "-db", db, "win", "Cleo")
+		assertExit(t, status, 0, stderr)
+		if !strings.Contains(stderr, "migrated") || strings.Contains(stderr, "POST") {
+			t.Errorf("got %q want only the migration logged", stderr)
+		}
+	})
+
+	t.Run("won't open a database the server has open", func(t *testing.T) {
+		db := newTestDatabase(t, `[]`)
+		file, err := os.OpenFile(db, os.O_RDWR, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store, err := httpserver.NewFileSystemPlayerStore(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		status, _, stderr := leaguectl(t, "", "-db", db, "list")
+		assertExit(t, status, 1, stderr)
+	})
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{{}, {"frobnicate"}, {"score"}, {"add", "Cleo"}, {"add", "Cleo", "lots"}, {"list", "-o", "yaml"}} {
+		status, _, stderr := leaguectl(t, "", append([]string{"-server", "http://127.0.0.1:1"}, args...)...)
+		if status != 2 {
+			t.Errorf("%v: got exit status %d want 2, stderr: %s", args, status, stderr)
+		}
+	}
+}
+
+func TestServerDown(t *testing.T) {
+	url, _ := newTestServer(t, nil)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	status, _, stderr := leaguectl(t, "", "-server", down.URL, "-timeout", "5s", "ping")
+	assertExit(t, status, 1, stderr)
+	if !strings.Contains(stderr, "-db") {
+		t.Errorf("got %q want it to suggest -db", stderr)
+	}
+
+	status, _, stderr = leaguectl(t, "", "-server", url, "ping")
+	assertExit(t, status, 0, stderr)
+}
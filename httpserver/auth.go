@@ -0,0 +1,145 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSessionTTL is how long a token from /login lasts unless
+// PlayerServer.SessionTTL says otherwise
+const DefaultSessionTTL = 12 * time.Hour
+
+// sessionSweepInterval is how often expired tokens are looked for
+const sessionSweepInterval = time.Minute
+
+// session is who a token was issued to, and when it stops working
+type session struct {
+	username string
+	created  time.Time
+	expires  time.Time
+}
+
+// sessions maps the bearer tokens handed out by /login to usernames
+type sessions struct {
+	now func() time.Time
+
+	mu        sync.RWMutex
+	tokens    map[string]session
+	lastSweep time.Time
+}
+
+func newSessions() *sessions {
+	return &sessions{tokens: map[string]session{}, now: time.Now}
+}
+
+// issue creates a new token for username that lasts for ttl
+func (s *sessions) issue(username string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 16)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.sweep(now)
+	s.tokens[token] = session{username: username, created: now, expires: now.Add(ttl)}
+	return token, nil
+}
+
+// sweep forgets expired tokens, at most once every sessionSweepInterval
+func (s *sessions) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sessionSweepInterval {
+		return
+	}
+	for token, session := range s.tokens {
+		if !now.Before(session.expires) {
+			delete(s.tokens, token)
+		}
+	}
+	s.lastSweep = now
+}
+
+// lookup returns the user a token was issued to, if it hasn't expired
+func (s *sessions) lookup(token string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.tokens[token]
+	if !ok || !s.now().Before(session.expires) {
+		return "", false
+	}
+	return session.username, true
+}
+
+// revoke stops a token working, reporting whether it was a valid one
+func (s *sessions) revoke(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.tokens[token]
+	delete(s.tokens, token)
+	return ok && s.now().Before(session.expires)
+}
+
+// bearerToken pulls the token out of an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, prefix))
+}
+
+// IssueToken logs username in without a password. It is for code in the same
+// process that is already trusted, such as leaguectl working on the database
+// file directly.
+func (p *PlayerServer) IssueToken(username string) (string, error) {
+	return p.sessions.issue(username, p.SessionTTL)
+}
+
+// logoutHandler serves POST /logout, which stops the request's token working
+func (p *PlayerServer) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !p.sessions.revoke(bearerToken(r)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticatedUser returns the logged in user making the request, if any
+func (p *PlayerServer) authenticatedUser(r *http.Request) (string, bool) {
+	token := bearerToken(r)
+	if token == "" {
+		return "", false
+	}
+	return p.sessions.lookup(token)
+}
+
+// identity names the client behind a request: its user when it sent a valid
+// token, otherwise its remote IP
+func (p *PlayerServer) identity(r *http.Request) string {
+	if username, ok := p.authenticatedUser(r); ok {
+		return "user:" + username
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
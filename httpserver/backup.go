@@ -0,0 +1,380 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Snapshotter is implemented by stores that can copy out and replace their
+// whole contents while they are in use
+type Snapshotter interface {
+	Snapshot(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+const (
+	backupPrefix     = "league-"
+	backupSuffix     = ".json"
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+// BackupInfo describes one backup file
+type BackupInfo struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// BackupManager writes snapshots of a store to a directory, prunes old ones
+// and restores them
+type BackupManager struct {
+	Dir string
+	// Keep is how many backups to hold on to, 0 for no limit
+	Keep int
+	// MaxAge removes backups older than this, 0 for no limit. The newest backup is always kept.
+	MaxAge time.Duration
+
+	store Snapshotter
+	now   func() time.Time
+	mu    sync.Mutex
+}
+
+// NewBackupManager creates a BackupManager that keeps backups of store in dir
+func NewBackupManager(dir string, store Snapshotter) *BackupManager {
+	return &BackupManager{Dir: dir, store: store, now: time.Now}
+}
+
+// Create takes a consistent snapshot of the store and writes it to a new
+// backup file, then prunes old backups
+func (b *BackupManager) Create() (BackupInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := os.MkdirAll(b.Dir, 0700)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("problem creating backup directory %s, %v", b.Dir, err)
+	}
+
+	taken := b.now().UTC()
+	name := backupPrefix + taken.Format(backupTimeFormat) + backupSuffix
+
+	tmp, err := os.CreateTemp(b.Dir, ".backup-*")
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("problem creating backup in %s, %v", b.Dir, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = b.store.Snapshot(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("problem writing backup %s, %v", name, err)
+	}
+
+	// the rename means a backup is either complete or missing, never half written
+	err = os.Rename(tmp.Name(), filepath.Join(b.Dir, name))
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("problem saving backup %s, %v", name, err)
+	}
+
+	info, err := os.Stat(filepath.Join(b.Dir, name))
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	b.prune()
+	return BackupInfo{Name: name, Time: taken, Size: info.Size()}, nil
+}
+
+// List returns the backups in the directory, oldest first
+func (b *BackupManager) List() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(b.Dir)
+	if os.IsNotExist(err) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem listing backups in %s, %v", b.Dir, err)
+	}
+
+	backups := []BackupInfo{}
+	for _, entry := range entries {
+		taken, ok := backupTime(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{Name: entry.Name(), Time: taken, Size: info.Size()})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups, nil
+}
+
+// backupTime reads the time a backup was taken from its name
+func backupTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+		return time.Time{}, false
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix)
+	taken, err := time.Parse(backupTimeFormat, stamp)
+	return taken, err == nil
+}
+
+// prune applies Keep and MaxAge
+func (b *BackupManager) prune() {
+	backups, err := b.List()
+	if err != nil || len(backups) <= 1 {
+		return
+	}
+
+	cutoff := b.now().Add(-b.MaxAge)
+	for i, backup := range backups[:len(backups)-1] {
+		tooMany := b.Keep > 0 && len(backups)-i > b.Keep
+		tooOld := b.MaxAge > 0 && backup.Time.Before(cutoff)
+		if tooMany || tooOld {
+			err := os.Remove(filepath.Join(b.Dir, backup.Name))
+			if err != nil {
+				log.Printf("problem removing old backup %s, %v", backup.Name, err)
+			}
+		}
+	}
+}
+
+// Find picks a backup by name, or by time: the newest backup taken at or
+// before an RFC 3339 timestamp
+func (b *BackupManager) Find(nameOrTime string) (BackupInfo, error) {
+	backups, err := b.List()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	for _, backup := range backups {
+		if backup.Name == nameOrTime {
+			return backup, nil
+		}
+	}
+
+	at, err := time.Parse(time.RFC3339, nameOrTime)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("no backup called %q", nameOrTime)
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		if !backups[i].Time.After(at) {
+			return backups[i], nil
+		}
+	}
+	return BackupInfo{}, fmt.Errorf("no backup from before %s", at.Format(time.RFC3339))
+}
+
+// Restore rolls the store back to a backup, chosen as with Find
+func (b *BackupManager) Restore(nameOrTime string) (BackupInfo, error) {
+	backup, err := b.Find(nameOrTime)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	file, err := os.Open(filepath.Join(b.Dir, backup.Name))
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("problem opening backup %s, %v", backup.Name, err)
+	}
+	defer file.Close()
+
+	err = b.store.Restore(file)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("problem restoring backup %s, %v", backup.Name, err)
+	}
+	return backup, nil
+}
+
+// Rekey re-encrypts every backup with key, or decrypts them when key is nil,
+// so they can still be restored once the database key has been rotated. old
+// is the key they are encrypted with now. Backups already encrypted with key
+// are left alone, so a rotation that stopped part way can be run again.
+func (b *BackupManager) Rekey(old, key *DatabaseKey) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	backups, err := b.List()
+	if err != nil {
+		return 0, err
+	}
+
+	rekeyed := 0
+	for _, backup := range backups {
+		path := filepath.Join(b.Dir, backup.Name)
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return rekeyed, fmt.Errorf("problem reading backup %s, %v", backup.Name, err)
+		}
+		if key != nil && sealedWith(raw, key) {
+			continue
+		}
+
+		resealed, err := reseal(raw, old, key)
+		if err != nil {
+			return rekeyed, fmt.Errorf("problem re-encrypting backup %s, %w", backup.Name, err)
+		}
+		err = writeFileAtomic(path, resealed)
+		if err != nil {
+			return rekeyed, fmt.Errorf("problem re-encrypting backup %s, %v", backup.Name, err)
+		}
+		rekeyed++
+	}
+	return rekeyed, nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path,
+// so a crash leaves either the old or the new contents
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Schedule takes a backup every interval until the returned function is called
+func (b *BackupManager) Schedule(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				backup, err := b.Create()
+				if err != nil {
+					log.Printf("problem with scheduled backup, %v", err)
+					continue
+				}
+				log.Printf("scheduled backup %s written", backup.Name)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// backupHandler lists backups (GET), takes one (POST) or streams a snapshot
+// straight to the client (GET ?download=1)
+func (p *PlayerServer) backupHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+
+	if r.Method == http.MethodGet && r.URL.Query().Get("download") != "" {
+		snapshotter, ok := p.Store.(Snapshotter)
+		if !ok {
+			http.Error(w, "store does not support snapshots", http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("content-type", jsonContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s%s%s", backupPrefix, time.Now().UTC().Format(backupTimeFormat), backupSuffix))
+		err := snapshotter.Snapshot(w)
+		if err != nil {
+			log.Printf("problem streaming snapshot, %v", err)
+		}
+		return
+	}
+
+	if p.Backups == nil {
+		http.Error(w, "backups are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	var result interface{}
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		result, err = p.Backups.List()
+	case http.MethodPost:
+		result, err = p.Backups.Create()
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", jsonContentType)
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// restoreHandler rolls the league back to ?backup=name or ?at=timestamp
+func (p *PlayerServer) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if p.Backups == nil {
+		http.Error(w, "backups are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	target := r.URL.Query().Get("backup")
+	if target == "" {
+		target = r.URL.Query().Get("at")
+	}
+	if target == "" {
+		http.Error(w, "say which backup to restore with ?backup= or ?at=", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := p.Backups.Find(target); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var backup BackupInfo
+	var err error
+	p.trackChanges(&JournalEntry{Op: OpRestore, Actor: p.identity(r)}, func() {
+		backup, err = p.Backups.Restore(target)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", jsonContentType)
+	json.NewEncoder(w).Encode(backup)
+}
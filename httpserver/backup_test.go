@@ -0,0 +1,211 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestBackups(t testing.TB, store Snapshotter, clock *fakeClock) (*BackupManager, func()) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "backups")
+	assertNoError(t, err)
+
+	backups := NewBackupManager(dir, store)
+	backups.now = clock.now
+	return backups, func() { os.RemoveAll(dir) }
+}
+
+func TestBackupManager(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("restores an earlier backup by name", func(t *testing.T) {
+		_, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		backups, cleanBackups := newTestBackups(t, store, &fakeClock{current: start})
+		defer cleanBackups()
+
+		backup, err := backups.Create()
+		assertNoError(t, err)
+
+		store.RecordWin("Cleo")
+		store.DeletePlayer("Chris")
+
+		_, err = backups.Restore(backup.Name)
+		assertNoError(t, err)
+
+		want := League{{"Chris", 33}, {"Cleo", 10}}
+		if got := store.GetLeague(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("restores the newest backup before a time", func(t *testing.T) {
+		_, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		clock := &fakeClock{current: start}
+		backups, cleanBackups := newTestBackups(t, store, clock)
+		defer cleanBackups()
+
+		_, err := backups.Create()
+		assertNoError(t, err)
+
+		clock.advance(time.Hour)
+		store.RecordWin("Cleo")
+		_, err = backups.Create()
+		assertNoError(t, err)
+
+		clock.advance(time.Hour)
+		store.RecordWin("Cleo")
+		_, err = backups.Create()
+		assertNoError(t, err)
+
+		restored, err := backups.Restore(start.Add(90 * time.Minute).Format(time.RFC3339))
+		assertNoError(t, err)
+
+		if !restored.Time.Equal(start.Add(time.Hour)) {
+			t.Errorf("restored the backup from %v, want the one from %v", restored.Time, start.Add(time.Hour))
+		}
+		assertScoreEquals(t, store.GetPlayerScore("Cleo"), 11)
+
+		_, err = backups.Restore(start.Add(-time.Minute).Format(time.RFC3339))
+		if err == nil {
+			t.Error("expected an error restoring from before the first backup")
+		}
+	})
+
+	t.Run("keeps only the newest backups", func(t *testing.T) {
+		_, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		clock := &fakeClock{current: start}
+		backups, cleanBackups := newTestBackups(t, store, clock)
+		defer cleanBackups()
+		backups.Keep = 2
+
+		for i := 0; i < 4; i++ {
+			_, err := backups.Create()
+			assertNoError(t, err)
+			clock.advance(time.Minute)
+		}
+
+		list, err := backups.List()
+		assertNoError(t, err)
+		if len(list) != 2 {
+			t.Fatalf("got %d backups, want 2", len(list))
+		}
+		if !list[0].Time.Equal(start.Add(2 * time.Minute)) {
+			t.Errorf("oldest kept backup is from %v, want %v", list[0].Time, start.Add(2*time.Minute))
+		}
+	})
+
+	t.Run("removes backups older than MaxAge but never the newest", func(t *testing.T) {
+		_, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		clock := &fakeClock{current: start}
+		backups, cleanBackups := newTestBackups(t, store, clock)
+		defer cleanBackups()
+		backups.MaxAge = 24 * time.Hour
+
+		_, err := backups.Create()
+		assertNoError(t, err)
+		clock.advance(48 * time.Hour)
+		_, err = backups.Create()
+		assertNoError(t, err)
+
+		list, err := backups.List()
+		assertNoError(t, err)
+		if len(list) != 1 || !list[0].Time.Equal(start.Add(48*time.Hour)) {
+			t.Errorf("got %v, want only the newest backup", list)
+		}
+	})
+}
+
+func TestBackupEndpoints(t *testing.T) {
+	server, store, clean := newFileSystemServer(t, importTestLeague)
+	defer clean()
+	backups, cleanBackups := newTestBackups(t, store, &fakeClock{current: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)})
+	defer cleanBackups()
+
+	t.Run("returns 501 until backups are configured", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "/admin/backup", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, asAdmin(t, server, request))
+
+		assertStatus(t, response.Code, http.StatusNotImplemented)
+	})
+
+	server.Backups = backups
+	var created BackupInfo
+
+	t.Run("takes a backup on POST", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "/admin/backup", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, asAdmin(t, server, request))
+
+		assertStatus(t, response.Code, http.StatusCreated)
+		err := json.NewDecoder(response.Body).Decode(&created)
+		assertNoError(t, err)
+		if created.Name == "" || created.Size == 0 {
+			t.Errorf("got %+v, want a named, non-empty backup", created)
+		}
+	})
+
+	t.Run("lists backups on GET", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/admin/backup", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, asAdmin(t, server, request))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		var list []BackupInfo
+		err := json.NewDecoder(response.Body).Decode(&list)
+		assertNoError(t, err)
+		if len(list) != 1 || list[0].Name != created.Name {
+			t.Errorf("got %v, want just %s", list, created.Name)
+		}
+	})
+
+	t.Run("streams a snapshot on GET with download", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/admin/backup?download=1", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, asAdmin(t, server, request))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		db, _, err := loadDatabase(response.Body.Bytes())
+		assertNoError(t, err)
+		if len(db.League) != 2 {
+			t.Errorf("got %v, want the whole league", db.League)
+		}
+	})
+
+	t.Run("restores a backup", func(t *testing.T) {
+		store.DeletePlayer("Cleo")
+
+		request, _ := http.NewRequest(http.MethodPost, "/admin/restore?backup="+created.Name, nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, asAdmin(t, server, request))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		assertScoreEquals(t, store.GetPlayerScore("Cleo"), 10)
+		if entries := server.Journal.Entries(1); len(entries) != 1 || entries[0].Op != OpRestore {
+			t.Errorf("got journal %v, want a restore entry", entries)
+		}
+	})
+
+	t.Run("returns 404 for an unknown backup", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "/admin/restore?backup=nope.json", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, asAdmin(t, server, request))
+
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
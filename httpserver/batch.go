@@ -0,0 +1,214 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxBatchOperations stops one request holding the store lock for too long
+const maxBatchOperations = 1000
+
+// Operation is one change in a batch. Op is one of the Mutation kinds:
+// "win", "set", "delete" or "rename".
+type Operation struct {
+	Op   string `json:"op"`
+	Name string `json:"name"`
+	Wins int    `json:"wins,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// OperationResult reports what happened to one Operation in a batch
+type OperationResult struct {
+	Index  int     `json:"index"`
+	Op     string  `json:"op"`
+	Name   string  `json:"name"`
+	Status int     `json:"status"`
+	Player *Player `json:"player,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// BatchRequest is the body of POST /batch
+type BatchRequest struct {
+	Operations []Operation `json:"operations"`
+}
+
+// BatchResponse is returned by POST /batch
+type BatchResponse struct {
+	Applied bool              `json:"applied"`
+	Results []OperationResult `json:"results"`
+}
+
+// operationError is an operation that can't be applied, with the status to report it with
+type operationError struct {
+	status int
+	err    error
+}
+
+func (e *operationError) Error() string { return e.err.Error() }
+
+func newOperationError(status int, format string, args ...interface{}) error {
+	return &operationError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// errorStatus picks the HTTP status to report err with
+func errorStatus(err error) int {
+	switch e := err.(type) {
+	case *operationError:
+		return e.status
+	case *RuleError:
+		return e.Status
+	}
+	return http.StatusInternalServerError
+}
+
+func (o Operation) mutation() Mutation {
+	return Mutation{Kind: o.Op, Player: o.Name, Wins: o.Wins, NewName: o.To}
+}
+
+// applyOperation returns league with op applied, and the player it left behind.
+// With normalize set names are matched ignoring case.
+func applyOperation(league League, op Operation, normalize bool) (League, *Player, error) {
+	if strings.TrimSpace(op.Name) == "" {
+		return league, nil, newOperationError(http.StatusBadRequest, "name is empty")
+	}
+	if normalize {
+		op.Name = canonicalIn(league, op.Name)
+	}
+	player, idx := league.Find(op.Name)
+
+	switch op.Op {
+	case MutationWin:
+		if player == nil {
+			league = append(league, Player{op.Name, 1})
+			return league, &league[len(league)-1], nil
+		}
+		player.Wins++
+		return league, player, nil
+
+	case MutationSet:
+		if op.Wins < 0 {
+			return league, nil, newOperationError(http.StatusUnprocessableEntity, "wins can't be negative, got %d", op.Wins)
+		}
+		if player == nil {
+			league = append(league, Player{op.Name, op.Wins})
+			return league, &league[len(league)-1], nil
+		}
+		player.Wins = op.Wins
+		return league, player, nil
+
+	case MutationDelete:
+		if player == nil {
+			return league, nil, newOperationError(http.StatusNotFound, "player %s does not exist", op.Name)
+		}
+		return append(league[:idx], league[idx+1:]...), nil, nil
+
+	case MutationRename:
+		renamed, err := renamePlayer(league, op.Name, op.To, normalize)
+		if err != nil {
+			return league, nil, err
+		}
+		player, _ := renamed.Find(op.To)
+		return renamed, player, nil
+	}
+
+	return league, nil, newOperationError(http.StatusBadRequest, "unknown operation %q", op.Op)
+}
+
+// errBatchFailed stops UpdateLeague when any operation in a batch fails
+var errBatchFailed = errors.New("batch has failed operations")
+
+// batchHandler applies a list of operations to the store, all or none of them,
+// in a single write
+func (p *PlayerServer) batchHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batch BatchRequest
+	err := json.NewDecoder(r.Body).Decode(&batch)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("problem parsing batch, %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(batch.Operations) > maxBatchOperations {
+		http.Error(w, fmt.Sprintf("batch has %d operations, the limit is %d", len(batch.Operations), maxBatchOperations), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	updater, ok := p.Store.(LeagueUpdater)
+	if !ok {
+		http.Error(w, "store does not support batches", http.StatusNotImplemented)
+		return
+	}
+
+	results := make([]OperationResult, len(batch.Operations))
+	check := p.newRuleCheck()
+	journal := &JournalEntry{Op: "batch", Actor: p.identity(r)}
+	err = p.updateLeague(journal, updater, func(league League) (League, error) {
+		failed := false
+		for i, op := range batch.Operations {
+			result := OperationResult{Index: i, Op: op.Op, Name: op.Name, Status: http.StatusOK}
+			if p.NormalizeNames {
+				op.Name = canonicalIn(league, op.Name)
+				batch.Operations[i] = op
+			}
+
+			var player *Player
+			err := check.check(op.mutation(), league)
+			if err == nil {
+				league, player, err = applyOperation(league, op, p.NormalizeNames)
+			}
+
+			if err != nil {
+				failed = true
+				result.Status = errorStatus(err)
+				result.Error = err.Error()
+			} else if player != nil {
+				copied := *player
+				result.Player = &copied
+			}
+			if err == nil && op.Op == MutationRename {
+				journal.rename(op.Name, op.To)
+			}
+			results[i] = result
+		}
+
+		if failed {
+			return nil, errBatchFailed
+		}
+		return league, nil
+	})
+
+	if err != nil {
+		check.release()
+	}
+
+	response := BatchResponse{Applied: err == nil, Results: results}
+	status := http.StatusOK
+	switch err {
+	case nil:
+	case errBatchFailed:
+		status = http.StatusUnprocessableEntity
+		for i := range response.Results {
+			if response.Results[i].Error == "" {
+				// nothing was applied, so don't report the player it would have left
+				response.Results[i].Status = http.StatusFailedDependency
+				response.Results[i].Player = nil
+			}
+		}
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", jsonContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,154 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newBatchRequest(t testing.TB, operations ...Operation) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(BatchRequest{Operations: operations})
+	assertNoError(t, err)
+	req, _ := http.NewRequest(http.MethodPost, "/batch", bytes.NewReader(body))
+	return req
+}
+
+func getBatchResponse(t testing.TB, body io.Reader) (response BatchResponse) {
+	t.Helper()
+	err := json.NewDecoder(body).Decode(&response)
+	if err != nil {
+		t.Fatalf("Unable to parse batch response, %v", err)
+	}
+	return
+}
+
+func TestBatch(t *testing.T) {
+	t.Run("applies every operation in one go", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		request := newBatchRequest(t,
+			Operation{Op: "win", Name: "Cleo"},
+			Operation{Op: "win", Name: "Pepper"},
+			Operation{Op: "set", Name: "Floyd", Wins: 5},
+			Operation{Op: "rename", Name: "Chris", To: "Christopher"},
+			Operation{Op: "delete", Name: "Pepper"},
+		)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusOK)
+		got := getBatchResponse(t, response.Body)
+		if !got.Applied || len(got.Results) != 5 {
+			t.Fatalf("got %+v", got)
+		}
+		if got.Results[0].Player == nil || *got.Results[0].Player != (Player{"Cleo", 11}) {
+			t.Errorf("got first result %+v", got.Results[0])
+		}
+		assertLeague(t, store.GetLeague(), []Player{{"Christopher", 33}, {"Cleo", 11}, {"Floyd", 5}})
+	})
+
+	t.Run("counts wins earlier in the batch against the rules", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		server.Rules = []Rule{NewMaxWinsPerHour(2)}
+
+		request := newBatchRequest(t,
+			Operation{Op: "win", Name: "Cleo"},
+			Operation{Op: "win", Name: "Cleo"},
+			Operation{Op: "win", Name: "Cleo"},
+		)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		got := getBatchResponse(t, response.Body)
+		if got.Applied || got.Results[2].Status != http.StatusTooManyRequests {
+			t.Fatalf("got %+v want the third win blocked", got)
+		}
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+
+		// nothing was applied, so the wins it counted are given back
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newBatchRequest(t, Operation{Op: "win", Name: "Cleo"}, Operation{Op: "win", Name: "Cleo"}))
+		assertStatus(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("applies nothing if any operation fails", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		request := newBatchRequest(t,
+			Operation{Op: "win", Name: "Cleo"},
+			Operation{Op: "delete", Name: "Nobody"},
+			Operation{Op: "rename", Name: "Cleo", To: "Chris"},
+			Operation{Op: "explode", Name: "Cleo"},
+		)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		got := getBatchResponse(t, response.Body)
+		if got.Applied {
+			t.Errorf("expected nothing to be applied")
+		}
+
+		wantStatuses := []int{http.StatusFailedDependency, http.StatusNotFound, http.StatusConflict, http.StatusBadRequest}
+		for i, want := range wantStatuses {
+			if got.Results[i].Status != want {
+				t.Errorf("operation %d got status %d want %d (%s)", i, got.Results[i].Status, want, got.Results[i].Error)
+			}
+		}
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+	})
+
+	t.Run("runs every operation through the rules", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		server.Rules = []Rule{NamePolicy{DefaultNamePattern}}
+
+		request := newBatchRequest(t,
+			Operation{Op: "set", Name: "Pepper", Wins: 3},
+			Operation{Op: "rename", Name: "Cleo", To: "<Cleo>"},
+		)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		got := getBatchResponse(t, response.Body)
+		if got.Results[1].Status != http.StatusUnprocessableEntity {
+			t.Errorf("got rename result %+v", got.Results[1])
+		}
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+	})
+
+	t.Run("persists with a single write", func(t *testing.T) {
+		database, clean := createTempFile(t, importTestLeague)
+		defer clean()
+		store, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		server := NewPlayerServer(store)
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newBatchRequest(t, Operation{Op: "win", Name: "Chris"}, Operation{Op: "delete", Name: "Cleo"}))
+		assertStatus(t, response.Code, http.StatusOK)
+
+		reloaded, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		assertLeague(t, reloaded.GetLeague(), []Player{{"Chris", 34}})
+	})
+
+	t.Run("only accepts POST", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		request, _ := http.NewRequest(http.MethodGet, "/batch", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusMethodNotAllowed)
+	})
+}
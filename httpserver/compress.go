@@ -0,0 +1,276 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressionConfig controls gzip/deflate compression of responses
+type CompressionConfig struct {
+	// MinSize is the smallest body, in bytes, worth compressing. 0 compresses
+	// every body. NewPlayerServer sets it to defaultCompressMinSize.
+	MinSize int
+}
+
+// defaultCompressMinSize is roughly one network packet
+const defaultCompressMinSize = 1400
+
+// incompressibleTypes are content types that are already compressed
+var incompressibleTypes = []string{
+	"image/", "video/", "audio/",
+	"application/gzip", "application/zip", "application/x-gzip", "application/zstd",
+	"application/octet-stream", "font/woff",
+}
+
+var gzipPool = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+var deflatePool = sync.Pool{New: func() interface{} { return zlib.NewWriter(io.Discard) }}
+
+// compressor is what gzip.Writer and zlib.Writer have in common
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// returning "" when neither is acceptable
+func negotiateEncoding(acceptEncoding string) string {
+	parts := strings.Split(acceptEncoding, ",")
+	listed := map[string]bool{}
+	for _, part := range parts {
+		coding, _ := parseQuality(part)
+		listed[coding] = true
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range parts {
+		coding, q := parseQuality(part)
+		if q <= 0 {
+			continue
+		}
+
+		switch coding {
+		case "gzip", "deflate":
+		case "*":
+			// * only stands for codings that aren't listed, so one refused
+			// with q=0 stays refused
+			switch {
+			case !listed["gzip"]:
+				coding = "gzip"
+			case !listed["deflate"]:
+				coding = "deflate"
+			default:
+				continue
+			}
+		default:
+			continue
+		}
+		// prefer gzip when both have the same weight
+		if q > bestQ || (q == bestQ && coding == "gzip") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// parseQuality splits "gzip;q=0.8" into its value and weight
+func parseQuality(part string) (string, float64) {
+	fields := strings.Split(part, ";")
+	value := strings.ToLower(strings.TrimSpace(fields[0]))
+	q := 1.0
+	for _, param := range fields[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			parsed, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err == nil {
+				q = parsed
+			}
+		}
+	}
+	return value, q
+}
+
+func compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// body is big enough to be worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	writer  compressor
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	if c.decided {
+		if c.writer != nil {
+			return c.writer.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+
+	c.buf.Write(p)
+	if c.buf.Len() >= c.minSize {
+		err := c.start(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start sends the headers and anything buffered, compressed if allowed
+func (c *compressWriter) start(compress bool) error {
+	c.decided = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	header := c.Header()
+	if header.Get("Content-Type") == "" && c.buf.Len() > 0 {
+		header.Set("Content-Type", http.DetectContentType(c.buf.Bytes()))
+	}
+
+	if compress && c.shouldCompress() {
+		header.Set("Content-Encoding", c.encoding)
+		header.Del("Content-Length")
+		c.writer = c.acquire()
+	}
+
+	c.ResponseWriter.WriteHeader(c.status)
+	if c.buf.Len() == 0 {
+		return nil
+	}
+
+	var err error
+	if c.writer != nil {
+		_, err = c.writer.Write(c.buf.Bytes())
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf.Bytes())
+	}
+	c.buf.Reset()
+	return err
+}
+
+func (c *compressWriter) shouldCompress() bool {
+	header := c.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if c.status < http.StatusOK || c.status == http.StatusNoContent || c.status == http.StatusNotModified {
+		return false
+	}
+	return compressible(header.Get("Content-Type"))
+}
+
+func (c *compressWriter) acquire() compressor {
+	var w compressor
+	if c.encoding == "gzip" {
+		w = gzipPool.Get().(*gzip.Writer)
+	} else {
+		w = deflatePool.Get().(*zlib.Writer)
+	}
+	w.Reset(c.ResponseWriter)
+	return w
+}
+
+// Flush sends what has been written so far. Streaming responses are compressed
+// even when the first chunk is small, since more is on the way.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		c.start(c.buf.Len() > 0)
+	}
+	if c.writer != nil {
+		c.writer.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets protocol upgrades take over the connection
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := c.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	c.decided = true
+	return hijacker.Hijack()
+}
+
+// close finishes the response, writing small bodies uncompressed
+func (c *compressWriter) close() {
+	if !c.decided {
+		if c.status == 0 && c.buf.Len() == 0 {
+			// the handler wrote nothing, let net/http send its default response
+			return
+		}
+		c.start(false)
+	}
+	if c.writer == nil {
+		return
+	}
+
+	c.writer.Close()
+	if c.encoding == "gzip" {
+		gzipPool.Put(c.writer)
+	} else {
+		deflatePool.Put(c.writer)
+	}
+	c.writer = nil
+}
+
+// compress gzips or deflates responses for clients that accept it, according to p.Compression
+func (p *PlayerServer) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := p.Compression
+		// event streams are sent a few bytes at a time and must not wait in a buffer
+		streaming := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		if config == nil || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" || streaming {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: config.MinSize}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,151 @@
+package httpserver
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newBigLeague(size int) League {
+	league := League{}
+	for i := 0; i < size; i++ {
+		league = append(league, Player{fmt.Sprintf("Player%d", i), size - i})
+	}
+	return league
+}
+
+func newCompressedLeagueRequest(acceptEncoding string) *http.Request {
+	req := newLeagueRequest()
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	return req
+}
+
+func TestCompression(t *testing.T) {
+	wantedLeague := newBigLeague(200)
+	store := StubPlayerStore{league: wantedLeague}
+	server := NewPlayerServer(&store)
+
+	t.Run("gzips large responses", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newCompressedLeagueRequest("gzip"))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		assertHeader(t, response, "Content-Encoding", "gzip")
+		assertHeader(t, response, "Vary", "Accept-Encoding")
+		assertContentType(t, response, jsonContentType)
+
+		reader, err := gzip.NewReader(response.Body)
+		assertNoError(t, err)
+		assertLeague(t, getLeagueFromResponse(t, reader), wantedLeague)
+	})
+
+	t.Run("deflates when that is preferred", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newCompressedLeagueRequest("gzip;q=0.5, deflate"))
+
+		assertHeader(t, response, "Content-Encoding", "deflate")
+
+		reader, err := zlib.NewReader(response.Body)
+		assertNoError(t, err)
+		assertLeague(t, getLeagueFromResponse(t, reader), wantedLeague)
+	})
+
+	t.Run("doesn't compress for clients that don't ask", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newCompressedLeagueRequest("br, gzip;q=0"))
+
+		assertHeader(t, response, "Content-Encoding", "")
+		assertHeader(t, response, "Vary", "Accept-Encoding")
+		assertLeague(t, getLeagueFromResponse(t, response.Body), wantedLeague)
+	})
+
+	t.Run("skips small bodies", func(t *testing.T) {
+		store := newStore(map[string]int{"Pepper": 20})
+		server := NewPlayerServer(&store)
+		request := newGetScoreRequest("Pepper")
+		request.Header.Set("Accept-Encoding", "gzip")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertHeader(t, response, "Content-Encoding", "")
+		assertResponseBody(t, response.Body.String(), "20")
+	})
+
+	t.Run("compresses every body when MinSize is 0", func(t *testing.T) {
+		store := newStore(map[string]int{"Pepper": 20})
+		server := NewPlayerServer(&store)
+		server.Compression.MinSize = 0
+		request := newGetScoreRequest("Pepper")
+		request.Header.Set("Accept-Encoding", "gzip")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertHeader(t, response, "Content-Encoding", "gzip")
+	})
+
+	t.Run("skips already compressed content types", func(t *testing.T) {
+		server := &PlayerServer{Compression: &CompressionConfig{MinSize: 10}}
+		handler := server.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(make([]byte, 100))
+		}))
+		request := newCompressedLeagueRequest("gzip")
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, request)
+
+		assertHeader(t, response, "Content-Encoding", "")
+		if response.Body.Len() != 100 {
+			t.Errorf("got %d bytes want 100", response.Body.Len())
+		}
+	})
+
+	t.Run("compresses streamed responses as they are flushed", func(t *testing.T) {
+		server := &PlayerServer{Compression: &CompressionConfig{MinSize: 1 << 20}}
+		handler := server.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "first chunk\n")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, "second chunk\n")
+		}))
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newCompressedLeagueRequest("gzip"))
+
+		assertHeader(t, response, "Content-Encoding", "gzip")
+		if !response.Flushed {
+			t.Errorf("expected the response to be flushed")
+		}
+		reader, err := gzip.NewReader(response.Body)
+		assertNoError(t, err)
+		body, _ := io.ReadAll(reader)
+		assertResponseBody(t, string(body), "first chunk\nsecond chunk\n")
+	})
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                         "",
+		"gzip":                     "gzip",
+		"deflate":                  "deflate",
+		"deflate, gzip":            "gzip",
+		"gzip;q=0.2, deflate;q=1":  "deflate",
+		"*":                        "gzip",
+		"identity":                 "",
+		"gzip;q=0":                 "",
+		"gzip;q=0, *":              "deflate",
+		"*, gzip;q=0, deflate;q=0": "",
+	}
+
+	for header, want := range cases {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("Accept-Encoding %q got %q want %q", header, got, want)
+		}
+	}
+}
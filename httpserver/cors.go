@@ -0,0 +1,182 @@
+package httpserver
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig controls which browser origins may call the API.
+// Origins may be exact ("https://board.example.com"), a wildcard subdomain
+// ("https://*.example.com") or "*" for any origin.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// DefaultCORSMethods are allowed when CORSConfig.AllowedMethods is empty
+var DefaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// DefaultCORSHeaders are allowed when CORSConfig.AllowedHeaders is empty
+var DefaultCORSHeaders = []string{"Authorization", "Content-Type", "Accept", "If-Match"}
+
+// defaultExposedHeaders are response headers frontends are likely to want
+var defaultExposedHeaders = []string{"ETag", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "X-Blocked-By-Rule"}
+
+// Validate checks the config doesn't let every site make credentialed requests
+func (c *CORSConfig) Validate() error {
+	if !c.AllowCredentials {
+		return nil
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return errors.New(`CORS can't allow credentials from any origin ("*"), list the origins instead`)
+		}
+	}
+	return nil
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, if it
+// is allowed. Origins only allowed by "*" get a literal "*", which browsers
+// never send credentials with.
+func (c *CORSConfig) allowOrigin(origin string) (string, bool) {
+	anyOrigin := false
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			anyOrigin = true
+			continue
+		}
+		if strings.EqualFold(allowed, origin) || matchWildcardOrigin(allowed, origin) {
+			return origin, true
+		}
+	}
+	if anyOrigin {
+		return "*", true
+	}
+	return "", false
+}
+
+// matchWildcardOrigin matches "https://*.example.com" against any subdomain
+// of example.com on the same scheme (and port, if one is given)
+func matchWildcardOrigin(pattern, origin string) bool {
+	idx := strings.Index(pattern, "://*.")
+	if idx < 0 {
+		return false
+	}
+	scheme := pattern[:idx+len("://")]
+	suffix := pattern[idx+len("://*"):]
+
+	origin = strings.ToLower(origin)
+	if !strings.HasPrefix(origin, strings.ToLower(scheme)) {
+		return false
+	}
+	host := origin[len(scheme):]
+	return strings.HasSuffix(host, strings.ToLower(suffix)) && len(host) > len(suffix)
+}
+
+func (c *CORSConfig) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return DefaultCORSMethods
+	}
+	return c.AllowedMethods
+}
+
+func (c *CORSConfig) headers() []string {
+	if len(c.AllowedHeaders) == 0 {
+		return DefaultCORSHeaders
+	}
+	return c.AllowedHeaders
+}
+
+func (c *CORSConfig) methodAllowed(method string) bool {
+	for _, allowed := range c.methods() {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORSConfig) headersAllowed(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+
+		found := false
+		for _, allowed := range c.headers() {
+			if strings.EqualFold(allowed, header) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// cors answers preflight requests and adds CORS headers to responses for
+// allowed origins, according to p.CORS
+func (p *PlayerServer) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := p.CORS
+		origin := r.Header.Get("Origin")
+		if config == nil || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		allowOrigin, ok := config.allowOrigin(origin)
+		if !ok {
+			if preflight {
+				log.Println("rejected CORS preflight from", origin, r.URL)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		if config.AllowCredentials && allowOrigin != "*" {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			exposed := append(append([]string{}, defaultExposedHeaders...), config.ExposedHeaders...)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+		if !config.methodAllowed(r.Header.Get("Access-Control-Request-Method")) || !config.headersAllowed(requestedHeaders) {
+			log.Println("rejected CORS preflight for", r.Header.Get("Access-Control-Request-Method"), requestedHeaders, "from", origin)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(config.methods(), ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(config.headers(), ", "))
+		if config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
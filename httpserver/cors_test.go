@@ -0,0 +1,122 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newCORSServer() *PlayerServer {
+	store := newStore(map[string]int{"Pepper": 20})
+	server := NewPlayerServer(&store)
+	server.CORS = &CORSConfig{
+		AllowedOrigins:   []string{"https://board.example.com", "https://*.league.test"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+	return server
+}
+
+func newPreflightRequest(path, origin, method, headers string) *http.Request {
+	req, _ := http.NewRequest(http.MethodOptions, path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return req
+}
+
+func TestCORS(t *testing.T) {
+	t.Run("answers preflight requests on every route", func(t *testing.T) {
+		server := newCORSServer()
+
+		for _, path := range []string{"/list", "/login", "/store/Pepper", "/ping", "/readyz"} {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, newPreflightRequest(path, "https://board.example.com", http.MethodGet, "Authorization"))
+
+			assertStatus(t, response.Code, http.StatusNoContent)
+			assertHeader(t, response, "Access-Control-Allow-Origin", "https://board.example.com")
+			assertHeader(t, response, "Access-Control-Allow-Credentials", "true")
+			assertHeader(t, response, "Access-Control-Max-Age", "600")
+			assertHeader(t, response, "Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+		}
+	})
+
+	t.Run("matches wildcard subdomains", func(t *testing.T) {
+		server := newCORSServer()
+		cases := map[string]int{
+			"https://tv.league.test":    http.StatusNoContent,
+			"https://a.b.league.test":   http.StatusNoContent,
+			"https://league.test":       http.StatusForbidden,
+			"http://tv.league.test":     http.StatusForbidden,
+			"https://tv.notleague.test": http.StatusForbidden,
+			"https://evil.example.com":  http.StatusForbidden,
+		}
+
+		for origin, want := range cases {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, newPreflightRequest("/list", origin, http.MethodGet, ""))
+			if response.Code != want {
+				t.Errorf("origin %s got status %d want %d", origin, response.Code, want)
+			}
+		}
+	})
+
+	t.Run("rejects preflights for methods or headers that aren't allowed", func(t *testing.T) {
+		server := newCORSServer()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPreflightRequest("/store/Pepper", "https://board.example.com", "CONNECT", ""))
+		assertStatus(t, response.Code, http.StatusForbidden)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newPreflightRequest("/list", "https://board.example.com", http.MethodGet, "X-Secret"))
+		assertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("adds headers to actual requests from allowed origins", func(t *testing.T) {
+		server := newCORSServer()
+		request := newLeagueRequest()
+		request.Header.Set("Origin", "https://board.example.com")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusOK)
+		assertHeader(t, response, "Access-Control-Allow-Origin", "https://board.example.com")
+		assertHeader(t, response, "Vary", "Origin")
+	})
+
+	t.Run("leaves requests from other origins without CORS headers", func(t *testing.T) {
+		server := newCORSServer()
+		request := newLeagueRequest()
+		request.Header.Set("Origin", "https://evil.example.com")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertHeader(t, response, "Access-Control-Allow-Origin", "")
+	})
+
+	t.Run("never allows credentials from any origin", func(t *testing.T) {
+		server := newCORSServer()
+		server.CORS.AllowedOrigins = []string{"https://board.example.com", "*"}
+
+		if err := server.CORS.Validate(); err == nil {
+			t.Error("expected * with credentials to be rejected")
+		}
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPreflightRequest("/list", "https://evil.example.com", http.MethodGet, ""))
+		assertStatus(t, response.Code, http.StatusNoContent)
+		assertHeader(t, response, "Access-Control-Allow-Origin", "*")
+		assertHeader(t, response, "Access-Control-Allow-Credentials", "")
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newPreflightRequest("/list", "https://board.example.com", http.MethodGet, ""))
+		assertHeader(t, response, "Access-Control-Allow-Origin", "https://board.example.com")
+		assertHeader(t, response, "Access-Control-Allow-Credentials", "true")
+	})
+}
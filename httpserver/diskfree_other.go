@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package httpserver
+
+import "errors"
+
+// diskFree is not supported on this platform
+func diskFree(dir string) (uint64, error) {
+	return 0, errors.New("disk free space is not supported on this platform")
+}
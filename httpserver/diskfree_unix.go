@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package httpserver
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// diskFree returns the number of bytes available to unprivileged users in dir
+func diskFree(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, fmt.Errorf("problem reading disk usage for %s, %v", dir, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,197 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DatabaseKeyEnv is the environment variable LoadDatabaseKey falls back to
+const DatabaseKeyEnv = "LEAGUE_DB_KEY"
+
+const sealCipher = "AES-256-GCM"
+
+// ErrWrongKey is returned when an encrypted database cannot be opened with the key given
+var ErrWrongKey = errors.New("wrong database key")
+
+// DatabaseKey encrypts the database file with AES-256-GCM
+type DatabaseKey struct {
+	aead cipher.AEAD
+	id   string
+}
+
+// sealedDatabase is how an encrypted database is kept on disk. KeyID lets a
+// wrong key be reported as such rather than as a parse error.
+type sealedDatabase struct {
+	Cipher string `json:"cipher"`
+	KeyID  string `json:"key_id"`
+	Nonce  []byte `json:"nonce"`
+	Data   []byte `json:"data"`
+}
+
+// NewDatabaseKey makes a key from 32 random bytes
+func NewDatabaseKey(raw []byte) (*DatabaseKey, error) {
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("database key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &DatabaseKey{aead: aead, id: hex.EncodeToString(sum[:4])}, nil
+}
+
+// ParseDatabaseKey reads a key written as 64 hex characters or as base64
+func ParseDatabaseKey(text string) (*DatabaseKey, error) {
+	text = strings.TrimSpace(text)
+	raw, err := hex.DecodeString(text)
+	if err != nil {
+		raw, err = base64.StdEncoding.DecodeString(text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database key must be hex or base64")
+	}
+	return NewDatabaseKey(raw)
+}
+
+// LoadDatabaseKey reads the key from path, or from DatabaseKeyEnv when path is
+// empty. It returns nil when neither is set, meaning the database is not encrypted.
+func LoadDatabaseKey(path string) (*DatabaseKey, error) {
+	if path != "" {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("problem reading database key file %s, %v", path, err)
+		}
+		key, err := ParseDatabaseKey(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("problem with database key file %s, %v", path, err)
+		}
+		return key, nil
+	}
+
+	text := os.Getenv(DatabaseKeyEnv)
+	if text == "" {
+		return nil, nil
+	}
+	key, err := ParseDatabaseKey(text)
+	if err != nil {
+		return nil, fmt.Errorf("problem with %s, %v", DatabaseKeyEnv, err)
+	}
+	return key, nil
+}
+
+// GenerateDatabaseKey returns a new random key in hex
+func GenerateDatabaseKey() (string, error) {
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// ID is a short fingerprint of the key, safe to log
+func (k *DatabaseKey) ID() string {
+	return k.id
+}
+
+// seal encrypts plain, a whole database file
+func (k *DatabaseKey) seal(plain []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := json.Marshal(sealedDatabase{
+		Cipher: sealCipher,
+		KeyID:  k.id,
+		Nonce:  nonce,
+		Data:   k.aead.Seal(nil, nonce, plain, []byte(k.id)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(sealed, '\n'), nil
+}
+
+// isSealed reports whether raw is an encrypted database
+func isSealed(raw []byte) bool {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return false
+	}
+	var header struct {
+		Cipher string `json:"cipher"`
+	}
+	return json.Unmarshal(trimmed, &header) == nil && header.Cipher != ""
+}
+
+// unseal decrypts raw if it is encrypted, otherwise hands it back untouched
+func unseal(raw []byte, key *DatabaseKey) ([]byte, error) {
+	if !isSealed(raw) {
+		return raw, nil
+	}
+	if key == nil {
+		return nil, fmt.Errorf("database is encrypted, give a key with -db-key-file or %s", DatabaseKeyEnv)
+	}
+
+	var sealed sealedDatabase
+	err := json.Unmarshal(raw, &sealed)
+	if err != nil {
+		return nil, fmt.Errorf("problem parsing encrypted database, %v", err)
+	}
+	if sealed.Cipher != sealCipher {
+		return nil, fmt.Errorf("database is encrypted with %s, only %s is supported", sealed.Cipher, sealCipher)
+	}
+	if sealed.KeyID != key.id {
+		return nil, fmt.Errorf("%w: database was encrypted with key %s, the key given is %s", ErrWrongKey, sealed.KeyID, key.id)
+	}
+	if len(sealed.Nonce) != key.aead.NonceSize() {
+		return nil, fmt.Errorf("problem decrypting database, bad nonce")
+	}
+
+	plain, err := key.aead.Open(nil, sealed.Nonce, sealed.Data, []byte(sealed.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("problem decrypting database, it has been changed or damaged since it was written")
+	}
+	return plain, nil
+}
+
+// sealedWith reports whether raw is encrypted with key
+func sealedWith(raw []byte, key *DatabaseKey) bool {
+	if !isSealed(raw) {
+		return false
+	}
+	var header struct {
+		KeyID string `json:"key_id"`
+	}
+	return json.Unmarshal(raw, &header) == nil && header.KeyID == key.id
+}
+
+// reseal decrypts raw with old, if it is encrypted, and encrypts it again with
+// key. A nil key leaves it in plaintext.
+func reseal(raw []byte, old, key *DatabaseKey) ([]byte, error) {
+	plain, err := unseal(raw, old)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return plain, nil
+	}
+	return key.seal(plain)
+}
@@ -0,0 +1,222 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKey(t testing.TB) *DatabaseKey {
+	t.Helper()
+	text, err := GenerateDatabaseKey()
+	assertNoError(t, err)
+	key, err := ParseDatabaseKey(text)
+	assertNoError(t, err)
+	return key
+}
+
+func readDatabaseFile(t testing.TB, file *os.File) []byte {
+	t.Helper()
+	file.Seek(0, 0)
+	raw, err := io.ReadAll(file)
+	assertNoError(t, err)
+	return raw
+}
+
+func TestEncryptedFileSystemStore(t *testing.T) {
+	t.Run("does not leave player names on disk", func(t *testing.T) {
+		database, clean := createTempFile(t, "")
+		defer clean()
+		key := newTestKey(t)
+
+		store, err := NewEncryptedFileSystemPlayerStore(database, key)
+		assertNoError(t, err)
+		store.RecordWin("Cleo")
+
+		if raw := readDatabaseFile(t, database); bytes.Contains(raw, []byte("Cleo")) {
+			t.Errorf("found a player name in the encrypted file %s", raw)
+		}
+
+		reopened, err := NewEncryptedFileSystemPlayerStore(database, key)
+		assertNoError(t, err)
+		assertScoreEquals(t, reopened.GetPlayerScore("Cleo"), 1)
+	})
+
+	t.Run("encrypts a plaintext database when first given a key", func(t *testing.T) {
+		database, clean := createTempFile(t, `{"version": 1, "league": [{"Name": "Cleo", "Wins": 10}]}`)
+		defer clean()
+
+		store, err := NewEncryptedFileSystemPlayerStore(database, newTestKey(t))
+		assertNoError(t, err)
+
+		assertScoreEquals(t, store.GetPlayerScore("Cleo"), 10)
+		if !isSealed(readDatabaseFile(t, database)) {
+			t.Error("expected the database to be encrypted")
+		}
+	})
+
+	t.Run("says when the key is wrong", func(t *testing.T) {
+		database, clean := createTempFile(t, "")
+		defer clean()
+
+		_, err := NewEncryptedFileSystemPlayerStore(database, newTestKey(t))
+		assertNoError(t, err)
+
+		_, err = NewEncryptedFileSystemPlayerStore(database, newTestKey(t))
+		if !errors.Is(err, ErrWrongKey) {
+			t.Errorf("got %v, want %v", err, ErrWrongKey)
+		}
+	})
+
+	t.Run("asks for a key when there is none", func(t *testing.T) {
+		database, clean := createTempFile(t, "")
+		defer clean()
+
+		_, err := NewEncryptedFileSystemPlayerStore(database, newTestKey(t))
+		assertNoError(t, err)
+
+		_, err = NewFileSystemPlayerStore(database)
+		if err == nil || !strings.Contains(err.Error(), "encrypted") {
+			t.Errorf("got %v, want an error saying the database is encrypted", err)
+		}
+	})
+
+	t.Run("notices a tampered file", func(t *testing.T) {
+		database, clean := createTempFile(t, "")
+		defer clean()
+		key := newTestKey(t)
+
+		store, err := NewEncryptedFileSystemPlayerStore(database, key)
+		assertNoError(t, err)
+		store.RecordWin("Cleo")
+
+		raw := readDatabaseFile(t, database)
+		// flip a character inside the base64 ciphertext
+		at := bytes.Index(raw, []byte(`"data":"`)) + len(`"data":"`) + 4
+		if raw[at] == 'A' {
+			raw[at] = 'B'
+		} else {
+			raw[at] = 'A'
+		}
+		database.WriteAt(raw, 0)
+
+		_, err = NewEncryptedFileSystemPlayerStore(database, key)
+		if err == nil || errors.Is(err, ErrWrongKey) {
+			t.Errorf("got %v, want an error about the file being changed", err)
+		}
+	})
+
+	t.Run("re-encrypts with a new key", func(t *testing.T) {
+		database, clean := createTempFile(t, "")
+		defer clean()
+		oldKey, newKey := newTestKey(t), newTestKey(t)
+
+		store, err := NewEncryptedFileSystemPlayerStore(database, oldKey)
+		assertNoError(t, err)
+		store.RecordWin("Cleo")
+
+		assertNoError(t, store.Rekey(newKey))
+
+		_, err = NewEncryptedFileSystemPlayerStore(database, oldKey)
+		if !errors.Is(err, ErrWrongKey) {
+			t.Errorf("old key: got %v, want %v", err, ErrWrongKey)
+		}
+		reopened, err := NewEncryptedFileSystemPlayerStore(database, newKey)
+		assertNoError(t, err)
+		assertScoreEquals(t, reopened.GetPlayerScore("Cleo"), 1)
+	})
+
+	t.Run("re-encrypts backups so they can still be restored", func(t *testing.T) {
+		database, clean := createTempFile(t, "")
+		defer clean()
+		oldKey, newKey := newTestKey(t), newTestKey(t)
+
+		store, err := NewEncryptedFileSystemPlayerStore(database, oldKey)
+		assertNoError(t, err)
+		store.RecordWin("Cleo")
+		backups, cleanBackups := newTestBackups(t, store, &fakeClock{current: time.Now()})
+		defer cleanBackups()
+		backup, err := backups.Create()
+		assertNoError(t, err)
+		store.RecordWin("Chris")
+
+		rekeyed, err := backups.Rekey(oldKey, newKey)
+		assertNoError(t, err)
+		assertNoError(t, store.Rekey(newKey))
+		if rekeyed != 1 {
+			t.Errorf("got %d backups re-encrypted want 1", rekeyed)
+		}
+
+		_, err = backups.Restore(backup.Name)
+		assertNoError(t, err)
+		assertLeague(t, store.GetLeague(), []Player{{"Cleo", 1}})
+
+		rekeyed, err = backups.Rekey(oldKey, newKey)
+		assertNoError(t, err)
+		if rekeyed != 0 {
+			t.Errorf("got %d backups re-encrypted a second time want 0", rekeyed)
+		}
+	})
+
+	t.Run("encrypts the copy kept before migrating a plaintext database", func(t *testing.T) {
+		database, clean := createTempFile(t, `[{"Name": "Cleo", "Wins": 10}]`)
+		defer clean()
+		key := newTestKey(t)
+
+		_, err := NewEncryptedFileSystemPlayerStore(database, key)
+		assertNoError(t, err)
+
+		copies, _ := filepath.Glob(database.Name() + ".*.bak")
+		if len(copies) != 1 {
+			t.Fatalf("got copies %v want one", copies)
+		}
+		raw, err := os.ReadFile(copies[0])
+		assertNoError(t, err)
+		if !sealedWith(raw, key) || bytes.Contains(raw, []byte("Cleo")) {
+			t.Errorf("got copy %s want it encrypted", raw)
+		}
+	})
+}
+
+func TestLoadDatabaseKey(t *testing.T) {
+	t.Run("is nil without a file or environment variable", func(t *testing.T) {
+		t.Setenv(DatabaseKeyEnv, "")
+		key, err := LoadDatabaseKey("")
+		assertNoError(t, err)
+		if key != nil {
+			t.Error("expected no key")
+		}
+	})
+
+	t.Run("reads the environment variable", func(t *testing.T) {
+		text, _ := GenerateDatabaseKey()
+		t.Setenv(DatabaseKeyEnv, text)
+		key, err := LoadDatabaseKey("")
+		assertNoError(t, err)
+		if key == nil {
+			t.Error("expected a key")
+		}
+	})
+
+	t.Run("prefers the key file", func(t *testing.T) {
+		t.Setenv(DatabaseKeyEnv, "not a key")
+		text, _ := GenerateDatabaseKey()
+		file, clean := createTempFile(t, text+"\n")
+		defer clean()
+
+		_, err := LoadDatabaseKey(file.Name())
+		assertNoError(t, err)
+	})
+
+	t.Run("rejects short keys", func(t *testing.T) {
+		_, err := ParseDatabaseKey("abcd")
+		if err == nil {
+			t.Error("expected an error for a short key")
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// StatsStore is implemented by stores that remember each player's history
+type StatsStore interface {
+	PlayerStats(name string) (PlayerStats, bool)
+	PlayerHistory(name string) []DomainEvent
+}
+
+// EventSourcedPlayerStore is a PlayerStore that records every change as a
+// DomainEvent and answers questions from projections of those events.
+//
+// It is an alternative to FileSystemPlayerStore, which stays the default and
+// is not built from events. Unlike FileSystemPlayerStore it keeps no
+// profiles, can't be backed up or checked with fsck, and its log is not
+// encrypted.
+type EventSourcedPlayerStore struct {
+	events  *EventStore
+	league  *LeagueProjection
+	stats   *StatsProjection
+	history *HistoryProjection
+}
+
+// NewEventSourcedPlayerStore replays log to build the league
+func NewEventSourcedPlayerStore(log EventLog) (*EventSourcedPlayerStore, error) {
+	store := &EventSourcedPlayerStore{
+		league:  &LeagueProjection{},
+		stats:   &StatsProjection{},
+		history: &HistoryProjection{},
+	}
+
+	events, err := NewEventStore(log, store.league, store.stats, store.history)
+	if err != nil {
+		return nil, err
+	}
+	store.events = events
+	return store, nil
+}
+
+// Events returns the underlying EventStore, so more projections can be added
+func (e *EventSourcedPlayerStore) Events() *EventStore {
+	return e.events
+}
+
+// Rebuild replays every event into fresh projections
+func (e *EventSourcedPlayerStore) Rebuild() error {
+	return e.events.Rebuild()
+}
+
+func (e *EventSourcedPlayerStore) GetPlayerScore(name string) int {
+	var wins int
+	e.events.View(func() {
+		player, _ := e.league.Find(name)
+		wins = player.Wins
+	})
+	return wins
+}
+
+func (e *EventSourcedPlayerStore) GetLeague() League {
+	var league League
+	e.events.View(func() {
+		league = e.league.League()
+	})
+	return league
+}
+
+func (e *EventSourcedPlayerStore) RecordWin(name string) {
+	logError(e.TryRecordWin(name))
+}
+
+func (e *EventSourcedPlayerStore) RecordNewPlayer(player Player) {
+	logError(e.TryRecordNewPlayer(player))
+}
+
+func (e *EventSourcedPlayerStore) DeletePlayer(name string) {
+	logError(e.TryDeletePlayer(name))
+}
+
+// TryRecordWin is RecordWin, returning the error if the event couldn't be appended to the log
+func (e *EventSourcedPlayerStore) TryRecordWin(name string) error {
+	return e.execute(func() []DomainEvent {
+		events := []DomainEvent{}
+		if _, ok := e.league.Find(name); !ok {
+			events = append(events, DomainEvent{Type: PlayerCreated, Player: name})
+		}
+		return append(events, DomainEvent{Type: WinRecorded, Player: name})
+	})
+}
+
+// TryRecordNewPlayer is RecordNewPlayer, returning the error if the event couldn't be appended to the log
+func (e *EventSourcedPlayerStore) TryRecordNewPlayer(player Player) error {
+	return e.execute(func() []DomainEvent {
+		if _, ok := e.league.Find(player.Name); ok {
+			return []DomainEvent{{Type: PlayerOverwritten, Player: player.Name, Wins: player.Wins}}
+		}
+		return []DomainEvent{{Type: PlayerCreated, Player: player.Name, Wins: player.Wins}}
+	})
+}
+
+// TryDeletePlayer is DeletePlayer, returning the error if the event couldn't be appended to the log
+func (e *EventSourcedPlayerStore) TryDeletePlayer(name string) error {
+	return e.execute(func() []DomainEvent {
+		if _, ok := e.league.Find(name); !ok {
+			log.Printf("player cound not be found, and deleted: %s", name)
+			return nil
+		}
+		return []DomainEvent{{Type: PlayerDeleted, Player: name}}
+	})
+}
+
+// UpdateLeague records the difference between the league and the result of
+// update as events. If update returns an error nothing is recorded.
+func (e *EventSourcedPlayerStore) UpdateLeague(update func(League) (League, error)) error {
+	return e.UpdateLeagueRenaming(func(league League) (League, map[string]string, error) {
+		league, err := update(league)
+		return league, nil, err
+	})
+}
+
+// UpdateLeagueRenaming is UpdateLeague for changes that may rename players.
+// update also returns the renames it made, new name to old, which are
+// recorded as PlayerRenamed so the players keep their stats and history.
+func (e *EventSourcedPlayerStore) UpdateLeagueRenaming(update func(League) (League, map[string]string, error)) error {
+	_, err := e.events.Execute(func() ([]DomainEvent, error) {
+		before := e.league.League()
+		after, renamed, err := update(append(League{}, before...))
+		if err != nil {
+			return nil, err
+		}
+		return leagueEvents(before, after, renamed), nil
+	})
+	return err
+}
+
+// leagueEvents are the events that turn before into after, where renamed maps
+// new names to old ones. Deletions come first and renames next, so names
+// are free by the time they are reused.
+func leagueEvents(before, after League, renamed map[string]string) []DomainEvent {
+	renamedAway := make(map[string]bool, len(renamed))
+	for _, from := range renamed {
+		renamedAway[from] = true
+	}
+
+	events := []DomainEvent{}
+	for _, player := range before {
+		if found, _ := after.Find(player.Name); found == nil && !renamedAway[player.Name] {
+			events = append(events, DomainEvent{Type: PlayerDeleted, Player: player.Name})
+		}
+	}
+	for _, player := range after {
+		if from, ok := renamed[player.Name]; ok {
+			events = append(events, DomainEvent{Type: PlayerRenamed, Player: player.Name, From: from})
+		}
+	}
+	for _, player := range after {
+		name := player.Name
+		if from, ok := renamed[player.Name]; ok {
+			name = from
+		}
+		found, _ := before.Find(name)
+		switch {
+		case found == nil || (renamedAway[name] && name == player.Name):
+			events = append(events, DomainEvent{Type: PlayerCreated, Player: player.Name, Wins: player.Wins})
+		case found.Wins != player.Wins:
+			events = append(events, DomainEvent{Type: PlayerOverwritten, Player: player.Name, Wins: player.Wins})
+		}
+	}
+	return events
+}
+
+// PlayerStats returns what is known about a player, even after they are deleted
+func (e *EventSourcedPlayerStore) PlayerStats(name string) (PlayerStats, bool) {
+	var stats PlayerStats
+	var ok bool
+	e.events.View(func() {
+		stats, ok = e.stats.Stats(name)
+	})
+	return stats, ok
+}
+
+// PlayerHistory returns every event for a player, oldest first
+func (e *EventSourcedPlayerStore) PlayerHistory(name string) []DomainEvent {
+	var history []DomainEvent
+	e.events.View(func() {
+		history = e.history.History(name)
+	})
+	return history
+}
+
+// Close closes the event log if it needs closing
+func (e *EventSourcedPlayerStore) Close() error {
+	if closer, ok := e.events.log.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// execute records the events decide returns
+func (e *EventSourcedPlayerStore) execute(decide func() []DomainEvent) error {
+	_, err := e.events.Execute(func() ([]DomainEvent, error) {
+		return decide(), nil
+	})
+	return err
+}
+
+// logError logs err from a PlayerStore method, which has no way to report it
+func logError(err error) {
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// PlayerStatsResponse is the body of GET /players/{name}/stats
+type PlayerStatsResponse struct {
+	PlayerStats
+	History []DomainEvent `json:"history,omitempty"`
+}
+
+// statsHandler serves GET /players/{name}/stats, with ?history=1 adding
+// every event for the player
+func (p *PlayerServer) statsHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	store, ok := p.Store.(StatsStore)
+	if !ok {
+		http.Error(w, "store does not keep player stats", http.StatusNotImplemented)
+		return
+	}
+	stats, ok := store.PlayerStats(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("player %s has never played", name), http.StatusNotFound)
+		return
+	}
+
+	response := PlayerStatsResponse{PlayerStats: stats}
+	if r.URL.Query().Get("history") == "1" {
+		response.History = store.PlayerHistory(name)
+	}
+	w.Header().Set("content-type", jsonContentType)
+	json.NewEncoder(w).Encode(response)
+}
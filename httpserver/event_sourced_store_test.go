@@ -0,0 +1,210 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newEventSourcedStore(t testing.TB) *EventSourcedPlayerStore {
+	t.Helper()
+	store, err := NewEventSourcedPlayerStore(&MemoryEventLog{})
+	assertNoError(t, err)
+	return store
+}
+
+// failingEventLog is a MemoryEventLog that can't be appended to
+type failingEventLog struct {
+	MemoryEventLog
+}
+
+func (f *failingEventLog) Append(events ...DomainEvent) error {
+	return errors.New("disk full")
+}
+
+func TestEventSourcedPlayerStore(t *testing.T) {
+	t.Run("records wins as events", func(t *testing.T) {
+		store := newEventSourcedStore(t)
+		store.RecordWin("Cleo")
+		store.RecordWin("Cleo")
+		store.RecordNewPlayer(Player{"Chris", 33})
+
+		assertScoreEquals(t, store.GetPlayerScore("Cleo"), 2)
+		assertLeague(t, store.GetLeague(), League{{"Chris", 33}, {"Cleo", 2}})
+
+		var types []string
+		for _, event := range store.PlayerHistory("Cleo") {
+			types = append(types, event.Type)
+		}
+		if len(types) != 3 || types[0] != PlayerCreated || types[2] != WinRecorded {
+			t.Errorf("got %v want PlayerCreated then two WinRecorded", types)
+		}
+	})
+
+	t.Run("overwrites existing players", func(t *testing.T) {
+		store := newEventSourcedStore(t)
+		store.RecordNewPlayer(Player{"Cleo", 3})
+		store.RecordNewPlayer(Player{"Cleo", 7})
+
+		assertLeague(t, store.GetLeague(), League{{"Cleo", 7}})
+		stats, _ := store.PlayerStats("Cleo")
+		if stats.Overwrites != 1 {
+			t.Errorf("got %d overwrites want 1", stats.Overwrites)
+		}
+	})
+
+	t.Run("keeps stats for deleted players", func(t *testing.T) {
+		store := newEventSourcedStore(t)
+		store.RecordWin("Cleo")
+		store.DeletePlayer("Cleo")
+		store.DeletePlayer("Nobody")
+
+		assertLeague(t, store.GetLeague(), League{})
+		stats, ok := store.PlayerStats("Cleo")
+		if !ok || !stats.Deleted || stats.WinsRecorded != 1 {
+			t.Errorf("got %+v want deleted Cleo with one recorded win", stats)
+		}
+		if _, ok := store.PlayerStats("Nobody"); ok {
+			t.Error("deleting a missing player should not record anything")
+		}
+	})
+
+	t.Run("turns league updates into events", func(t *testing.T) {
+		store := newEventSourcedStore(t)
+		store.RecordNewPlayer(Player{"Cleo", 10})
+		store.RecordNewPlayer(Player{"Chris", 33})
+
+		err := store.UpdateLeague(func(league League) (League, error) {
+			return League{{"Chris", 40}, {"Cleopatra", 10}}, nil
+		})
+		assertNoError(t, err)
+		assertLeague(t, store.GetLeague(), League{{"Chris", 40}, {"Cleopatra", 10}})
+
+		events, _ := store.Events().Events(2)
+		var types []string
+		for _, event := range events {
+			types = append(types, event.Type+" "+event.Player)
+		}
+		want := []string{"PlayerDeleted Cleo", "PlayerOverwritten Chris", "PlayerCreated Cleopatra"}
+		if len(types) != len(want) || types[0] != want[0] || types[1] != want[1] || types[2] != want[2] {
+			t.Errorf("got %v want %v", types, want)
+		}
+	})
+
+	t.Run("records renames so stats and history carry over", func(t *testing.T) {
+		store := newEventSourcedStore(t)
+		store.RecordWin("Cleo")
+		store.RecordWin("Cleo")
+		server := NewPlayerServer(store)
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/rename", `{"from": "Cleo", "to": "Cleopatra"}`)))
+		assertStatus(t, response.Code, http.StatusOK)
+		store.RecordWin("Cleo")
+
+		assertLeague(t, store.GetLeague(), League{{"Cleopatra", 2}, {"Cleo", 1}})
+		stats, _ := store.PlayerStats("Cleopatra")
+		if stats.WinsRecorded != 2 || len(stats.PreviousNames) != 1 || stats.PreviousNames[0] != "Cleo" {
+			t.Errorf("got %+v want Cleo's two wins carried over", stats)
+		}
+		history := store.PlayerHistory("Cleopatra")
+		if len(history) != 4 || history[3].Type != PlayerRenamed || history[3].From != "Cleo" {
+			t.Errorf("got %+v want Cleo's history then the rename", history)
+		}
+		if stats, _ := store.PlayerStats("Cleo"); stats.WinsRecorded != 1 {
+			t.Errorf("got %+v want the new Cleo to start afresh", stats)
+		}
+
+		before := store.GetLeague()
+		assertNoError(t, store.Rebuild())
+		assertLeague(t, store.GetLeague(), before)
+	})
+
+	t.Run("records nothing when a league update fails", func(t *testing.T) {
+		store := newEventSourcedStore(t)
+		store.RecordNewPlayer(Player{"Cleo", 10})
+
+		failed := errors.New("no thanks")
+		err := store.UpdateLeague(func(league League) (League, error) {
+			return League{}, failed
+		})
+		if err != failed {
+			t.Errorf("got %v want %v", err, failed)
+		}
+		assertLeague(t, store.GetLeague(), League{{"Cleo", 10}})
+	})
+
+	t.Run("rebuilds to the same league", func(t *testing.T) {
+		store := newEventSourcedStore(t)
+		store.RecordWin("Cleo")
+		store.RecordNewPlayer(Player{"Chris", 33})
+		before := store.GetLeague()
+
+		assertNoError(t, store.Rebuild())
+		assertLeague(t, store.GetLeague(), before)
+	})
+
+	t.Run("serves the player API", func(t *testing.T) {
+		server := NewPlayerServer(newEventSourcedStore(t))
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Pepper"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Pepper"))
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newGetScoreRequest("Pepper"))
+		assertStatus(t, response.Code, http.StatusOK)
+		assertResponseBody(t, response.Body.String(), "2")
+	})
+
+	t.Run("reports changes that couldn't be appended to the log", func(t *testing.T) {
+		store, err := NewEventSourcedPlayerStore(&failingEventLog{})
+		assertNoError(t, err)
+		server := NewPlayerServer(store)
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPostWinRequest("Pepper"))
+
+		assertStatus(t, response.Code, http.StatusBadGateway)
+		assertLeague(t, store.GetLeague(), League{})
+	})
+}
+
+func TestPlayerStats(t *testing.T) {
+	t.Run("returns stats and history", func(t *testing.T) {
+		store := newEventSourcedStore(t)
+		store.RecordWin("Cleo")
+		store.RecordWin("Cleo")
+		server := NewPlayerServer(store)
+
+		request, _ := http.NewRequest(http.MethodGet, "/players/Cleo/stats?history=1", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		var got PlayerStatsResponse
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&got))
+		if got.Name != "Cleo" || got.Wins != 2 || got.WinsRecorded != 2 || len(got.History) != 3 {
+			t.Errorf("got %+v want Cleo with 2 wins and 3 events", got)
+		}
+	})
+
+	t.Run("returns 404 for players who never played", func(t *testing.T) {
+		server := NewPlayerServer(newEventSourcedStore(t))
+
+		request, _ := http.NewRequest(http.MethodGet, "/players/Nobody/stats", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("returns 501 when the store keeps no stats", func(t *testing.T) {
+		server := NewPlayerServer(&StubPlayerStore{})
+
+		request, _ := http.NewRequest(http.MethodGet, "/players/Cleo/stats", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNotImplemented)
+	})
+}
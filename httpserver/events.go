@@ -0,0 +1,324 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Types of Event
+const (
+	EventPlayerCreated   = "player_created"
+	EventWinRecorded     = "win_recorded"
+	EventScoreChanged    = "score_changed"
+	EventPlayerDeleted   = "player_deleted"
+	EventLeagueReordered = "league_reordered"
+	// EventLeaderChanged is sent when a different player takes first place
+	EventLeaderChanged = "leader_changed"
+	// EventReset tells a client it missed events and should reload the league
+	EventReset = "reset"
+)
+
+const (
+	defaultEventBufferSize = 1000
+	defaultEventHeartbeat  = 15 * time.Second
+	// subscriberQueueSize is how far a client can fall behind before it is dropped
+	subscriberQueueSize = 64
+)
+
+// Event is a change to the league
+type Event struct {
+	ID     int       `json:"id"`
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Player string    `json:"player,omitempty"`
+	Wins   int       `json:"wins"`
+	// League is the new order of the league for EventLeagueReordered
+	League []Player `json:"league,omitempty"`
+}
+
+// diffLeague works out the events that turn before into after
+func diffLeague(before, after League) []Event {
+	events := []Event{}
+	for _, player := range after {
+		old, _ := before.Find(player.Name)
+		switch {
+		case old == nil:
+			events = append(events, Event{Type: EventPlayerCreated, Player: player.Name, Wins: player.Wins})
+		case player.Wins == old.Wins+1:
+			events = append(events, Event{Type: EventWinRecorded, Player: player.Name, Wins: player.Wins})
+		case player.Wins != old.Wins:
+			events = append(events, Event{Type: EventScoreChanged, Player: player.Name, Wins: player.Wins})
+		}
+	}
+	for _, player := range before {
+		if found, _ := after.Find(player.Name); found == nil {
+			events = append(events, Event{Type: EventPlayerDeleted, Player: player.Name})
+		}
+	}
+
+	if reordered(before, after) {
+		events = append(events, Event{Type: EventLeagueReordered, League: append(League{}, after...)})
+	}
+	if len(after) > 0 && (len(before) == 0 || before[0].Name != after[0].Name) {
+		events = append(events, Event{Type: EventLeaderChanged, Player: after[0].Name, Wins: after[0].Wins})
+	}
+	return events
+}
+
+// reordered reports whether the players in both leagues are in a different order
+func reordered(before, after League) bool {
+	var was, is []string
+	for _, player := range before {
+		if found, _ := after.Find(player.Name); found != nil {
+			was = append(was, player.Name)
+		}
+	}
+	for _, player := range after {
+		if found, _ := before.Find(player.Name); found != nil {
+			is = append(is, player.Name)
+		}
+	}
+	for i := range was {
+		if was[i] != is[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriber is one client listening for events
+type subscriber struct {
+	events chan Event
+}
+
+// eventBroker numbers events, keeps the most recent ones so clients can
+// resume, and fans them out to subscribers
+type eventBroker struct {
+	mu          sync.Mutex
+	nextID      int
+	size        int
+	buffer      []Event
+	subscribers map[*subscriber]bool
+	closed      bool
+	heartbeat   time.Duration
+	now         func() time.Time
+}
+
+func newEventBroker(size int) *eventBroker {
+	return &eventBroker{
+		nextID:      1,
+		size:        size,
+		subscribers: map[*subscriber]bool{},
+		heartbeat:   defaultEventHeartbeat,
+		now:         time.Now,
+	}
+}
+
+// publish sends events to every subscriber. A subscriber that can't keep up
+// is dropped rather than holding up everyone else, it can resume from the buffer.
+// It returns the events numbered and timestamped.
+func (b *eventBroker) publish(events ...Event) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	published := make([]Event, 0, len(events))
+	for _, event := range events {
+		event.ID = b.nextID
+		event.Time = b.now()
+		b.nextID++
+		published = append(published, event)
+
+		b.buffer = append(b.buffer, event)
+		if len(b.buffer) > b.size {
+			b.buffer = append([]Event{}, b.buffer[len(b.buffer)-b.size:]...)
+		}
+
+		for sub := range b.subscribers {
+			select {
+			case sub.events <- event:
+			default:
+				log.Printf("dropping event subscriber that fell %d events behind", subscriberQueueSize)
+				delete(b.subscribers, sub)
+				close(sub.events)
+			}
+		}
+	}
+	return published
+}
+
+// subscribe starts listening after the event lastID. It returns the buffered
+// events since then, and false if some have already been forgotten.
+func (b *eventBroker) subscribe(lastID int) (*subscriber, []Event, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, nil, false, fmt.Errorf("server is shutting down")
+	}
+
+	complete := true
+	backlog := []Event{}
+	if lastID > 0 {
+		oldest := b.nextID
+		if len(b.buffer) > 0 {
+			oldest = b.buffer[0].ID
+		}
+		complete = lastID >= oldest-1 && lastID < b.nextID
+		for _, event := range b.buffer {
+			if event.ID > lastID {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	sub := &subscriber{events: make(chan Event, subscriberQueueSize)}
+	b.subscribers[sub] = true
+	return sub, backlog, complete, nil
+}
+
+func (b *eventBroker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[sub] {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// close ends every subscription and turns away new ones
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// trackChanges runs change and publishes an event for each way it altered
+// the league. Changes are run one at a time so their events don't overlap.
+// If anything changed entry is filled in and recorded in the journal.
+func (p *PlayerServer) trackChanges(entry *JournalEntry, change func()) {
+	webhooks := p.Webhooks
+	if webhooks != nil {
+		// deferred first so it runs after the unlock, and the next change
+		// doesn't wait for the outbox to reach the disk
+		defer webhooks.persist()
+	}
+	p.changes.Lock()
+	defer p.changes.Unlock()
+
+	before := p.Store.GetLeague()
+	change()
+	after := p.Store.GetLeague()
+	if events := diffLeague(before, after); len(events) > 0 {
+		published := p.events.publish(events...)
+		if webhooks != nil {
+			webhooks.queue(published...)
+		}
+	}
+
+	entry.Before, entry.After = changedPlayers(before, after)
+	if p.Journal != nil && (len(entry.Before) > 0 || len(entry.After) > 0) {
+		*entry = p.Journal.record(*entry)
+	}
+}
+
+// updateLeague is UpdateLeague with events published and entry recorded for whatever changed
+func (p *PlayerServer) updateLeague(entry *JournalEntry, updater LeagueUpdater, update func(League) (League, error)) (err error) {
+	p.trackChanges(entry, func() {
+		err = applyUpdate(entry, updater, update)
+	})
+	return err
+}
+
+// renamingUpdater is implemented by stores that keep more about a player than
+// their wins, so that it can follow players renamed by an update
+type renamingUpdater interface {
+	UpdateLeagueRenaming(update func(League) (League, map[string]string, error)) error
+}
+
+// applyUpdate runs update through updater, passing on the renames update
+// notes in entry
+func applyUpdate(entry *JournalEntry, updater LeagueUpdater, update func(League) (League, error)) error {
+	renaming, ok := updater.(renamingUpdater)
+	if !ok {
+		return updater.UpdateLeague(update)
+	}
+	return renaming.UpdateLeagueRenaming(func(league League) (League, map[string]string, error) {
+		league, err := update(league)
+		return league, entry.Renamed, err
+	})
+}
+
+// eventsHandler streams league changes as Server-Sent Events. Clients resume
+// with Last-Event-ID, or ?lastEventId= for the first connection.
+func (p *PlayerServer) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	after, _ := strconv.Atoi(lastID)
+
+	sub, backlog, complete, err := p.events.subscribe(after)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer p.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 2000\n\n")
+
+	if !complete {
+		writeEvent(w, Event{Type: EventReset, Time: time.Now()})
+	}
+	for _, event := range backlog {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(p.events.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, open := <-sub.events:
+			if !open {
+				return
+			}
+			writeEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one event in the text/event-stream format
+func writeEvent(w http.ResponseWriter, event Event) {
+	data, _ := json.Marshal(event)
+	if event.ID > 0 {
+		fmt.Fprintf(w, "id: %d\n", event.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
@@ -0,0 +1,250 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseMessage is one message read off an event stream
+type sseMessage struct {
+	id, event, data string
+	comment         bool
+}
+
+// eventStream reads messages from a /events response in the background
+type eventStream struct {
+	response *http.Response
+	messages chan sseMessage
+}
+
+func openEventStream(t testing.TB, url, lastEventID string) *eventStream {
+	t.Helper()
+	request, _ := http.NewRequest(http.MethodGet, url+"/events", nil)
+	request.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	response, err := http.DefaultClient.Do(request)
+	assertNoError(t, err)
+	assertStatus(t, response.StatusCode, http.StatusOK)
+
+	stream := &eventStream{response: response, messages: make(chan sseMessage, 100)}
+	go func() {
+		defer close(stream.messages)
+		scanner := bufio.NewScanner(response.Body)
+		var message sseMessage
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if message != (sseMessage{}) {
+					stream.messages <- message
+				}
+				message = sseMessage{}
+			case strings.HasPrefix(line, ":"):
+				stream.messages <- sseMessage{comment: true}
+			case strings.HasPrefix(line, "id: "):
+				message.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				message.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				message.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return stream
+}
+
+// next returns the next event, skipping heartbeats
+func (s *eventStream) next(t testing.TB) sseMessage {
+	t.Helper()
+	for {
+		select {
+		case message, ok := <-s.messages:
+			if !ok {
+				t.Fatal("event stream ended")
+			}
+			if !message.comment && message.event != "" {
+				return message
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+}
+
+func (s *eventStream) close() {
+	s.response.Body.Close()
+}
+
+func TestDiffLeague(t *testing.T) {
+	before := League{{"Chris", 33}, {"Cleo", 10}, {"Pepper", 2}}
+	after := League{{"Chris", 33}, {"Cleo", 11}, {"Floyd", 0}}
+
+	var got []string
+	for _, event := range diffLeague(before, after) {
+		got = append(got, event.Type+" "+event.Player)
+	}
+	want := []string{EventWinRecorded + " Cleo", EventPlayerCreated + " Floyd", EventPlayerDeleted + " Pepper"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	events := diffLeague(League{{"Cleo", 10}, {"Chris", 9}}, League{{"Chris", 19}, {"Cleo", 10}})
+	if len(events) != 3 || events[0].Type != EventScoreChanged || events[1].Type != EventLeagueReordered || events[2].Type != EventLeaderChanged {
+		t.Errorf("got %+v want a score change, a reorder and a new leader", events)
+	}
+}
+
+func TestEventBroker(t *testing.T) {
+	t.Run("resumes from the buffer", func(t *testing.T) {
+		broker := newEventBroker(3)
+		for i := 0; i < 4; i++ {
+			broker.publish(Event{Type: EventWinRecorded, Player: "Cleo", Wins: i})
+		}
+
+		_, backlog, complete, err := broker.subscribe(2)
+		assertNoError(t, err)
+		if !complete || len(backlog) != 2 || backlog[0].ID != 3 {
+			t.Errorf("got %+v complete %v, want events 3 and 4", backlog, complete)
+		}
+
+		_, _, complete, _ = broker.subscribe(0)
+		if !complete {
+			t.Error("a new subscriber hasn't missed anything")
+		}
+
+		_, _, complete, _ = broker.subscribe(1)
+		if !complete {
+			t.Error("event 1 was the last one seen, nothing after it was forgotten")
+		}
+	})
+
+	t.Run("says when events were forgotten", func(t *testing.T) {
+		broker := newEventBroker(2)
+		for i := 0; i < 5; i++ {
+			broker.publish(Event{Type: EventWinRecorded})
+		}
+
+		_, backlog, complete, _ := broker.subscribe(1)
+		if complete || len(backlog) != 2 {
+			t.Errorf("got %d events complete %v, want the 2 buffered ones and incomplete", len(backlog), complete)
+		}
+	})
+
+	t.Run("drops subscribers that fall behind", func(t *testing.T) {
+		broker := newEventBroker(defaultEventBufferSize)
+		slow, _, _, _ := broker.subscribe(0)
+
+		for i := 0; i <= subscriberQueueSize; i++ {
+			broker.publish(Event{Type: EventWinRecorded})
+		}
+
+		received := 0
+		for range slow.events {
+			received++
+		}
+		if received != subscriberQueueSize {
+			t.Errorf("got %d events before being dropped, want %d", received, subscriberQueueSize)
+		}
+	})
+
+	t.Run("turns subscribers away once closed", func(t *testing.T) {
+		broker := newEventBroker(defaultEventBufferSize)
+		sub, _, _, _ := broker.subscribe(0)
+
+		broker.close()
+
+		if _, open := <-sub.events; open {
+			t.Error("expected the subscription to end")
+		}
+		if _, _, _, err := broker.subscribe(0); err == nil {
+			t.Error("expected an error subscribing after close")
+		}
+	})
+}
+
+func TestEventsEndpoint(t *testing.T) {
+	server, _, clean := newFileSystemServer(t, importTestLeague)
+	defer clean()
+	server.events.heartbeat = 10 * time.Millisecond
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	t.Run("streams changes as they happen", func(t *testing.T) {
+		stream := openEventStream(t, ts.URL, "")
+		defer stream.close()
+
+		deleteFloyd, _ := http.NewRequest(http.MethodDelete, "/store/Floyd", nil)
+		for _, request := range []*http.Request{newPostWinRequest("Cleo"), newPostWinRequest("Floyd"), deleteFloyd} {
+			server.ServeHTTP(httptest.NewRecorder(), request)
+		}
+
+		for _, want := range []string{EventWinRecorded, EventPlayerCreated, EventPlayerDeleted} {
+			message := stream.next(t)
+			if message.event != want {
+				t.Errorf("got event %q want %q", message.event, want)
+			}
+		}
+	})
+
+	t.Run("resumes after Last-Event-ID", func(t *testing.T) {
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+		last := server.events.nextID - 1
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+		stream := openEventStream(t, ts.URL, strconv.Itoa(last))
+		defer stream.close()
+
+		message := stream.next(t)
+		if message.id != strconv.Itoa(last+1) {
+			t.Errorf("got event %s want %d", message.id, last+1)
+		}
+		var event Event
+		assertNoError(t, json.Unmarshal([]byte(message.data), &event))
+		if event.Player != "Cleo" || event.Wins != 13 {
+			t.Errorf("got %+v want Cleo on 13 wins", event)
+		}
+	})
+
+	t.Run("sends heartbeats", func(t *testing.T) {
+		stream := openEventStream(t, ts.URL, "")
+		defer stream.close()
+
+		select {
+		case message := <-stream.messages:
+			if !message.comment {
+				t.Errorf("got %+v want a heartbeat", message)
+			}
+		case <-time.After(time.Second):
+			t.Error("no heartbeat")
+		}
+	})
+
+	t.Run("ends streams on shutdown", func(t *testing.T) {
+		stream := openEventStream(t, ts.URL, "")
+		defer stream.close()
+
+		assertNoError(t, server.Shutdown(context.Background()))
+
+		deadline := time.After(2 * time.Second)
+		for {
+			select {
+			case _, open := <-stream.messages:
+				if !open {
+					return
+				}
+			case <-deadline:
+				t.Fatal("stream still open after shutdown")
+			}
+		}
+	})
+}
@@ -0,0 +1,294 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Types of DomainEvent
+const (
+	PlayerCreated     = "PlayerCreated"
+	WinRecorded       = "WinRecorded"
+	PlayerDeleted     = "PlayerDeleted"
+	PlayerOverwritten = "PlayerOverwritten"
+	PlayerRenamed     = "PlayerRenamed"
+)
+
+// DomainEvent is a fact about the league. Once stored it is never changed.
+type DomainEvent struct {
+	Seq    int64     `json:"seq"`
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Player string    `json:"player"`
+	// Wins is the player's wins after PlayerCreated or PlayerOverwritten
+	Wins int `json:"wins,omitempty"`
+	// From is the player's old name after PlayerRenamed
+	From string `json:"from,omitempty"`
+}
+
+// EventLog keeps events in the order they happened
+type EventLog interface {
+	Append(events ...DomainEvent) error
+	ReadAll() ([]DomainEvent, error)
+}
+
+// Projection builds a read model from events. Projections are only touched
+// by the EventStore, under its lock.
+type Projection interface {
+	Reset()
+	Apply(event DomainEvent)
+}
+
+// EventStore appends events to a log and keeps projections up to date with them
+type EventStore struct {
+	log         EventLog
+	now         func() time.Time
+	mu          sync.RWMutex
+	seq         int64
+	projections []Projection
+}
+
+// NewEventStore replays log into projections
+func NewEventStore(log EventLog, projections ...Projection) (*EventStore, error) {
+	s := &EventStore{log: log, now: time.Now, projections: projections}
+	err := s.Rebuild()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Rebuild throws away every projection and replays the whole log into them
+func (s *EventStore) Rebuild() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, err := s.log.ReadAll()
+	if err != nil {
+		return err
+	}
+	for _, projection := range s.projections {
+		projection.Reset()
+	}
+	s.seq = 0
+	for _, event := range events {
+		s.apply(event)
+	}
+	return nil
+}
+
+// AddProjection builds a new projection from the log and keeps it up to date from then on
+func (s *EventStore) AddProjection(projection Projection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, err := s.log.ReadAll()
+	if err != nil {
+		return err
+	}
+	projection.Reset()
+	for _, event := range events {
+		projection.Apply(event)
+	}
+	s.projections = append(s.projections, projection)
+	return nil
+}
+
+func (s *EventStore) apply(event DomainEvent) {
+	for _, projection := range s.projections {
+		projection.Apply(event)
+	}
+	s.seq = event.Seq
+}
+
+// Execute calls decide with the projections up to date and no other changes
+// running, then stores the events it returns. It returns them numbered.
+func (s *EventStore) Execute(decide func() ([]DomainEvent, error)) ([]DomainEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, err := decide()
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+
+	now := s.now()
+	for i := range events {
+		events[i].Seq = s.seq + int64(i) + 1
+		events[i].Time = now
+	}
+	err = s.log.Append(events...)
+	if err != nil {
+		return nil, fmt.Errorf("problem storing events, %v", err)
+	}
+	for _, event := range events {
+		s.apply(event)
+	}
+	return events, nil
+}
+
+// Append stores events as they are
+func (s *EventStore) Append(events ...DomainEvent) ([]DomainEvent, error) {
+	return s.Execute(func() ([]DomainEvent, error) { return events, nil })
+}
+
+// View runs read with the projections locked against changes
+func (s *EventStore) View(read func()) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	read()
+}
+
+// Events returns every stored event after seq
+func (s *EventStore) Events(after int64) ([]DomainEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events, err := s.log.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, event := range events {
+		if event.Seq > after {
+			return events[i:], nil
+		}
+	}
+	return []DomainEvent{}, nil
+}
+
+// MemoryEventLog keeps events in memory, for tests and throwaway servers
+type MemoryEventLog struct {
+	mu     sync.Mutex
+	events []DomainEvent
+}
+
+func (m *MemoryEventLog) Append(events ...DomainEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, events...)
+	return nil
+}
+
+func (m *MemoryEventLog) ReadAll() ([]DomainEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]DomainEvent{}, m.events...), nil
+}
+
+// FileEventLog keeps events in a file, one JSON object per line. Events are
+// only ever added to the end of the file.
+type FileEventLog struct {
+	mu   sync.Mutex
+	file *os.File
+	lock *databaseLock
+}
+
+// NewFileEventLog takes the lock on file and checks it can be read. A last
+// line cut short by a crash is removed, here and only here, so reading the
+// log never changes it.
+func NewFileEventLog(file *os.File) (*FileEventLog, error) {
+	lock, err := lockDatabase(file)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &FileEventLog{file: file, lock: lock}
+	_, complete, err := l.read()
+	if err == nil && complete < l.size() {
+		log.Printf("removing unfinished event at the end of %s", file.Name())
+		err = file.Truncate(complete)
+	}
+	if err != nil {
+		lock.release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// ReadAll returns every event in the file, leaving out a last line that is
+// still being written
+func (l *FileEventLog) ReadAll() ([]DomainEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events, _, err := l.read()
+	return events, err
+}
+
+// read parses the file, returning its events and the length of the part of
+// it that holds whole lines. Callers hold l.mu, apart from NewFileEventLog.
+func (l *FileEventLog) read() ([]DomainEvent, int64, error) {
+	_, err := l.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []DomainEvent{}
+	reader := bufio.NewReader(l.file)
+	var offset int64
+	for line := 1; ; line++ {
+		raw, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// no newline means the last write never finished
+			return events, offset, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("problem reading event log %s, %v", l.file.Name(), err)
+		}
+
+		var event DomainEvent
+		err = json.Unmarshal(bytes.TrimSpace(raw), &event)
+		if err != nil {
+			return nil, 0, fmt.Errorf("problem parsing event log %s at line %d, %v", l.file.Name(), line, err)
+		}
+		events = append(events, event)
+		offset += int64(len(raw))
+	}
+}
+
+// size is the length of the file, or 0 if it can't be found
+func (l *FileEventLog) size() int64 {
+	info, err := l.file.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (l *FileEventLog) Append(events ...DomainEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		err := encoder.Encode(event)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := l.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Close releases the lock on the log and closes the file
+func (l *FileEventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lock.release()
+	return l.file.Close()
+}
@@ -0,0 +1,231 @@
+package httpserver
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventStore(t *testing.T) {
+	t.Run("numbers and times events and applies them to projections", func(t *testing.T) {
+		league := &LeagueProjection{}
+		store, err := NewEventStore(&MemoryEventLog{}, league)
+		assertNoError(t, err)
+		clock := &fakeClock{current: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+		store.now = clock.now
+
+		events, err := store.Append(
+			DomainEvent{Type: PlayerCreated, Player: "Cleo"},
+			DomainEvent{Type: WinRecorded, Player: "Cleo"},
+		)
+		assertNoError(t, err)
+
+		if events[0].Seq != 1 || events[1].Seq != 2 || !events[1].Time.Equal(clock.current) {
+			t.Errorf("got %+v want seq 1 and 2 at %v", events, clock.current)
+		}
+		assertLeague(t, league.League(), League{{"Cleo", 1}})
+	})
+
+	t.Run("stores nothing when decide fails", func(t *testing.T) {
+		log := &MemoryEventLog{}
+		store, err := NewEventStore(log)
+		assertNoError(t, err)
+
+		_, err = store.Execute(func() ([]DomainEvent, error) {
+			return []DomainEvent{{Type: PlayerCreated, Player: "Cleo"}}, os.ErrInvalid
+		})
+		if err != os.ErrInvalid {
+			t.Errorf("got %v want %v", err, os.ErrInvalid)
+		}
+		if events, _ := log.ReadAll(); len(events) != 0 {
+			t.Errorf("got %d events stored want none", len(events))
+		}
+	})
+
+	t.Run("rebuilds projections from the log", func(t *testing.T) {
+		league := &LeagueProjection{}
+		store, err := NewEventStore(&MemoryEventLog{}, league)
+		assertNoError(t, err)
+		store.Append(
+			DomainEvent{Type: PlayerCreated, Player: "Cleo", Wins: 3},
+			DomainEvent{Type: PlayerCreated, Player: "Chris"},
+			DomainEvent{Type: PlayerDeleted, Player: "Chris"},
+		)
+
+		league.Reset()
+		assertNoError(t, store.Rebuild())
+		assertLeague(t, league.League(), League{{"Cleo", 3}})
+
+		events, _ := store.Append(DomainEvent{Type: WinRecorded, Player: "Cleo"})
+		if events[0].Seq != 4 {
+			t.Errorf("got seq %d after rebuild want 4", events[0].Seq)
+		}
+	})
+
+	t.Run("catches up projections added later", func(t *testing.T) {
+		store, err := NewEventStore(&MemoryEventLog{})
+		assertNoError(t, err)
+		store.Append(DomainEvent{Type: PlayerCreated, Player: "Cleo", Wins: 2})
+
+		league := &LeagueProjection{}
+		assertNoError(t, store.AddProjection(league))
+		store.Append(DomainEvent{Type: WinRecorded, Player: "Cleo"})
+
+		assertLeague(t, league.League(), League{{"Cleo", 3}})
+	})
+
+	t.Run("returns events after a sequence number", func(t *testing.T) {
+		store, err := NewEventStore(&MemoryEventLog{})
+		assertNoError(t, err)
+		store.Append(
+			DomainEvent{Type: PlayerCreated, Player: "Cleo"},
+			DomainEvent{Type: WinRecorded, Player: "Cleo"},
+			DomainEvent{Type: WinRecorded, Player: "Cleo"},
+		)
+
+		events, err := store.Events(1)
+		assertNoError(t, err)
+		if len(events) != 2 || events[0].Seq != 2 {
+			t.Errorf("got %+v want events 2 and 3", events)
+		}
+	})
+}
+
+func TestFileEventLog(t *testing.T) {
+	t.Run("keeps events across reopening", func(t *testing.T) {
+		file, clean := createTempFile(t, "")
+		defer clean()
+
+		log, err := NewFileEventLog(file)
+		assertNoError(t, err)
+		first := []DomainEvent{
+			{Seq: 1, Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Type: PlayerCreated, Player: "Cleo", Wins: 2},
+			{Seq: 2, Time: time.Date(2024, 5, 1, 12, 1, 0, 0, time.UTC), Type: WinRecorded, Player: "Cleo"},
+		}
+		assertNoError(t, log.Append(first...))
+		assertNoError(t, log.Close())
+
+		log, err = NewFileEventLog(openAgain(t, file))
+		assertNoError(t, err)
+		defer log.Close()
+
+		got, err := log.ReadAll()
+		assertNoError(t, err)
+		if !reflect.DeepEqual(got, first) {
+			t.Errorf("got %+v want %+v", got, first)
+		}
+	})
+
+	t.Run("drops a last event that was never finished", func(t *testing.T) {
+		file, clean := createTempFile(t, `{"seq":1,"type":"PlayerCreated","player":"Cleo"}
+{"seq":2,"type":"WinRec`)
+		defer clean()
+
+		log, err := NewFileEventLog(file)
+		assertNoError(t, err)
+		defer log.Close()
+
+		events, err := log.ReadAll()
+		assertNoError(t, err)
+		if len(events) != 1 {
+			t.Fatalf("got %d events want 1", len(events))
+		}
+
+		assertNoError(t, log.Append(DomainEvent{Seq: 2, Type: WinRecorded, Player: "Cleo"}))
+		events, err = log.ReadAll()
+		assertNoError(t, err)
+		if len(events) != 2 || events[1].Type != WinRecorded {
+			t.Errorf("got %+v want the new event after the first", events)
+		}
+	})
+
+	t.Run("leaves the file alone when reading it", func(t *testing.T) {
+		file, clean := createTempFile(t, `{"seq":1,"type":"PlayerCreated","player":"Cleo"}
+`)
+		defer clean()
+
+		log, err := NewFileEventLog(file)
+		assertNoError(t, err)
+		defer log.Close()
+
+		// as if another event were half way through being written
+		_, err = file.WriteAt([]byte(`{"seq":2,"type":"WinRec`), 49)
+		assertNoError(t, err)
+		events, err := log.ReadAll()
+		assertNoError(t, err)
+		if len(events) != 1 {
+			t.Fatalf("got %d events want 1", len(events))
+		}
+		if info, _ := file.Stat(); info.Size() != 72 {
+			t.Errorf("got a file of %d bytes want the unfinished event kept", info.Size())
+		}
+	})
+
+	t.Run("refuses a damaged event in the middle", func(t *testing.T) {
+		file, clean := createTempFile(t, `{"seq":1,"type":"PlayerCreated","player":"Cleo"}
+not json
+{"seq":3,"type":"WinRecorded","player":"Cleo"}
+`)
+		defer clean()
+
+		_, err := NewFileEventLog(file)
+		if err == nil {
+			t.Fatal("expected an error for a damaged log")
+		}
+	})
+}
+
+func TestProjections(t *testing.T) {
+	at := func(minute int) time.Time { return time.Date(2024, 5, 1, 12, minute, 0, 0, time.UTC) }
+	events := []DomainEvent{
+		{Seq: 1, Time: at(0), Type: PlayerCreated, Player: "Cleo"},
+		{Seq: 2, Time: at(1), Type: WinRecorded, Player: "Cleo"},
+		{Seq: 3, Time: at(2), Type: PlayerCreated, Player: "Chris", Wins: 5},
+		{Seq: 4, Time: at(3), Type: WinRecorded, Player: "Cleo"},
+		{Seq: 5, Time: at(4), Type: PlayerOverwritten, Player: "Cleo", Wins: 10},
+		{Seq: 6, Time: at(5), Type: PlayerDeleted, Player: "Chris"},
+	}
+
+	t.Run("league", func(t *testing.T) {
+		league := &LeagueProjection{}
+		league.Reset()
+		for _, event := range events {
+			league.Apply(event)
+		}
+		assertLeague(t, league.League(), League{{"Cleo", 10}})
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		stats := &StatsProjection{}
+		stats.Reset()
+		for _, event := range events {
+			stats.Apply(event)
+		}
+
+		cleo, _ := stats.Stats("Cleo")
+		lastWin := at(3)
+		want := PlayerStats{Name: "Cleo", Wins: 10, WinsRecorded: 2, BestWins: 10, Created: at(0), LastWin: &lastWin, Overwrites: 1}
+		if !reflect.DeepEqual(cleo, want) {
+			t.Errorf("got %+v want %+v", cleo, want)
+		}
+
+		chris, ok := stats.Stats("Chris")
+		if !ok || !chris.Deleted || chris.Deletions != 1 || chris.BestWins != 5 {
+			t.Errorf("got %+v want Chris deleted with best wins 5", chris)
+		}
+	})
+
+	t.Run("history", func(t *testing.T) {
+		history := &HistoryProjection{}
+		history.Reset()
+		for _, event := range events {
+			history.Apply(event)
+		}
+
+		got := history.History("Chris")
+		if !reflect.DeepEqual(got, []DomainEvent{events[2], events[5]}) {
+			t.Errorf("got %+v want Chris's two events", got)
+		}
+	})
+}
@@ -3,44 +3,166 @@ package httpserver
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
 	"sort"
+	"sync"
 )
 
 type FileSystemPlayerStore struct {
 	Database *json.Encoder
 	league League
+	profiles map[string]Profile
+	file *os.File
+	tape *tape
+	lock *databaseLock
+	mu sync.RWMutex
+	// readOnly is set when the database was found corrupt, problems says why
+	readOnly bool
+	problems []Problem
 }
 
 func NewFileSystemPlayerStore(file *os.File) (*FileSystemPlayerStore, error) {
+	return NewEncryptedFileSystemPlayerStore(file, nil)
+}
+
+// NewEncryptedFileSystemPlayerStore opens a store whose file is encrypted with key.
+// A plaintext file is encrypted the first time it is opened with a key.
+// A corrupt file gives a *CorruptDatabaseError.
+func NewEncryptedFileSystemPlayerStore(file *os.File, key *DatabaseKey) (*FileSystemPlayerStore, error) {
+	return openFileSystemPlayerStore(file, key, false)
+}
+
+// NewReadOnlyFileSystemPlayerStore opens a corrupt database so it can be read
+// and repaired. Nothing is written until Repair or Restore is called. A
+// database with no problems opens as normal.
+func NewReadOnlyFileSystemPlayerStore(file *os.File, key *DatabaseKey) (*FileSystemPlayerStore, error) {
+	return openFileSystemPlayerStore(file, key, true)
+}
 
-	err := initialisePlayerDBFile(file)
+func openFileSystemPlayerStore(file *os.File, key *DatabaseKey, readOnlyIfCorrupt bool) (store *FileSystemPlayerStore, err error) {
+	// only one process may write the file, or they overwrite each other
+	lock, err := lockDatabase(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			lock.release()
+		}
+	}()
+
+	err = initialisePlayerDBFile(file)
 	if err != nil {
 		return nil, fmt.Errorf("problem with initilising player db file, %v", err)
 	}
 
-	league, err := NewLeague(file)
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading player store from file %s, %v", file.Name(), err)
+	}
+
+	plain, err := unseal(raw, key)
+	if err != nil {
+		return nil, fmt.Errorf("problem opening %s, %w", file.Name(), err)
+	}
+
+	db, version, err := loadDatabase(plain)
 
 	if err != nil {
 		return nil, fmt.Errorf("problem loading player store from file %s, %v", file.Name(), err)
 	}
 
-	return &FileSystemPlayerStore{
-		json.NewEncoder(&tape{file}),
-		league,
-	}, nil
+	tape := &tape{file: file, key: key}
+	store = &FileSystemPlayerStore{
+		Database: json.NewEncoder(tape),
+		league:   db.League,
+		profiles: db.Profiles,
+		file:     file,
+		tape:     tape,
+		lock:     lock,
+	}
+	if store.profiles == nil {
+		store.profiles = map[string]Profile{}
+	}
+
+	problems := checkDatabase(db)
+	if len(problems) > 0 {
+		if !readOnlyIfCorrupt {
+			return nil, &CorruptDatabaseError{Path: file.Name(), Problems: problems}
+		}
+		store.readOnly = true
+		store.problems = problems
+		log.Printf("opened %s read only, %v", file.Name(), &CorruptDatabaseError{Path: file.Name(), Problems: problems})
+		return store, nil
+	}
+
+	if version != currentSchemaVersion {
+		// the copy kept before migrating mustn't leave the league readable
+		// next to a database that is being encrypted
+		original := raw
+		if key != nil && !isSealed(raw) {
+			original, err = key.seal(raw)
+			if err != nil {
+				return nil, fmt.Errorf("problem encrypting %s before migrating, %v", file.Name(), err)
+			}
+		}
+		_, err = backupDatabase(file.Name(), original, version)
+		if err != nil {
+			return nil, err
+		}
+		err = store.write(store.league, store.profiles)
+		if err != nil {
+			return nil, fmt.Errorf("problem upgrading %s from version %d, %v", file.Name(), version, err)
+		}
+		log.Printf("migrated %s from version %d to %d", file.Name(), version, currentSchemaVersion)
+	} else if key != nil && !isSealed(raw) {
+		err = store.write(store.league, store.profiles)
+		if err != nil {
+			return nil, fmt.Errorf("problem encrypting %s, %v", file.Name(), err)
+		}
+		log.Printf("encrypted %s with key %s", file.Name(), key.ID())
+	}
+	return store, nil
+}
+
+// write saves the whole database over the file
+func (f *FileSystemPlayerStore) write(league League, profiles map[string]Profile) error {
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	return f.Database.Encode(newDatabase(league, profiles))
+}
+
+// writeOver is write for the ways out of read only mode
+func (f *FileSystemPlayerStore) writeOver(league League, profiles map[string]Profile) error {
+	readOnly := f.readOnly
+	f.readOnly = false
+	err := f.write(league, profiles)
+	if err != nil {
+		f.readOnly = readOnly
+		return err
+	}
+	f.problems = nil
+	return nil
 }
 
 func (f *FileSystemPlayerStore) GetLeague() League {
-	sort.Slice(f.league, func(i int, j int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sort.SliceStable(f.league, func(i int, j int) bool {
 		return f.league[i].Wins > f.league[j].Wins
 	})
 
 
-	return f.league
+	return append(League{}, f.league...)
 }
 
 func (f *FileSystemPlayerStore) GetPlayerScore(playerName string) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 
 	player, _ := f.league.Find(playerName)
 
@@ -52,6 +174,8 @@ func (f *FileSystemPlayerStore) GetPlayerScore(playerName string) int {
 }
 
 func (f *FileSystemPlayerStore) RecordWin(playerName string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	player, _ := f.league.Find(playerName)
 
 	if player != nil {
@@ -59,10 +183,12 @@ func (f *FileSystemPlayerStore) RecordWin(playerName string) {
 	}else{
 		f.league = append(f.league, Player{playerName, 1})
 	}
-	f.Database.Encode(f.league)
+	f.write(f.league, f.profiles)
 }
 
 func (f *FileSystemPlayerStore) RecordNewPlayer(player Player){
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
 	playerFound, idx := f.league.Find(player.Name)
 	if playerFound != nil{
@@ -70,19 +196,313 @@ func (f *FileSystemPlayerStore) RecordNewPlayer(player Player){
 		f.league = append(f.league[:idx], f.league[idx+1:]...)
 	}
 	f.league = append(f.league, Player{player.Name, player.Wins})
-	f.Database.Encode(f.league)
+	f.write(f.league, f.profiles)
 }
 
 func (f *FileSystemPlayerStore) DeletePlayer(name string){
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	playerFound, idx := f.league.Find(name)
 	if playerFound != nil{
-		//player found- delete
+		//player found- delete, with their profile so a new player by that name starts afresh
 		f.league = append(f.league[:idx], f.league[idx+1:]...)
+		if _, ok := f.profiles[playerFound.Name]; ok {
+			f.profiles = copyProfiles(f.profiles)
+			delete(f.profiles, playerFound.Name)
+		}
 	}else{
-		fmt.Errorf("player cound not be found, and deleted: %s", name)
+		log.Printf("player cound not be found, and deleted: %s", name)
+	}
+	f.write(f.league, f.profiles)
+
+}
+
+// UpdateLeague replaces the league with the result of update in a single write.
+// If update returns an error the league is left as it was.
+func (f *FileSystemPlayerStore) UpdateLeague(update func(League) (League, error)) error {
+	return f.UpdateLeagueRenaming(func(league League) (League, map[string]string, error) {
+		league, err := update(league)
+		return league, nil, err
+	})
+}
+
+// UpdateLeagueRenaming is UpdateLeague for changes that may rename players.
+// update also returns the renames it made, new name to old, and profiles
+// follow those players in the same write. Profiles of players that are no
+// longer in the league are dropped.
+func (f *FileSystemPlayerStore) UpdateLeagueRenaming(update func(League) (League, map[string]string, error)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	league, renamed, err := update(append(League{}, f.league...))
+	if err != nil {
+		return err
+	}
+
+	profiles := followPlayers(f.profiles, league, renamed)
+	err = f.write(league, profiles)
+	if err != nil {
+		return fmt.Errorf("problem writing league to %s, %w", f.file.Name(), err)
+	}
+	f.league = league
+	f.profiles = profiles
+	return nil
+}
+
+// followPlayers returns the profiles of the players in league, under their
+// new names for players in renamed
+func followPlayers(profiles map[string]Profile, league League, renamed map[string]string) map[string]Profile {
+	renamedAway := make(map[string]bool, len(renamed))
+	for _, from := range renamed {
+		renamedAway[from] = true
+	}
+
+	followed := make(map[string]Profile, len(profiles))
+	for _, player := range league {
+		from, ok := renamed[player.Name]
+		if !ok {
+			if renamedAway[player.Name] {
+				// a new player took the name someone was renamed from
+				continue
+			}
+			from = player.Name
+		}
+		if profile, ok := profiles[from]; ok {
+			followed[player.Name] = profile
+		}
+	}
+	return followed
+}
+
+// GetProfile returns the profile stored for a player
+func (f *FileSystemPlayerStore) GetProfile(name string) (Profile, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	profile, ok := f.profiles[name]
+	return profile, ok
+}
+
+// SetProfile stores a player's profile
+func (f *FileSystemPlayerStore) SetProfile(name string, profile Profile) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	profiles := copyProfiles(f.profiles)
+	profiles[name] = profile
+	return f.replaceProfiles(profiles)
+}
+
+// RenameProfile moves a profile to a player's new name
+func (f *FileSystemPlayerStore) RenameProfile(from, to string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	profile, ok := f.profiles[from]
+	if !ok {
+		return nil
+	}
+	profiles := copyProfiles(f.profiles)
+	delete(profiles, from)
+	profiles[to] = profile
+	return f.replaceProfiles(profiles)
+}
+
+func (f *FileSystemPlayerStore) replaceProfiles(profiles map[string]Profile) error {
+	err := f.write(f.league, profiles)
+	if err != nil {
+		return fmt.Errorf("problem writing profiles to %s, %w", f.file.Name(), err)
+	}
+	f.profiles = profiles
+	return nil
+}
+
+func copyProfiles(profiles map[string]Profile) map[string]Profile {
+	copied := make(map[string]Profile, len(profiles))
+	for name, profile := range profiles {
+		copied[name] = profile
+	}
+	return copied
+}
+
+// Rekey re-encrypts the database with a new key. A nil key writes it out as plaintext.
+func (f *FileSystemPlayerStore) Rekey(key *DatabaseKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old := f.tape.key
+	f.tape.key = key
+	err := f.write(f.league, f.profiles)
+	if err != nil {
+		f.tape.key = old
+		return fmt.Errorf("problem re-encrypting %s, %v", f.file.Name(), err)
+	}
+	return nil
+}
+
+// Snapshot writes a consistent copy of the whole database to w, encrypted
+// the same way as the file
+func (f *FileSystemPlayerStore) Snapshot(w io.Writer) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	plain, err := json.Marshal(newDatabase(f.league, f.profiles))
+	if err != nil {
+		return err
+	}
+	plain = append(plain, '\n')
+	if f.tape.key != nil {
+		plain, err = f.tape.key.seal(plain)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(plain)
+	return err
+}
+
+// Restore replaces the whole database with a snapshot, upgrading it if it
+// came from an older version
+func (f *FileSystemPlayerStore) Restore(r io.Reader) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("problem reading snapshot, %v", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	plain, err := unseal(raw, f.tape.key)
+	if err != nil {
+		return err
+	}
+	db, _, err := loadDatabase(plain)
+	if err != nil {
+		return err
+	}
+	if problems := checkDatabase(db); len(problems) > 0 {
+		return &CorruptDatabaseError{Path: "snapshot", Problems: problems}
+	}
+	if db.Profiles == nil {
+		db.Profiles = map[string]Profile{}
 	}
-	f.Database.Encode(f.league)
 
+	err = f.writeOver(db.League, db.Profiles)
+	if err != nil {
+		return fmt.Errorf("problem writing restored league to %s, %v", f.file.Name(), err)
+	}
+	f.league = db.League
+	f.profiles = db.Profiles
+	return nil
+}
+
+// ReadOnly reports whether the store was opened read only because it is corrupt
+func (f *FileSystemPlayerStore) ReadOnly() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.readOnly
+}
+
+// Problems lists what is wrong with the database
+func (f *FileSystemPlayerStore) Problems() []Problem {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.readOnly {
+		return append([]Problem{}, f.problems...)
+	}
+	return CheckLeague(f.league)
+}
+
+// Repair fixes the league as options say, keeping a copy of the file as it
+// was, and leaves read only mode. It returns the problems it fixed.
+func (f *FileSystemPlayerStore) Repair(options RepairOptions) ([]Problem, error) {
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	problems := CheckLeague(f.league)
+	if f.readOnly {
+		problems = f.problems
+	}
+	if len(problems) == 0 {
+		return problems, nil
+	}
+
+	info, err := f.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, info.Size())
+	_, err = f.file.ReadAt(raw, 0)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading %s before repairing it, %v", f.file.Name(), err)
+	}
+	_, err = copyDatabase(f.file.Name(), raw, "fsck", "repairing")
+	if err != nil {
+		return nil, err
+	}
+
+	league := RepairLeague(f.league, options)
+	err = f.writeOver(league, f.profiles)
+	if err != nil {
+		return nil, fmt.Errorf("problem writing repaired league to %s, %v", f.file.Name(), err)
+	}
+	f.league = league
+	return problems, nil
+}
+
+// Close releases the lock on the database and closes the file
+func (f *FileSystemPlayerStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lock.release()
+	return f.file.Close()
+}
+
+// DatabasePath is the name of the file backing the store
+func (f *FileSystemPlayerStore) DatabasePath() string {
+	return f.file.Name()
+}
+
+// CheckRead makes sure the database file can still be read
+func (f *FileSystemPlayerStore) CheckRead() error {
+	_, err := f.file.ReadAt(make([]byte, 1), 0)
+	if err != nil {
+		return fmt.Errorf("problem reading database file %s, %v", f.file.Name(), err)
+	}
+	return nil
+}
+
+// CheckWrite makes sure the database file can still be opened for writing and
+// the directory holding it can still be written to, without touching the
+// database itself
+func (f *FileSystemPlayerStore) CheckWrite() error {
+	// the store writes through the file it already has open, which keeps
+	// working after the file is made read only or removed, but a restart won't
+	database, err := os.OpenFile(f.file.Name(), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("problem opening database file %s for writing, %v", f.file.Name(), err)
+	}
+	database.Close()
+
+	probe, err := os.CreateTemp(filepath.Dir(f.file.Name()), ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("problem creating write probe next to %s, %v", f.file.Name(), err)
+	}
+	defer os.Remove(probe.Name())
+	defer probe.Close()
+
+	_, err = probe.Write([]byte("ok"))
+	if err == nil {
+		err = probe.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("problem writing probe next to %s, %v", f.file.Name(), err)
+	}
+	return nil
 }
 
 func initialisePlayerDBFile(file *os.File) error {
@@ -95,7 +515,8 @@ func initialisePlayerDBFile(file *os.File) error {
 
 	// Path for an empty file (make an empty JSON for the rest of my code)
 	if info.Size() == 0 {
-		file.Write([]byte("[]"))
+		empty, _ := json.Marshal(newDatabase(League{}, nil))
+		file.Write(empty)
 		file.Seek(0, 0)
 	}
 	return nil
@@ -3,6 +3,7 @@ package httpserver
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -159,6 +160,11 @@ func createTempFile(t testing.TB, initialData string) (*os.File, func()) {
 	removeFile := func() {
 		tmpfile.Close()
 		os.Remove(tmpfile.Name())
+		// and anything the store kept next to it, like migration backups
+		sidecars, _ := filepath.Glob(tmpfile.Name() + ".*")
+		for _, sidecar := range sidecars {
+			os.Remove(sidecar)
+		}
 	}
 
 	return tmpfile, removeFile
@@ -0,0 +1,268 @@
+package httpserver
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Encoder writes players out in one response format
+type Encoder interface {
+	ContentType() string
+	EncodeLeague(w io.Writer, league League) error
+	EncodePlayer(w io.Writer, player Player) error
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]Encoder{}
+)
+
+// RegisterFormat makes an Encoder available as ?format=name and through the
+// Accept header, replacing any existing format with that name
+func RegisterFormat(name string, encoder Encoder) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[name] = encoder
+}
+
+// Formats lists the names of the registered formats
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupFormat(name string) (Encoder, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	encoder, ok := formats[name]
+	return encoder, ok
+}
+
+func init() {
+	RegisterFormat("json", jsonEncoder{})
+	RegisterFormat("csv", csvEncoder{})
+	RegisterFormat("xml", xmlEncoder{})
+	RegisterFormat("html", htmlEncoder{})
+	RegisterFormat("text", textEncoder{})
+	RegisterFormat("ndjson", ndjsonEncoder{})
+}
+
+// negotiateFormat picks the format for a response from ?format= or the Accept
+// header, using fallback when the client has no preference. Callers send
+// Vary: Accept so caches don't give one client's format to another.
+func negotiateFormat(r *http.Request, fallback string) (string, Encoder, bool) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		encoder, ok := lookupFormat(name)
+		return name, encoder, ok
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		encoder, ok := lookupFormat(fallback)
+		return fallback, encoder, ok
+	}
+
+	type mediaRange struct {
+		value string
+		q     float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		value, q := parseQuality(part)
+		if q > 0 && value != "" {
+			ranges = append(ranges, mediaRange{value, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	// try the fallback first so wildcards prefer it
+	names := append([]string{fallback}, Formats()...)
+	for _, accepted := range ranges {
+		for _, name := range names {
+			encoder, ok := lookupFormat(name)
+			if ok && mediaTypeMatches(accepted.value, encoder.ContentType()) {
+				return name, encoder, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// mediaTypeMatches checks a content type against an Accept media range such as "text/*"
+func mediaTypeMatches(accepted, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case accepted == "*/*":
+		return true
+	case strings.HasSuffix(accepted, "/*"):
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*"))
+	default:
+		return accepted == mediaType
+	}
+}
+
+// writeNotAcceptable tells the client which formats it could have asked for
+func writeNotAcceptable(w http.ResponseWriter) {
+	http.Error(w, fmt.Sprintf("unsupported format, try one of: %s", strings.Join(Formats(), ", ")), http.StatusNotAcceptable)
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return jsonContentType }
+
+func (jsonEncoder) EncodeLeague(w io.Writer, league League) error {
+	if league == nil {
+		league = League{}
+	}
+	return json.NewEncoder(w).Encode(league)
+}
+
+func (jsonEncoder) EncodePlayer(w io.Writer, player Player) error {
+	return json.NewEncoder(w).Encode(player)
+}
+
+// ndjsonContentType is newline delimited JSON, one player per line
+const ndjsonContentType = "application/x-ndjson"
+
+type ndjsonEncoder struct{}
+
+func (ndjsonEncoder) ContentType() string { return ndjsonContentType }
+
+func (ndjsonEncoder) EncodeLeague(w io.Writer, league League) error {
+	encoder := json.NewEncoder(w)
+	for _, player := range league {
+		err := encoder.Encode(player)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ndjsonEncoder) EncodePlayer(w io.Writer, player Player) error {
+	return json.NewEncoder(w).Encode(player)
+}
+
+type csvEncoder struct{}
+
+func (csvEncoder) ContentType() string { return "text/csv; charset=utf-8" }
+
+func (c csvEncoder) EncodeLeague(w io.Writer, league League) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"Name", "Wins"})
+	for _, player := range league {
+		writer.Write([]string{player.Name, strconv.Itoa(player.Wins)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (c csvEncoder) EncodePlayer(w io.Writer, player Player) error {
+	return c.EncodeLeague(w, League{player})
+}
+
+type xmlPlayer struct {
+	XMLName xml.Name `xml:"player"`
+	Name    string   `xml:"name"`
+	Wins    int      `xml:"wins"`
+}
+
+type xmlLeague struct {
+	XMLName xml.Name    `xml:"league"`
+	Players []xmlPlayer `xml:"player"`
+}
+
+type xmlEncoder struct{}
+
+func (xmlEncoder) ContentType() string { return "application/xml; charset=utf-8" }
+
+func (xmlEncoder) EncodeLeague(w io.Writer, league League) error {
+	doc := xmlLeague{}
+	for _, player := range league {
+		doc.Players = append(doc.Players, xmlPlayer{Name: player.Name, Wins: player.Wins})
+	}
+	return writeXML(w, doc)
+}
+
+func (xmlEncoder) EncodePlayer(w io.Writer, player Player) error {
+	return writeXML(w, xmlPlayer{Name: player.Name, Wins: player.Wins})
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	io.WriteString(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err := encoder.Encode(v)
+	io.WriteString(w, "\n")
+	return err
+}
+
+var leagueTable = template.Must(template.New("league").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>League</title></head>
+<body>
+<table>
+<thead><tr><th>Rank</th><th>Name</th><th>Wins</th></tr></thead>
+<tbody>
+{{- range $i, $player := . }}
+<tr><td>{{ inc $i }}</td><td>{{ $player.Name }}</td><td>{{ $player.Wins }}</td></tr>
+{{- end }}
+</tbody>
+</table>
+</body>
+</html>
+`))
+
+type htmlEncoder struct{}
+
+func (htmlEncoder) ContentType() string { return "text/html; charset=utf-8" }
+
+func (htmlEncoder) EncodeLeague(w io.Writer, league League) error {
+	return leagueTable.Execute(w, league)
+}
+
+func (htmlEncoder) EncodePlayer(w io.Writer, player Player) error {
+	return leagueTable.Execute(w, League{player})
+}
+
+type textEncoder struct{}
+
+func (textEncoder) ContentType() string { return "text/plain; charset=utf-8" }
+
+func (textEncoder) EncodeLeague(w io.Writer, league League) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, player := range league {
+		fmt.Fprintf(writer, "%s\t%d\n", player.Name, player.Wins)
+	}
+	return writer.Flush()
+}
+
+// EncodePlayer writes just the score, which is what /store/{name} has always returned
+func (textEncoder) EncodePlayer(w io.Writer, player Player) error {
+	_, err := fmt.Fprint(w, player.Wins)
+	return err
+}
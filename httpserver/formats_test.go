@@ -0,0 +1,162 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type upperEncoder struct{}
+
+func (upperEncoder) ContentType() string { return "text/x-upper" }
+
+func (upperEncoder) EncodeLeague(w io.Writer, league League) error {
+	for _, player := range league {
+		fmt.Fprintln(w, strings.ToUpper(player.Name))
+	}
+	return nil
+}
+
+func (upperEncoder) EncodePlayer(w io.Writer, player Player) error {
+	_, err := fmt.Fprint(w, strings.ToUpper(player.Name))
+	return err
+}
+
+func TestLeagueFormats(t *testing.T) {
+	league := League{{"Cleo", 32}, {"Chris", 20}}
+	store := StubPlayerStore{league: league}
+	server := NewPlayerServer(&store)
+
+	cases := []struct {
+		name        string
+		url         string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"csv from the query", "/list?format=csv", "", "text/csv; charset=utf-8", "Name,Wins\nCleo,32\nChris,20\n"},
+		{"text from the Accept header", "/list", "text/plain", "text/plain; charset=utf-8", "Cleo   32\nChris  20\n"},
+		{"xml from the Accept header", "/list", "application/xml", "application/xml; charset=utf-8",
+			"<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<league>\n  <player>\n    <name>Cleo</name>\n    <wins>32</wins>\n  </player>\n  <player>\n    <name>Chris</name>\n    <wins>20</wins>\n  </player>\n</league>\n"},
+		{"highest weighted Accept wins", "/list", "text/csv;q=0.5, application/xml;q=0.1", "text/csv; charset=utf-8", "Name,Wins\nCleo,32\nChris,20\n"},
+		{"query beats the Accept header", "/list?format=csv", "application/xml", "text/csv; charset=utf-8", "Name,Wins\nCleo,32\nChris,20\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodGet, c.url, nil)
+			if c.accept != "" {
+				request.Header.Set("Accept", c.accept)
+			}
+			response := httptest.NewRecorder()
+
+			server.ServeHTTP(response, request)
+
+			assertStatus(t, response.Code, http.StatusOK)
+			assertContentType(t, response, c.contentType)
+			assertResponseBody(t, response.Body.String(), c.body)
+			assertVaries(t, response, "Accept")
+		})
+	}
+
+	t.Run("html table", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/list", nil)
+		request.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertContentType(t, response, "text/html; charset=utf-8")
+		if !strings.Contains(response.Body.String(), "<tr><td>1</td><td>Cleo</td><td>32</td></tr>") {
+			t.Errorf("league table missing from %s", response.Body.String())
+		}
+	})
+
+	t.Run("wildcards get JSON", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/list", nil)
+		request.Header.Set("Accept", "*/*")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertContentType(t, response, jsonContentType)
+		assertLeague(t, getLeagueFromResponse(t, response.Body), league)
+	})
+
+	t.Run("unknown formats are not acceptable", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/list?format=yaml", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusNotAcceptable)
+	})
+
+	t.Run("new formats can be registered", func(t *testing.T) {
+		RegisterFormat("upper", upperEncoder{})
+		defer func() {
+			formatsMu.Lock()
+			delete(formats, "upper")
+			formatsMu.Unlock()
+		}()
+
+		request, _ := http.NewRequest(http.MethodGet, "/list", nil)
+		request.Header.Set("Accept", "text/x-upper")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertResponseBody(t, response.Body.String(), "CLEO\nCHRIS\n")
+	})
+}
+
+func TestPlayerFormats(t *testing.T) {
+	store := newStore(map[string]int{"Pepper": 20})
+	server := NewPlayerServer(&store)
+
+	t.Run("plain score by default", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newGetScoreRequest("Pepper"))
+
+		assertContentType(t, response, "text/plain; charset=utf-8")
+		assertResponseBody(t, response.Body.String(), "20")
+		assertVaries(t, response, "Accept")
+	})
+
+	t.Run("json player", func(t *testing.T) {
+		request := newGetScoreRequest("Pepper")
+		request.Header.Set("Accept", jsonContentType)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		var got Player
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&got))
+		if got != (Player{"Pepper", 20}) {
+			t.Errorf("got %v want Pepper with 20 wins", got)
+		}
+	})
+
+	t.Run("csv player", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/store/Pepper?format=csv", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertResponseBody(t, response.Body.String(), "Name,Wins\nPepper,20\n")
+	})
+}
+
+func assertVaries(t testing.TB, response *httptest.ResponseRecorder, header string) {
+	t.Helper()
+	for _, vary := range response.Header().Values("Vary") {
+		if vary == header {
+			return
+		}
+	}
+	t.Errorf("got Vary %v want it to include %s", response.Header().Values("Vary"), header)
+}
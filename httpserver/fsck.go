@@ -0,0 +1,239 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Kinds of Problem found in a database
+const (
+	ProblemChecksum     = "checksum"
+	ProblemDuplicate    = "duplicate"
+	ProblemNegativeWins = "negative_wins"
+	ProblemEmptyName    = "empty_name"
+)
+
+// ErrReadOnly is returned by writes to a store opened read only
+var ErrReadOnly = errors.New("database is read only until it is repaired")
+
+// Problem is something wrong with the contents of a database
+type Problem struct {
+	Kind   string `json:"kind"`
+	Player string `json:"player,omitempty"`
+	Detail string `json:"detail"`
+}
+
+func (p Problem) String() string {
+	if p.Player == "" {
+		return fmt.Sprintf("%s: %s", p.Kind, p.Detail)
+	}
+	return fmt.Sprintf("%s: %q %s", p.Kind, p.Player, p.Detail)
+}
+
+// CorruptDatabaseError is returned when a database fails its checks on load
+type CorruptDatabaseError struct {
+	Path     string
+	Problems []Problem
+}
+
+func (e *CorruptDatabaseError) Error() string {
+	found := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		found[i] = problem.String()
+	}
+	return fmt.Sprintf("database %s is corrupt: %s", e.Path, strings.Join(found, "; "))
+}
+
+// CheckLeague finds duplicate names, negative wins and empty names
+func CheckLeague(league League) []Problem {
+	problems := []Problem{}
+	seen := map[string]int{}
+
+	for _, player := range league {
+		if strings.TrimSpace(player.Name) == "" {
+			problems = append(problems, Problem{Kind: ProblemEmptyName, Detail: fmt.Sprintf("a player with %d wins has no name", player.Wins)})
+			continue
+		}
+		if player.Wins < 0 {
+			problems = append(problems, Problem{Kind: ProblemNegativeWins, Player: player.Name, Detail: fmt.Sprintf("has %d wins", player.Wins)})
+		}
+		seen[player.Name]++
+		if seen[player.Name] == 2 {
+			problems = append(problems, Problem{Kind: ProblemDuplicate, Player: player.Name, Detail: "appears more than once"})
+		}
+	}
+	return problems
+}
+
+// checkDatabase verifies the checksum as well as the league
+func checkDatabase(db database) []Problem {
+	problems := []Problem{}
+	if want := databaseChecksum(db.League, db.Profiles); db.Checksum != want {
+		detail := "does not match the contents, the file was changed outside the server"
+		if db.Checksum == "" {
+			detail = "is missing"
+		}
+		problems = append(problems, Problem{Kind: ProblemChecksum, Detail: detail})
+	}
+	return append(problems, CheckLeague(db.League)...)
+}
+
+// Ways RepairLeague can fix duplicates and negative wins
+const (
+	MergeDuplicates      = "merge"
+	KeepFirstDuplicate   = "first"
+	KeepHighestDuplicate = "highest"
+
+	ZeroNegativeWins = "zero"
+	DropNegativeWins = "drop"
+)
+
+// RepairOptions say how RepairLeague fixes each kind of problem. Players
+// without a name are always dropped.
+type RepairOptions struct {
+	// Duplicates is MergeDuplicates (add their wins up), KeepFirstDuplicate or KeepHighestDuplicate
+	Duplicates string `json:"duplicates"`
+	// NegativeWins is ZeroNegativeWins or DropNegativeWins
+	NegativeWins string `json:"negative_wins"`
+}
+
+// DefaultRepairOptions are used for anything left empty
+var DefaultRepairOptions = RepairOptions{Duplicates: MergeDuplicates, NegativeWins: ZeroNegativeWins}
+
+// Validate makes sure the options are ones RepairLeague knows
+func (o RepairOptions) Validate() error {
+	switch o.Duplicates {
+	case "", MergeDuplicates, KeepFirstDuplicate, KeepHighestDuplicate:
+	default:
+		return fmt.Errorf("duplicates must be %s, %s or %s, not %q", MergeDuplicates, KeepFirstDuplicate, KeepHighestDuplicate, o.Duplicates)
+	}
+	switch o.NegativeWins {
+	case "", ZeroNegativeWins, DropNegativeWins:
+	default:
+		return fmt.Errorf("negative wins must be %s or %s, not %q", ZeroNegativeWins, DropNegativeWins, o.NegativeWins)
+	}
+	return nil
+}
+
+// RepairLeague returns a copy of league with the problems CheckLeague finds fixed
+func RepairLeague(league League, options RepairOptions) League {
+	if options.Duplicates == "" {
+		options.Duplicates = DefaultRepairOptions.Duplicates
+	}
+	if options.NegativeWins == "" {
+		options.NegativeWins = DefaultRepairOptions.NegativeWins
+	}
+
+	repaired := League{}
+	at := map[string]int{}
+	for _, player := range league {
+		if strings.TrimSpace(player.Name) == "" {
+			continue
+		}
+		if player.Wins < 0 {
+			if options.NegativeWins == DropNegativeWins {
+				continue
+			}
+			player.Wins = 0
+		}
+
+		i, seen := at[player.Name]
+		if !seen {
+			at[player.Name] = len(repaired)
+			repaired = append(repaired, player)
+			continue
+		}
+		switch options.Duplicates {
+		case MergeDuplicates:
+			repaired[i].Wins += player.Wins
+		case KeepHighestDuplicate:
+			if player.Wins > repaired[i].Wins {
+				repaired[i].Wins = player.Wins
+			}
+		}
+	}
+	return repaired
+}
+
+// fsckHandler reports problems with the database (GET) or repairs them (POST)
+func (p *PlayerServer) fsckHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+
+	store, ok := p.Store.(Repairer)
+	if !ok {
+		http.Error(w, "store can't be checked", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		options := RepairOptions{
+			Duplicates:   r.URL.Query().Get("duplicates"),
+			NegativeWins: r.URL.Query().Get("negative_wins"),
+		}
+		if err := options.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		p.trackChanges(&JournalEntry{Op: OpRepair, Actor: p.identity(r)}, func() {
+			_, err = store.Repair(options)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("content-type", jsonContentType)
+	json.NewEncoder(w).Encode(FsckReport{ReadOnly: store.ReadOnly(), Problems: store.Problems()})
+}
+
+// FsckReport is the body of /admin/fsck
+type FsckReport struct {
+	ReadOnly bool      `json:"read_only"`
+	Problems []Problem `json:"problems"`
+}
+
+// Repairer is implemented by stores that can check and fix their own data
+type Repairer interface {
+	ReadOnly() bool
+	Problems() []Problem
+	Repair(options RepairOptions) ([]Problem, error)
+}
+
+// readOnlyPaths can still be changed while the store is read only, they are how it gets fixed
+var readOnlyPaths = map[string]bool{
+	"/login":         true,
+	"/shutdown":      true,
+	"/admin/backup":  true,
+	"/admin/restore": true,
+	"/admin/fsck":    true,
+	"/admin/export":  true,
+}
+
+// readOnly turns away changes while the store is read only
+func (p *PlayerServer) readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store, ok := p.Store.(Repairer)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ok = false
+		}
+		if ok && store.ReadOnly() && !readOnlyPaths[r.URL.Path] {
+			http.Error(w, "database is read only until it is repaired, see /admin/fsck", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,192 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// corruptDatabase is a current version file edited by hand, so its checksum
+// is stale and it has a duplicate, a negative score and a player with no name
+const corruptDatabase = `{
+	"version": 2,
+	"checksum": "sha256:675ad81c2ef69f8ad6f4bfd492b86fcac774d4e7ca8835b1d349e2f0114f40ca",
+	"league": [
+		{"Name": "Cleo", "Wins": 10},
+		{"Name": "Chris", "Wins": -3},
+		{"Name": "Cleo", "Wins": 4},
+		{"Name": "", "Wins": 7}
+	]
+}`
+
+func problemKinds(problems []Problem) []string {
+	kinds := []string{}
+	for _, problem := range problems {
+		kinds = append(kinds, problem.Kind)
+	}
+	return kinds
+}
+
+func TestCheckLeague(t *testing.T) {
+	t.Run("finds nothing wrong with a good league", func(t *testing.T) {
+		problems := CheckLeague(League{{"Cleo", 10}, {"Chris", 0}})
+		if len(problems) != 0 {
+			t.Errorf("got %v want no problems", problems)
+		}
+	})
+
+	t.Run("finds duplicates, negative wins and empty names", func(t *testing.T) {
+		problems := CheckLeague(League{{"Cleo", 10}, {"Chris", -3}, {"Cleo", 4}, {"Cleo", 1}, {" ", 7}})
+
+		got := problemKinds(problems)
+		want := []string{ProblemNegativeWins, ProblemDuplicate, ProblemEmptyName}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+}
+
+func TestRepairLeague(t *testing.T) {
+	league := League{{"Cleo", 10}, {"Chris", -3}, {"Cleo", 4}, {"", 7}, {"Cleo", 12}}
+
+	cases := map[string]struct {
+		options RepairOptions
+		want    League
+	}{
+		"defaults":       {RepairOptions{}, League{{"Cleo", 26}, {"Chris", 0}}},
+		"keep first":     {RepairOptions{Duplicates: KeepFirstDuplicate}, League{{"Cleo", 10}, {"Chris", 0}}},
+		"keep highest":   {RepairOptions{Duplicates: KeepHighestDuplicate}, League{{"Cleo", 12}, {"Chris", 0}}},
+		"drop negatives": {RepairOptions{NegativeWins: DropNegativeWins}, League{{"Cleo", 26}}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := RepairLeague(league, c.options)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v want %v", got, c.want)
+			}
+		})
+	}
+
+	t.Run("rejects unknown options", func(t *testing.T) {
+		err := RepairOptions{Duplicates: "shrug"}.Validate()
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestCorruptDatabase(t *testing.T) {
+	t.Run("refuses to open normally", func(t *testing.T) {
+		database, clean := createTempFile(t, corruptDatabase)
+		defer clean()
+
+		_, err := NewFileSystemPlayerStore(database)
+
+		var corrupt *CorruptDatabaseError
+		if !errors.As(err, &corrupt) {
+			t.Fatalf("got %v want a %T", err, corrupt)
+		}
+		got := problemKinds(corrupt.Problems)
+		want := []string{ProblemChecksum, ProblemNegativeWins, ProblemDuplicate, ProblemEmptyName}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("notices a value changed by hand", func(t *testing.T) {
+		database, clean := createTempFile(t, strings.Replace(`{
+	"version": 2,
+	"checksum": "sha256:675ad81c2ef69f8ad6f4bfd492b86fcac774d4e7ca8835b1d349e2f0114f40ca",
+	"league": [{"Name": "Cleo", "Wins": 10}, {"Name": "Chris", "Wins": 33}],
+	"profiles": {"Cleo": {"DisplayName": "Cleo the Great", "Team": "red"}}
+}`, "33", "34", 1))
+		defer clean()
+
+		_, err := NewFileSystemPlayerStore(database)
+
+		var corrupt *CorruptDatabaseError
+		if !errors.As(err, &corrupt) || !reflect.DeepEqual(problemKinds(corrupt.Problems), []string{ProblemChecksum}) {
+			t.Errorf("got %v want a checksum problem", err)
+		}
+	})
+
+	t.Run("serves reads but not writes when opened read only", func(t *testing.T) {
+		database, clean := createTempFile(t, corruptDatabase)
+		defer clean()
+
+		store, err := NewReadOnlyFileSystemPlayerStore(database, nil)
+		assertNoError(t, err)
+		server := NewPlayerServer(store)
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newGetScoreRequest("Cleo"))
+		assertStatus(t, response.Code, http.StatusOK)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newPostWinRequest("Cleo"))
+		assertStatus(t, response.Code, http.StatusServiceUnavailable)
+
+		err = store.UpdateLeague(func(league League) (League, error) { return league, nil })
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("got %v want %v", err, ErrReadOnly)
+		}
+	})
+
+	t.Run("is repaired through /admin/fsck", func(t *testing.T) {
+		database, clean := createTempFile(t, corruptDatabase)
+		defer clean()
+
+		store, err := NewReadOnlyFileSystemPlayerStore(database, nil)
+		assertNoError(t, err)
+		server := NewPlayerServer(store)
+
+		request, _ := http.NewRequest(http.MethodGet, "/admin/fsck", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, request))
+
+		var report FsckReport
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&report))
+		if !report.ReadOnly || len(report.Problems) != 4 {
+			t.Errorf("got %+v want read only with 4 problems", report)
+		}
+
+		request, _ = http.NewRequest(http.MethodPost, "/admin/fsck?duplicates=highest", nil)
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, request))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		report = FsckReport{}
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&report))
+		if report.ReadOnly || len(report.Problems) != 0 {
+			t.Errorf("got %+v want writable with no problems", report)
+		}
+		assertLeague(t, store.GetLeague(), League{{"Cleo", 10}, {"Chris", 0}})
+
+		copies, _ := filepath.Glob(database.Name() + ".fsck-*.bak")
+		if len(copies) != 1 {
+			t.Errorf("got %v want a copy of the corrupt file", copies)
+		}
+
+		reopened, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		assertScoreEquals(t, reopened.GetPlayerScore("Cleo"), 10)
+	})
+
+	t.Run("is not restored from a snapshot", func(t *testing.T) {
+		_, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		err := store.Restore(strings.NewReader(corruptDatabase))
+
+		var corrupt *CorruptDatabaseError
+		if !errors.As(err, &corrupt) {
+			t.Errorf("got %v want a %T", err, corrupt)
+		}
+	})
+}
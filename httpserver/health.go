@@ -0,0 +1,115 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// StoreChecker is implemented by stores that can verify their backing storage
+// is still usable
+type StoreChecker interface {
+	CheckRead() error
+	CheckWrite() error
+}
+
+// DatabasePather is implemented by stores that keep their data in a file
+type DatabasePather interface {
+	DatabasePath() string
+}
+
+// defaultMinDiskFree is used when PlayerServer.MinDiskFree is not set
+const defaultMinDiskFree = 1 << 20
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// CheckResult is the outcome of a single readiness check
+type CheckResult struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Path      string `json:"path,omitempty"`
+	FreeBytes uint64 `json:"free_bytes,omitempty"`
+}
+
+// Readiness is the body returned by /readyz
+type Readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// healthzHandler reports that the process is alive. It never touches the store.
+func (p *PlayerServer) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	fmt.Fprint(w, "ok")
+}
+
+// readyzHandler reports whether the server should receive traffic
+func (p *PlayerServer) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	readiness := p.checkReadiness()
+
+	w.Header().Set("content-type", jsonContentType)
+	if readiness.Status != checkOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(readiness)
+}
+
+func (p *PlayerServer) checkReadiness() Readiness {
+	checks := map[string]CheckResult{}
+
+	if atomic.LoadInt32(&p.draining) == 1 {
+		checks["shutdown"] = CheckResult{Status: checkFail, Error: "server is shutting down"}
+	} else {
+		checks["shutdown"] = CheckResult{Status: checkOK}
+	}
+
+	if checker, ok := p.Store.(StoreChecker); ok {
+		checks["store_read"] = resultFromError(checker.CheckRead())
+		checks["store_write"] = resultFromError(checker.CheckWrite())
+	}
+
+	if pather, ok := p.Store.(DatabasePather); ok {
+		checks["disk"] = p.checkDisk(filepath.Dir(pather.DatabasePath()))
+	}
+
+	readiness := Readiness{Status: checkOK, Checks: checks}
+	for _, check := range checks {
+		if check.Status != checkOK {
+			readiness.Status = checkFail
+		}
+	}
+	return readiness
+}
+
+func (p *PlayerServer) checkDisk(dir string) CheckResult {
+	free, err := diskFree(dir)
+	if err != nil {
+		return CheckResult{Status: checkFail, Path: dir, Error: err.Error()}
+	}
+
+	minFree := p.MinDiskFree
+	if minFree == 0 {
+		minFree = defaultMinDiskFree
+	}
+
+	result := CheckResult{Status: checkOK, Path: dir, FreeBytes: free}
+	if free < minFree {
+		result.Status = checkFail
+		result.Error = fmt.Sprintf("only %d bytes free, want at least %d", free, minFree)
+	}
+	return result
+}
+
+func resultFromError(err error) CheckResult {
+	if err != nil {
+		return CheckResult{Status: checkFail, Error: err.Error()}
+	}
+	return CheckResult{Status: checkOK}
+}
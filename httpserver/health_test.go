@@ -0,0 +1,153 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+type failingCheckStore struct {
+	StubPlayerStore
+	writeErr error
+}
+
+func (s *failingCheckStore) CheckRead() error  { return nil }
+func (s *failingCheckStore) CheckWrite() error { return s.writeErr }
+
+func TestHealthz(t *testing.T) {
+	store := newStore(map[string]int{})
+	server := NewPlayerServer(&store)
+
+	request, _ := http.NewRequest(http.MethodGet, "/healthz", nil)
+	response := httptest.NewRecorder()
+
+	server.ServeHTTP(response, request)
+
+	assertStatus(t, response.Code, http.StatusOK)
+	assertResponseBody(t, response.Body.String(), "ok")
+}
+
+func TestReadyz(t *testing.T) {
+	t.Run("reports each check for a file system store", func(t *testing.T) {
+		database, cleanDatabase := createTempFile(t, "[]")
+		defer cleanDatabase()
+
+		store, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		server := NewPlayerServer(store)
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newReadyzRequest())
+
+		assertStatus(t, response.Code, http.StatusOK)
+		assertContentType(t, response, jsonContentType)
+
+		got := getReadinessFromResponse(t, response)
+		for _, name := range []string{"shutdown", "store_read", "store_write", "disk"} {
+			check, ok := got.Checks[name]
+			if !ok {
+				t.Fatalf("missing %q check in %+v", name, got.Checks)
+			}
+			if check.Status != checkOK {
+				t.Errorf("check %q got status %q want %q (%s)", name, check.Status, checkOK, check.Error)
+			}
+		}
+		if got.Checks["disk"].FreeBytes == 0 {
+			t.Errorf("expected free disk space to be reported")
+		}
+	})
+
+	t.Run("fails when the store can't write", func(t *testing.T) {
+		store := &failingCheckStore{writeErr: errors.New("read-only file system")}
+		server := NewPlayerServer(store)
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newReadyzRequest())
+
+		assertStatus(t, response.Code, http.StatusServiceUnavailable)
+
+		got := getReadinessFromResponse(t, response)
+		if got.Checks["store_write"].Error != "read-only file system" {
+			t.Errorf("got store_write check %+v", got.Checks["store_write"])
+		}
+	})
+
+	t.Run("fails when the database file is read only", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can write to read only files")
+		}
+		database, cleanDatabase := createTempFile(t, "[]")
+		defer cleanDatabase()
+
+		store, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		defer store.Close()
+		assertNoError(t, os.Chmod(database.Name(), 0444))
+
+		if err := store.CheckWrite(); err == nil {
+			t.Error("got no error want the read only file reported")
+		}
+	})
+
+	t.Run("fails when the database file is gone", func(t *testing.T) {
+		database, cleanDatabase := createTempFile(t, "[]")
+		defer cleanDatabase()
+
+		store, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		defer store.Close()
+		assertNoError(t, os.Remove(database.Name()))
+
+		if err := store.CheckWrite(); err == nil {
+			t.Error("got no error want the missing file reported")
+		}
+	})
+
+	t.Run("fails when there is not enough disk space", func(t *testing.T) {
+		database, cleanDatabase := createTempFile(t, "[]")
+		defer cleanDatabase()
+
+		store, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		server := NewPlayerServer(store)
+		server.MinDiskFree = 1 << 62
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newReadyzRequest())
+
+		assertStatus(t, response.Code, http.StatusServiceUnavailable)
+	})
+
+	t.Run("fails while shutting down but stays alive", func(t *testing.T) {
+		store := newStore(map[string]int{})
+		server := NewPlayerServer(&store)
+		atomic.StoreInt32(&server.draining, 1)
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newReadyzRequest())
+		assertStatus(t, response.Code, http.StatusServiceUnavailable)
+
+		request, _ := http.NewRequest(http.MethodGet, "/healthz", nil)
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+	})
+}
+
+func newReadyzRequest() *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+	return req
+}
+
+func getReadinessFromResponse(t testing.TB, response *httptest.ResponseRecorder) (readiness Readiness) {
+	t.Helper()
+	err := json.NewDecoder(response.Body).Decode(&readiness)
+	if err != nil {
+		t.Fatalf("Unable to parse readiness from response, %v", err)
+	}
+	return
+}
@@ -1,12 +1,17 @@
 package httpserver
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 
@@ -20,12 +25,71 @@ type PlayerStore interface {
 	DeletePlayer(name string)
 }
 
+// FallibleStore is implemented by stores whose writes can fail, such as
+// RemotePlayerStore, so that handlers can report the failure
+type FallibleStore interface {
+	TryRecordWin(name string) error
+	TryRecordNewPlayer(player Player) error
+	TryDeletePlayer(name string) error
+}
+
+// LeagueUpdater is implemented by stores that can change the whole league at once.
+// Either all of the change is stored or none of it is.
+type LeagueUpdater interface {
+	UpdateLeague(update func(League) (League, error)) error
+}
+
 // PlayerServer is a HTTP interface for player information
 type PlayerServer struct {
 	Store PlayerStore
 	//http.Handler // Embedding - "PlayerServer" now has all the methods that http.handler has (ServeHTTP)
 	http.Server
 	// This is referenced with p.Handler in "NewPlayerServer"
+
+	// DrainDelay is how long Shutdown keeps serving with /readyz failing,
+	// so load balancers stop sending traffic before connections close
+	DrainDelay time.Duration
+	// MinDiskFree is the free space (in bytes) the database directory needs for /readyz to pass
+	MinDiskFree uint64
+
+	// TLSCertFile and TLSKeyFile turn on HTTPS. They are reloaded when they change on disk.
+	TLSCertFile string
+	TLSKeyFile  string
+	// RedirectAddr is an optional plain HTTP address that redirects everything to HTTPS
+	RedirectAddr string
+	// ClientCAFile turns on client certificate checks (mTLS) for admin routes
+	ClientCAFile string
+	// SessionTTL is how long a token from /login lasts
+	SessionTTL time.Duration
+	// RateLimits are checked in order, the first match applies. nil turns rate limiting off.
+	RateLimits []RouteLimit
+	// Rules every change to the league has to pass
+	Rules []Rule
+	// CORS lets browser frontends on other origins call the API. nil turns CORS off.
+	CORS *CORSConfig
+	// Compression of responses for clients that accept it. nil turns compression off.
+	Compression *CompressionConfig
+	// NormalizeNames matches player names ignoring case, so "pepper" and
+	// "Pepper" are the same player
+	NormalizeNames bool
+	// Backups is used by /admin/backup and /admin/restore. nil leaves them switched off.
+	Backups *BackupManager
+	// Webhooks sends league events to registered URLs. nil leaves /admin/webhooks switched off.
+	Webhooks *WebhookDispatcher
+	// Journal records changes so they can be reverted and keeps deleted players
+	// in a trash bin. NewPlayerServer keeps one in memory, nil switches it off.
+	Journal *Journal
+
+	sessions *sessions
+	limiter  *rateLimiter
+	events   *eventBroker
+	changes  sync.Mutex
+	// livePingInterval is how often /live connections are pinged
+	livePingInterval time.Duration
+	redirect *http.Server
+	draining int32
+	stopped chan struct{}
+	stopOnce sync.Once
 }
 
 // Player stores a name with a number of wins
@@ -43,36 +107,75 @@ const jsonContentType = "application/json"
 
 var goodUsernames = map[string]string{"user_a": "passwordA", "user_b": "passwordB", "user_c": "passwordC", "admin": "Password1"}
 
+// adminUsers may use the admin routes when no ClientCAFile is set
+var adminUsers = map[string]bool{"admin": true}
+
 // NewPlayerServer creates a PlayerServer with routing configured
 func NewPlayerServer(store PlayerStore) *PlayerServer {
 	p := new(PlayerServer)
 	p.Store = store
+	p.stopped = make(chan struct{})
+	p.sessions = newSessions()
+	p.SessionTTL = DefaultSessionTTL
+	p.limiter = newRateLimiter()
+	p.Journal, _ = NewJournal("")
+	p.events = newEventBroker(defaultEventBufferSize)
+	p.livePingInterval = defaultLivePingInterval
+	p.Compression = &CompressionConfig{MinSize: defaultCompressMinSize}
 	router := http.NewServeMux()
 	p.Addr = ":5000"
 	router.Handle("/list", http.HandlerFunc(p.listHandler))
 	router.Handle("/store/", http.HandlerFunc(p.playersHandler))
 	router.Handle("/login", http.HandlerFunc(p.loginHandler))
+	router.Handle("/logout", http.HandlerFunc(p.logoutHandler))
 	router.Handle("/ping", http.HandlerFunc(p.pingHandler))
-	router.Handle("/shutdown", http.HandlerFunc(p.shutdownHandler))
-
-
-	p.Handler = router // Can do this because NewServeMux has the method ServeHTTP
+	router.Handle("/shutdown", p.adminOnly(p.shutdownHandler))
+	router.Handle("/healthz", http.HandlerFunc(p.healthzHandler))
+	router.Handle("/readyz", http.HandlerFunc(p.readyzHandler))
+	router.Handle("/players/", http.HandlerFunc(p.profileHandler))
+	router.Handle("/batch", http.HandlerFunc(p.batchHandler))
+	router.Handle("/events", http.HandlerFunc(p.eventsHandler))
+	router.Handle("/live", http.HandlerFunc(p.liveHandler))
+	router.Handle("/admin/export", p.adminOnly(p.exportHandler))
+	router.Handle("/admin/import", p.adminOnly(p.importHandler))
+	router.Handle("/admin/rename", p.adminOnly(p.renameHandler))
+	router.Handle("/admin/merge", p.adminOnly(p.mergeHandler))
+	router.Handle("/admin/history", p.adminOnly(p.historyHandler))
+	router.Handle("/admin/backup", p.adminOnly(p.backupHandler))
+	router.Handle("/admin/restore", p.adminOnly(p.restoreHandler))
+	router.Handle("/admin/fsck", p.adminOnly(p.fsckHandler))
+	router.Handle("/admin/webhooks", p.adminOnly(p.webhooksHandler))
+	router.Handle("/admin/webhooks/", p.adminOnly(p.webhooksHandler))
+	router.Handle("/admin/journal", p.adminOnly(p.journalHandler))
+	router.Handle("/admin/journal/", p.adminOnly(p.journalHandler))
+	router.Handle("/admin/trash", p.adminOnly(p.trashHandler))
+	router.Handle("/admin/trash/", p.adminOnly(p.trashHandler))
+
+
+	p.Handler = p.cors(p.compress(p.rateLimit(p.readOnly(router)))) // Can do this because NewServeMux has the method ServeHTTP
 	return p
 }
 
+// ServeHTTP lets a PlayerServer be used directly as a http.Handler
+func (p *PlayerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.Handler.ServeHTTP(w, r)
+}
+
 	func (p *PlayerServer) playersHandler(w http.ResponseWriter, r *http.Request) {
 		log.Println(r.Method, r.URL, r.RemoteAddr)
-		player := strings.TrimPrefix(r.URL.Path, "/store/")
+		player := p.canonicalName(strings.TrimPrefix(r.URL.Path, "/store/"))
 
 		switch r.Method {
 		case http.MethodPost:
-			p.processWin(w, player)
+			p.processWin(w, r, player)
 		case http.MethodGet:
-			p.showScore(w, player)
+			p.showScore(w, r, player)
 		case http.MethodPut:
 			p.processNewPlayer(w, r)
 		case http.MethodDelete:
-			p.processDelete(w, player)
+			p.processDelete(w, r, player)
+		case http.MethodPatch:
+			p.processPatch(w, r, player)
 		}
 }
 
@@ -80,8 +183,15 @@ func NewPlayerServer(store PlayerStore) *PlayerServer {
 
 func (p *PlayerServer) listHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println(r.Method, r.URL, r.RemoteAddr)
-	w.Header().Set("content-type", jsonContentType)
-	json.NewEncoder(w).Encode(p.Store.GetLeague())
+	w.Header().Add("Vary", "Accept")
+	_, encoder, ok := negotiateFormat(r, "json")
+	if !ok {
+		writeNotAcceptable(w)
+		return
+	}
+
+	w.Header().Set("content-type", encoder.ContentType())
+	encoder.EncodeLeague(w, p.Store.GetLeague())
 
 	//w.WriteHeader(http.StatusOK)
 
@@ -114,13 +224,16 @@ func (p*PlayerServer) loginHandler(w http.ResponseWriter, r *http.Request){
 		w.WriteHeader(401)
 		return
 	}
-	//fmt.Printf("Username: %s\n", u)
-	//fmt.Printf("Password: %s\n", p)
+	token, err := p.sessions.issue(u, p.SessionTTL)
+	if err != nil {
+		fmt.Printf("There was an error creating a token: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Authorization", "Bearer "+token)
 	w.WriteHeader(200)
-	//r.Header.Set("Authorization", "Bearer gntkgmvjd")
-	w.Header().Set("Authorization", "Bearer gntkgmvjd")
-	w.Write([]byte("Bearer jfjklnbrepieb"))
-	return
+	w.Write([]byte("Bearer " + token))
 }
 
 func (p *PlayerServer) pingHandler(w http.ResponseWriter, r *http.Request){
@@ -131,51 +244,193 @@ func (p *PlayerServer) pingHandler(w http.ResponseWriter, r *http.Request){
 func (p *PlayerServer) shutdownHandler(w http.ResponseWriter, r *http.Request){
 	log.Println(r.Method, r.URL, r.RemoteAddr)
 
-	//Goroutine this
-	err := p.Shutdown(context.Background())
-	if err != nil{
-		fmt.Printf("There was an error in shutdown")
+	w.WriteHeader(http.StatusAccepted)
+
+	// Shutdown waits for this request to finish, so it can't run inline
+	go func() {
+		err := p.Shutdown(context.Background())
+		if err != nil {
+			fmt.Printf("There was an error in shutdown: %v\n", err)
+		}
+	}()
+}
+
+// Shutdown marks the server as not ready, keeps serving for DrainDelay and then
+// gracefully shuts down the underlying http.Server
+func (p *PlayerServer) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&p.draining, 1)
+	defer p.stopOnce.Do(func() { close(p.stopped) })
+	// event streams never finish on their own, end them so clients reconnect elsewhere
+	p.events.close()
+
+	if p.DrainDelay > 0 {
+		select {
+		case <-time.After(p.DrainDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+	if p.redirect != nil {
+		p.redirect.Shutdown(ctx)
+	}
+	if p.Webhooks != nil {
+		p.Webhooks.Stop()
+	}
+	return p.Server.Shutdown(ctx)
+}
+
+// Stopped is closed once a call to Shutdown has finished
+func (p *PlayerServer) Stopped() <-chan struct{} {
+	return p.stopped
 }
 
 func (p *PlayerServer) getLeagueTable() []Player {
 	return p.Store.GetLeague()
 }
 
-func (p *PlayerServer) showScore(w http.ResponseWriter, player string){
+func (p *PlayerServer) showScore(w http.ResponseWriter, r *http.Request, player string){
+	w.Header().Add("Vary", "Accept")
+	_, encoder, ok := negotiateFormat(r, "text")
+	if !ok {
+		writeNotAcceptable(w)
+		return
+	}
+
 	score := p.Store.GetPlayerScore(player)
 
 	if score == 0 {
-		w.Write([]byte("404 Not Found"))
 		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 Not Found"))
+		return
 	}
-	fmt.Fprint(w, score)
+
+	w.Header().Set("content-type", encoder.ContentType())
+	w.Header().Set("ETag", playerETag(Player{player, score}))
+	encoder.EncodePlayer(w, Player{player, score})
 }
 
-func (p *PlayerServer) processWin(w http.ResponseWriter, player string) {
-	p.Store.RecordWin(player) // First value stored in the spy is the name of the player
+func (p *PlayerServer) processWin(w http.ResponseWriter, r *http.Request, player string) {
+	check, ok := p.allowMutations(w, Mutation{Kind: MutationWin, Player: player})
+	if !ok {
+		return
+	}
+	var err error
+	p.trackChanges(&JournalEntry{Op: MutationWin, Actor: p.identity(r)}, func() {
+		err = p.recordWin(player) // First value stored in the spy is the name of the player
+	})
+	if err != nil {
+		check.release()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
 	w.WriteHeader(http.StatusAccepted)
 }
 
 func (p* PlayerServer) processNewPlayer(w http.ResponseWriter, r *http.Request){
-	var requestPlayer []Player
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&requestPlayer)
-	
+	requestPlayer, err := decodePlayers(r.Body)
+
 	if err != nil {
-		fmt.Print("There was an error when decoding the Body of a PUT request", err)
+		fmt.Println("There was an error when decoding the Body of a PUT request", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
-	
+
+	if p.NormalizeNames {
+		league := p.Store.GetLeague()
+		for i, player := range requestPlayer {
+			requestPlayer[i].Name = canonicalIn(league, player.Name)
+			// later players in the body match this spelling too
+			league = append(league, requestPlayer[i])
+		}
+	}
+
+	mutations := make([]Mutation, 0, len(requestPlayer))
 	for _, player := range requestPlayer {
-		p.Store.RecordNewPlayer(player)
+		mutations = append(mutations, Mutation{Kind: MutationSet, Player: player.Name, Wins: player.Wins})
+	}
+	check, ok := p.allowMutations(w, mutations...)
+	if !ok {
+		return
+	}
+	
+	p.trackChanges(&JournalEntry{Op: MutationSet, Actor: p.identity(r)}, func() {
+		for _, player := range requestPlayer {
+			err = p.recordNewPlayer(player)
+			if err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		check.release()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
 	}
 		w.WriteHeader(http.StatusAccepted)
 }
 
-func (p* PlayerServer) processDelete(w http.ResponseWriter, player string){
-	p.Store.DeletePlayer(player)
-	w.Write([]byte("OK"))
+func (p* PlayerServer) processDelete(w http.ResponseWriter, r *http.Request, player string){
+	check, ok := p.allowMutations(w, Mutation{Kind: MutationDelete, Player: player})
+	if !ok {
+		return
+	}
+	var err error
+	p.trackChanges(&JournalEntry{Op: MutationDelete, Actor: p.identity(r)}, func() {
+		err = p.deletePlayer(player)
+	})
+	if err != nil {
+		check.release()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
 	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("OK"))
+
+
+}
+
+// recordWin is Store.RecordWin, returning the error if the store can report one
+func (p *PlayerServer) recordWin(name string) error {
+	if store, ok := p.Store.(FallibleStore); ok {
+		return store.TryRecordWin(name)
+	}
+	p.Store.RecordWin(name)
+	return nil
+}
+
+// recordNewPlayer is Store.RecordNewPlayer, returning the error if the store can report one
+func (p *PlayerServer) recordNewPlayer(player Player) error {
+	if store, ok := p.Store.(FallibleStore); ok {
+		return store.TryRecordNewPlayer(player)
+	}
+	p.Store.RecordNewPlayer(player)
+	return nil
+}
+
+// deletePlayer is Store.DeletePlayer, returning the error if the store can report one
+func (p *PlayerServer) deletePlayer(name string) error {
+	if store, ok := p.Store.(FallibleStore); ok {
+		return store.TryDeletePlayer(name)
+	}
+	p.Store.DeletePlayer(name)
+	return nil
+}
 
+// decodePlayers reads either a single Player object or an array of them
+func decodePlayers(body io.Reader) ([]Player, error) {
+	var raw json.RawMessage
+	err := json.NewDecoder(body).Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
 
-}
\ No newline at end of file
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		var player Player
+		err = json.Unmarshal(raw, &player)
+		return []Player{player}, err
+	}
+
+	var players []Player
+	err = json.Unmarshal(raw, &players)
+	return players, err
+}
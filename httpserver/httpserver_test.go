@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type StubPlayerStore struct {
@@ -205,7 +207,83 @@ func TestScoreWins(t *testing.T){
 }
 
 func TestLogin(t *testing.T){
-	t.Run("")
+	store := newStore(map[string]int{})
+	server := NewPlayerServer(&store)
+
+	t.Run("accepts a known username and password", func(t *testing.T){
+		request := newLoginRequest("user_a", "passwordA")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusOK)
+
+		token := strings.TrimPrefix(response.Body.String(), "Bearer ")
+		if username, ok := server.sessions.lookup(token); !ok || username != "user_a" {
+			t.Errorf("login token %q does not belong to user_a", token)
+		}
+	})
+	t.Run("rejects a wrong password", func(t *testing.T){
+		request := newLoginRequest("user_a", "wrong")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+	t.Run("rejects an unknown user", func(t *testing.T){
+		request := newLoginRequest("nobody", "passwordA")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+	t.Run("rejects a request without basic auth", func(t *testing.T){
+		request, _ := http.NewRequest(http.MethodGet, "/login", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+	t.Run("tokens expire after SessionTTL", func(t *testing.T) {
+		clock := &fakeClock{current: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+		server := NewPlayerServer(&store)
+		server.sessions.now = clock.now
+		server.SessionTTL = time.Hour
+		token := loginToken(t, server)
+
+		clock.advance(59 * time.Minute)
+		if _, ok := server.sessions.lookup(token); !ok {
+			t.Fatal("token expired early")
+		}
+		clock.advance(time.Minute)
+		if _, ok := server.sessions.lookup(token); ok {
+			t.Error("token still works after SessionTTL")
+		}
+
+		loginToken(t, server)
+		if len(server.sessions.tokens) != 1 {
+			t.Errorf("got %d tokens kept want the expired one forgotten", len(server.sessions.tokens))
+		}
+	})
+	t.Run("logs out", func(t *testing.T) {
+		token := loginToken(t, server)
+		logout := func() int {
+			request, _ := http.NewRequest(http.MethodPost, "/logout", nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+			return response.Code
+		}
+
+		assertStatus(t, logout(), http.StatusNoContent)
+		if _, ok := server.sessions.lookup(token); ok {
+			t.Error("token still works after logging out")
+		}
+		assertStatus(t, logout(), http.StatusUnauthorized)
+	})
 }
 
 func TestLeague(t *testing.T){
@@ -254,6 +332,12 @@ func newPutPlayerRequest(name string, reqBody []byte) *http.Request{
 	return req
 }
 
+func newLoginRequest(username, password string) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "/login", nil)
+	req.SetBasicAuth(username, password)
+	return req
+}
+
 func assertResponseBody(t testing.TB, got string, want string){
 	t.Helper()
 	if want != got {
@@ -287,7 +371,7 @@ func assertLeague(t testing.TB, got []Player, want []Player){
 }
 
 func newLeagueRequest() *http.Request {
-	req, _ := http.NewRequest(http.MethodGet, "/league", nil)
+	req, _ := http.NewRequest(http.MethodGet, "/list", nil)
 	return req
 }
 
@@ -0,0 +1,318 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Import modes
+const (
+	ImportMerge   = "merge"
+	ImportReplace = "replace"
+	ImportDryRun  = "dry-run"
+)
+
+// maxImportSize stops an import from using unbounded memory
+const maxImportSize = 10 << 20
+
+// ImportRowError explains why one row of an import was rejected. Rows count from 1.
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportReport is returned by /admin/import
+type ImportReport struct {
+	Mode    string           `json:"mode"`
+	Applied bool             `json:"applied"`
+	Rows    int              `json:"rows"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Deleted int              `json:"deleted"`
+	Errors  []ImportRowError `json:"errors"`
+}
+
+// importRow is one decoded row, or the reason it couldn't be decoded
+type importRow struct {
+	player Player
+	err    error
+}
+
+// rowDecoder reads every row of an import in one format
+type rowDecoder func(r io.Reader) ([]importRow, error)
+
+var importDecoders = map[string]rowDecoder{
+	"json":   decodeJSONRows,
+	"csv":    decodeCSVRows,
+	"ndjson": decodeNDJSONRows,
+}
+
+var importContentTypes = map[string]string{
+	jsonContentType:   "json",
+	"text/csv":        "csv",
+	ndjsonContentType: "ndjson",
+}
+
+func decodeJSONRows(r io.Reader) ([]importRow, error) {
+	var raw []json.RawMessage
+	err := json.NewDecoder(r).Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("problem parsing JSON array, %v", err)
+	}
+
+	rows := make([]importRow, 0, len(raw))
+	for _, item := range raw {
+		rows = append(rows, decodeJSONRow(item))
+	}
+	return rows, nil
+}
+
+func decodeNDJSONRows(r io.Reader) ([]importRow, error) {
+	var rows []importRow
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		rows = append(rows, decodeJSONRow(line))
+	}
+	return rows, scanner.Err()
+}
+
+func decodeJSONRow(raw []byte) importRow {
+	var player Player
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&player)
+	if err != nil {
+		return importRow{err: fmt.Errorf("problem parsing player, %v", err)}
+	}
+	return importRow{player: player}
+}
+
+func decodeCSVRows(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("problem reading CSV header, %v", err)
+	}
+	if len(header) != 2 || !strings.EqualFold(header[0], "Name") || !strings.EqualFold(header[1], "Wins") {
+		return nil, fmt.Errorf("CSV header must be Name,Wins, got %s", strings.Join(header, ","))
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return nil, err
+			}
+			rows = append(rows, importRow{err: err})
+			continue
+		}
+		if len(record) != 2 {
+			rows = append(rows, importRow{err: fmt.Errorf("want 2 fields, got %d", len(record))})
+			continue
+		}
+
+		wins, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			rows = append(rows, importRow{player: Player{Name: record[0]}, err: fmt.Errorf("wins %q is not a number", record[1])})
+			continue
+		}
+		rows = append(rows, importRow{player: Player{record[0], wins}})
+	}
+}
+
+// importFormat works out the format of an import from ?format= or Content-Type
+func importFormat(r *http.Request) string {
+	if name := r.URL.Query().Get("format"); name != "" {
+		return name
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil {
+		if name, ok := importContentTypes[mediaType]; ok {
+			return name
+		}
+	}
+	return "json"
+}
+
+// exportHandler streams the whole league as a download
+func (p *PlayerServer) exportHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	w.Header().Add("Vary", "Accept")
+	name, encoder, ok := negotiateFormat(r, "json")
+	if !ok {
+		writeNotAcceptable(w)
+		return
+	}
+
+	w.Header().Set("content-type", encoder.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=league.%s", name))
+	encoder.EncodeLeague(w, p.Store.GetLeague())
+}
+
+// importHandler loads a league from the request body. Every row is validated
+// first and nothing is changed unless all of them are good.
+func (p *PlayerServer) importHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = ImportMerge
+	}
+	if mode != ImportMerge && mode != ImportReplace && mode != ImportDryRun {
+		http.Error(w, fmt.Sprintf("unknown import mode %q", mode), http.StatusBadRequest)
+		return
+	}
+
+	format := importFormat(r)
+	decode, ok := importDecoders[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("can't import format %q", format), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	updater, ok := p.Store.(LeagueUpdater)
+	if !ok {
+		http.Error(w, "store does not support imports", http.StatusNotImplemented)
+		return
+	}
+
+	rows, err := decode(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := ImportReport{Mode: mode, Rows: len(rows), Errors: []ImportRowError{}}
+	check := p.newRuleCheck()
+	err = p.updateLeague(&JournalEntry{Op: "import", Actor: p.identity(r)}, updater, func(league League) (League, error) {
+		imported := p.validateImport(rows, league, check, &report)
+		if len(report.Errors) > 0 {
+			return nil, errImportInvalid
+		}
+
+		updated := mergeLeague(league, imported, mode == ImportReplace, &report)
+		if mode == ImportDryRun {
+			return nil, errImportDryRun
+		}
+		return updated, nil
+	})
+	if err != nil {
+		check.release()
+	}
+
+	status := http.StatusOK
+	switch err {
+	case nil:
+		report.Applied = true
+	case errImportDryRun:
+	case errImportInvalid:
+		status = http.StatusUnprocessableEntity
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", jsonContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+var (
+	errImportInvalid = errors.New("import has invalid rows")
+	errImportDryRun  = errors.New("dry run, nothing applied")
+)
+
+// validateImport checks every row, recording problems in the report
+func (p *PlayerServer) validateImport(rows []importRow, league League, check *ruleCheck, report *ImportReport) League {
+	imported := League{}
+	seen := map[string]int{}
+
+	for i, row := range rows {
+		key := row.player.Name
+		if p.NormalizeNames {
+			row.player.Name = canonicalIn(league, row.player.Name)
+			key = strings.ToLower(key)
+		}
+		fail := func(err error) {
+			report.Errors = append(report.Errors, ImportRowError{Row: i + 1, Name: row.player.Name, Error: err.Error()})
+		}
+
+		switch {
+		case row.err != nil:
+			fail(row.err)
+		case strings.TrimSpace(row.player.Name) == "":
+			fail(errors.New("name is empty"))
+		case row.player.Wins < 0:
+			fail(fmt.Errorf("wins can't be negative, got %d", row.player.Wins))
+		case seen[key] > 0:
+			fail(fmt.Errorf("duplicate of row %d", seen[key]))
+		default:
+			err := check.check(Mutation{Kind: MutationSet, Player: row.player.Name, Wins: row.player.Wins}, league)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			seen[key] = i + 1
+			imported = append(imported, row.player)
+		}
+	}
+	return imported
+}
+
+// mergeLeague applies imported players on top of league, dropping everyone
+// else when replace is set
+func mergeLeague(league, imported League, replace bool, report *ImportReport) League {
+	result := League{}
+	if !replace {
+		result = append(result, league...)
+	} else {
+		for _, player := range league {
+			if found, _ := imported.Find(player.Name); found == nil {
+				report.Deleted++
+			}
+		}
+	}
+
+	for _, player := range imported {
+		existing, _ := league.Find(player.Name)
+		if existing == nil {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+
+		if found, idx := result.Find(player.Name); found != nil {
+			result[idx] = player
+		} else {
+			result = append(result, player)
+		}
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newFileSystemServer(t testing.TB, initialData string) (*PlayerServer, *FileSystemPlayerStore, func()) {
+	t.Helper()
+	database, cleanDatabase := createTempFile(t, initialData)
+
+	store, err := NewFileSystemPlayerStore(database)
+	assertNoError(t, err)
+	return NewPlayerServer(store), store, cleanDatabase
+}
+
+func newImportRequest(query, contentType, body string) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost, "/admin/import"+query, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
+
+func getImportReport(t testing.TB, body io.Reader) (report ImportReport) {
+	t.Helper()
+	err := json.NewDecoder(body).Decode(&report)
+	if err != nil {
+		t.Fatalf("Unable to parse import report, %v", err)
+	}
+	return
+}
+
+const importTestLeague = `[{"Name": "Cleo", "Wins": 10}, {"Name": "Chris", "Wins": 33}]`
+
+func TestExport(t *testing.T) {
+	server, _, clean := newFileSystemServer(t, importTestLeague)
+	defer clean()
+
+	cases := map[string]string{
+		"csv":    "Name,Wins\nChris,33\nCleo,10\n",
+		"ndjson": "{\"Name\":\"Chris\",\"Wins\":33}\n{\"Name\":\"Cleo\",\"Wins\":10}\n",
+		"json":   "[{\"Name\":\"Chris\",\"Wins\":33},{\"Name\":\"Cleo\",\"Wins\":10}]\n",
+	}
+
+	for format, want := range cases {
+		request, _ := http.NewRequest(http.MethodGet, "/admin/export?format="+format, nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, asAdmin(t, server, request))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		assertHeader(t, response, "Content-Disposition", "attachment; filename=league."+format)
+		assertResponseBody(t, response.Body.String(), want)
+		assertVaries(t, response, "Accept")
+	}
+}
+
+func TestImport(t *testing.T) {
+	t.Run("merges JSON into the league", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newImportRequest("", jsonContentType, `[{"Name": "Chris", "Wins": 40}, {"Name": "Pepper", "Wins": 2}]`)))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		report := getImportReport(t, response.Body)
+		if !report.Applied || report.Created != 1 || report.Updated != 1 {
+			t.Errorf("got report %+v", report)
+		}
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 40}, {"Cleo", 10}, {"Pepper", 2}})
+	})
+
+	t.Run("replaces the league from CSV", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newImportRequest("?mode=replace", "text/csv", "Name,Wins\nPepper,7\nFloyd,3\n")))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		report := getImportReport(t, response.Body)
+		if report.Deleted != 2 || report.Created != 2 {
+			t.Errorf("got report %+v", report)
+		}
+		assertLeague(t, store.GetLeague(), []Player{{"Pepper", 7}, {"Floyd", 3}})
+	})
+
+	t.Run("dry runs NDJSON without changing anything", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newImportRequest("?mode=dry-run&format=ndjson", "", "{\"Name\":\"Pepper\",\"Wins\":1}\n\n{\"Name\":\"Cleo\",\"Wins\":11}\n")))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		report := getImportReport(t, response.Body)
+		if report.Applied || report.Rows != 2 || report.Created != 1 || report.Updated != 1 {
+			t.Errorf("got report %+v", report)
+		}
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+	})
+
+	t.Run("reports every bad row and applies nothing", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		server.Rules = []Rule{NamePolicy{DefaultNamePattern}}
+
+		body := "Name,Wins\nPepper,7\n,3\nFloyd,-1\nPepper,2\nBob,lots\n<b>,1\n"
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newImportRequest("", "text/csv", body)))
+
+		assertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		report := getImportReport(t, response.Body)
+
+		var gotRows []int
+		for _, rowErr := range report.Errors {
+			gotRows = append(gotRows, rowErr.Row)
+		}
+		if want := []int{2, 3, 4, 5, 6}; !reflect.DeepEqual(gotRows, want) {
+			t.Errorf("got errors on rows %v want %v: %+v", gotRows, want, report.Errors)
+		}
+		if report.Applied {
+			t.Errorf("expected nothing to be applied")
+		}
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+	})
+
+	t.Run("is written to the database", func(t *testing.T) {
+		database, clean := createTempFile(t, importTestLeague)
+		defer clean()
+		store, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		server := NewPlayerServer(store)
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newImportRequest("?mode=replace", jsonContentType, `[{"Name": "Pepper", "Wins": 2}]`)))
+		assertStatus(t, response.Code, http.StatusOK)
+
+		reloaded, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		assertLeague(t, reloaded.GetLeague(), []Player{{"Pepper", 2}})
+	})
+
+	t.Run("needs a store that can update atomically", func(t *testing.T) {
+		store := newStore(map[string]int{})
+		server := NewPlayerServer(&store)
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newImportRequest("", jsonContentType, `[]`)))
+
+		assertStatus(t, response.Code, http.StatusNotImplemented)
+	})
+}
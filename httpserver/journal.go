@@ -0,0 +1,718 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ops recorded in the journal besides the kinds of Mutation
+const (
+	OpRevert   = "revert"
+	OpUndelete = "undelete"
+	OpMerge    = "merge"
+	OpRestore  = "restore"
+	OpRepair   = "repair"
+)
+
+// adminOps are the journal entries /admin/history lists
+var adminOps = map[string]bool{MutationRename: true, OpMerge: true, OpRestore: true, OpRepair: true}
+
+// defaultJournalSize is how many entries the journal keeps for undoing
+const defaultJournalSize = 1000
+
+// defaultTrashSize is how many deleted players the trash holds
+const defaultTrashSize = 1000
+
+// journalCompactEvery is how many changes are appended to the journal file
+// before it is rewritten with just the current state
+const journalCompactEvery = 1000
+
+// JournalEntry is one change to the league. Before and After hold only the
+// players it changed; a player missing from one of them did not exist then.
+type JournalEntry struct {
+	ID     int       `json:"id"`
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Actor  string    `json:"actor"`
+	Before []Player  `json:"before"`
+	After  []Player  `json:"after"`
+	// Reverts lists the entries an OpRevert undid
+	Reverts []int `json:"reverts,omitempty"`
+	// RevertedBy is the entry that undid this one
+	RevertedBy int `json:"reverted_by,omitempty"`
+	// Renamed maps the new name of each player the entry renamed to their old one
+	Renamed map[string]string `json:"renamed,omitempty"`
+}
+
+// rename notes that the entry renamed a player, following on from any earlier
+// rename of theirs in the same entry
+func (e *JournalEntry) rename(from, to string) {
+	if e.Renamed == nil {
+		e.Renamed = map[string]string{}
+	}
+	if original, ok := e.Renamed[from]; ok {
+		delete(e.Renamed, from)
+		from = original
+	}
+	if from != to {
+		e.Renamed[to] = from
+	}
+}
+
+// TrashedPlayer is a deleted player that can still be restored
+type TrashedPlayer struct {
+	Player
+	Deleted time.Time `json:"deleted"`
+	Actor   string    `json:"actor"`
+	EntryID int       `json:"entry_id"`
+}
+
+// journalState is everything a Journal knows
+type journalState struct {
+	NextID  int             `json:"next_id"`
+	Entries []JournalEntry  `json:"entries"`
+	Trash   []TrashedPlayer `json:"trash"`
+}
+
+// journalRecord is one line of a journal file: the whole state, written when
+// the file is compacted, or a change to apply on top of the lines before it
+type journalRecord struct {
+	State *journalState `json:"state,omitempty"`
+	Entry *JournalEntry `json:"entry,omitempty"`
+	Purge string        `json:"purge,omitempty"`
+}
+
+// Journal remembers recent changes to the league so they can be reverted,
+// and keeps deleted players in a trash bin until they are restored or purged.
+// Its file only has each change appended to it, and is rewritten from time
+// to time so it doesn't grow for ever.
+type Journal struct {
+	// Size is how many entries are kept, older ones can no longer be reverted
+	Size int
+	// TrashSize is how many deleted players are kept, the longest deleted are
+	// purged first. 0 keeps them all.
+	TrashSize int
+
+	path         string
+	key          *DatabaseKey
+	now          func() time.Time
+	compactEvery int
+	mu           sync.Mutex
+	state        journalState
+	lines        int
+}
+
+// NewJournal loads the journal saved at path. An empty path keeps it in memory only.
+func NewJournal(path string) (*Journal, error) {
+	return NewEncryptedJournal(path, nil)
+}
+
+// NewEncryptedJournal loads the journal saved at path, which is encrypted with
+// key as the database is, since it holds players' names and wins. A journal
+// saved in plaintext is encrypted the first time it is opened with a key.
+func NewEncryptedJournal(path string, key *DatabaseKey) (*Journal, error) {
+	j := &Journal{
+		Size:         defaultJournalSize,
+		TrashSize:    defaultTrashSize,
+		path:         path,
+		key:          key,
+		now:          time.Now,
+		compactEvery: journalCompactEvery,
+		state:        journalState{NextID: 1, Entries: []JournalEntry{}, Trash: []TrashedPlayer{}},
+	}
+	if path == "" {
+		return j, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem reading journal from %s, %v", path, err)
+	}
+
+	rewrite := false
+	lines := bytes.Split(raw, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		rewrite = rewrite || (key != nil && !isSealed(line))
+
+		err := j.replay(line)
+		if err != nil && i == len(lines)-1 {
+			// the last change was only partly written when the server stopped
+			log.Printf("dropping damaged last line of journal %s, %v", path, err)
+			rewrite = true
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("problem parsing journal in %s at line %d, %w", path, i+1, err)
+		}
+		j.lines++
+	}
+
+	if rewrite {
+		err = j.compact()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// replay applies one line of a journal file
+func (j *Journal) replay(line []byte) error {
+	plain, err := unseal(line, j.key)
+	if err != nil {
+		return err
+	}
+	var record journalRecord
+	err = json.Unmarshal(plain, &record)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case record.State != nil:
+		j.state = *record.State
+	case record.Entry != nil:
+		j.state.NextID = record.Entry.ID + 1
+		j.apply(*record.Entry)
+	case record.Purge != "":
+		j.purge(record.Purge)
+	default:
+		// journals from before changes were appended hold just the state
+		return json.Unmarshal(plain, &j.state)
+	}
+	return nil
+}
+
+// append adds a record to the end of the file, or rewrites the file once it
+// holds enough records. Callers hold j.mu.
+func (j *Journal) append(record journalRecord) error {
+	if j.path == "" {
+		return nil
+	}
+	if j.lines >= j.compactEvery {
+		return j.compact()
+	}
+
+	line, err := j.encode(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("problem opening journal %s, %v", j.path, err)
+	}
+	_, err = file.Write(line)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("problem saving journal to %s, %v", j.path, err)
+	}
+	j.lines++
+	return nil
+}
+
+// compact rewrites the file as a single record of the whole state. Callers hold j.mu.
+func (j *Journal) compact() error {
+	if j.path == "" {
+		return nil
+	}
+	line, err := j.encode(journalRecord{State: &j.state})
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(j.path, line)
+	if err != nil {
+		return fmt.Errorf("problem saving journal to %s, %v", j.path, err)
+	}
+	j.lines = 1
+	return nil
+}
+
+// encode turns a record into a line of the file, encrypted if there is a key
+func (j *Journal) encode(record journalRecord) ([]byte, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if j.key != nil {
+		return j.key.seal(line)
+	}
+	return append(line, '\n'), nil
+}
+
+// Rekey rewrites the journal file encrypted with key, or in plaintext when key is nil
+func (j *Journal) Rekey(key *DatabaseKey) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	old := j.key
+	j.key = key
+	err := j.compact()
+	if err != nil {
+		j.key = old
+	}
+	return err
+}
+
+// record numbers and stores entry
+func (j *Journal) record(entry JournalEntry) JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry.ID = j.state.NextID
+	entry.Time = j.now()
+	j.state.NextID++
+	j.apply(entry)
+
+	if err := j.append(journalRecord{Entry: &entry}); err != nil {
+		log.Println(err)
+	}
+	return entry
+}
+
+// apply adds a numbered entry to the state. Players the entry removed from the
+// league go in the trash, whatever removed them, and anything entry reverts or
+// undeletes is taken back out. Callers hold j.mu.
+func (j *Journal) apply(entry JournalEntry) {
+	for i := range j.state.Entries {
+		for _, id := range entry.Reverts {
+			if j.state.Entries[i].ID == id {
+				j.state.Entries[i].RevertedBy = entry.ID
+			}
+		}
+	}
+
+	trash := j.state.Trash[:0]
+	for _, trashed := range j.state.Trash {
+		if containsID(entry.Reverts, trashed.EntryID) {
+			continue
+		}
+		if restored, _ := League(entry.After).Find(trashed.Name); entry.Op == OpUndelete && restored != nil {
+			continue
+		}
+		trash = append(trash, trashed)
+	}
+	j.state.Trash = trash
+
+	renamedFrom := map[string]bool{}
+	for _, oldName := range entry.Renamed {
+		renamedFrom[oldName] = true
+	}
+	for _, player := range entry.Before {
+		if kept, _ := League(entry.After).Find(player.Name); kept == nil && !renamedFrom[player.Name] {
+			j.state.Trash = append(j.state.Trash, TrashedPlayer{Player: player, Deleted: entry.Time, Actor: entry.Actor, EntryID: entry.ID})
+		}
+	}
+	if j.TrashSize > 0 && len(j.state.Trash) > j.TrashSize {
+		j.state.Trash = append([]TrashedPlayer{}, j.state.Trash[len(j.state.Trash)-j.TrashSize:]...)
+	}
+
+	j.state.Entries = append(j.state.Entries, entry)
+	if j.Size > 0 && len(j.state.Entries) > j.Size {
+		j.state.Entries = append([]JournalEntry{}, j.state.Entries[len(j.state.Entries)-j.Size:]...)
+	}
+}
+
+func containsID(ids []int, id int) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries returns up to limit entries, newest first. A limit of 0 returns them all.
+func (j *Journal) Entries(limit int) []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := j.state.Entries
+	if limit <= 0 || limit > len(entries) {
+		limit = len(entries)
+	}
+	result := make([]JournalEntry, 0, limit)
+	for i := len(entries) - 1; i >= len(entries)-limit; i-- {
+		result = append(result, entries[i])
+	}
+	return result
+}
+
+// toRevert returns the entries to undo, newest first: entry id on its own, or
+// when id is 0 every entry after the entry numbered after that is still in effect
+func (j *Journal) toRevert(id int, after int) ([]JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := j.state.Entries
+	if id > 0 {
+		for _, entry := range entries {
+			if entry.ID != id {
+				continue
+			}
+			if entry.RevertedBy != 0 {
+				return nil, newOperationError(http.StatusConflict, "entry %d was already reverted by entry %d", id, entry.RevertedBy)
+			}
+			return []JournalEntry{entry}, nil
+		}
+		return nil, newOperationError(http.StatusNotFound, "entry %d is not in the journal", id)
+	}
+
+	if len(entries) > 0 && after < entries[0].ID-1 {
+		return nil, newOperationError(http.StatusNotFound, "entries before %d are no longer kept", entries[0].ID)
+	}
+	// a revert inside the range cancels out the entries it undid, unless it also
+	// undid something before the range and so has to be undone itself
+	result := []JournalEntry{}
+	included := map[int]bool{}
+	for i := len(entries) - 1; i >= 0 && entries[i].ID > after; i-- {
+		entry := entries[i]
+		if entry.RevertedBy != 0 && !included[entry.RevertedBy] {
+			continue
+		}
+		if entry.Op == OpRevert && !revertsBefore(entry, after) {
+			continue
+		}
+		included[entry.ID] = true
+		result = append(result, entry)
+	}
+	if len(result) == 0 {
+		return nil, newOperationError(http.StatusConflict, "nothing to revert after entry %d", after)
+	}
+	return result, nil
+}
+
+// revertsBefore reports whether a revert undid any entry numbered at most id
+func revertsBefore(entry JournalEntry, id int) bool {
+	for _, reverted := range entry.Reverts {
+		if reverted <= id {
+			return true
+		}
+	}
+	return false
+}
+
+// Trash returns the deleted players that can be restored, most recent first
+func (j *Journal) Trash() []TrashedPlayer {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	trash := append([]TrashedPlayer{}, j.state.Trash...)
+	sort.SliceStable(trash, func(a, b int) bool { return trash[a].EntryID > trash[b].EntryID })
+	return trash
+}
+
+// trashed returns the most recently deleted player called name
+func (j *Journal) trashed(name string) (TrashedPlayer, bool) {
+	for _, player := range j.Trash() {
+		if player.Name == name {
+			return player, true
+		}
+	}
+	return TrashedPlayer{}, false
+}
+
+// Purge removes a player from the trash for good
+func (j *Journal) Purge(name string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.purge(name) {
+		return false
+	}
+	if err := j.append(journalRecord{Purge: name}); err != nil {
+		log.Println(err)
+	}
+	return true
+}
+
+// purge takes every player called name out of the trash. Callers hold j.mu.
+func (j *Journal) purge(name string) bool {
+	trash := j.state.Trash[:0]
+	for _, player := range j.state.Trash {
+		if player.Name != name {
+			trash = append(trash, player)
+		}
+	}
+	purged := len(trash) != len(j.state.Trash)
+	j.state.Trash = trash
+	return purged
+}
+
+// changedPlayers returns the players that differ between before and after,
+// as they were in each
+func changedPlayers(before, after League) ([]Player, []Player) {
+	changedBefore, changedAfter := []Player{}, []Player{}
+	for _, player := range before {
+		if found, _ := after.Find(player.Name); found == nil || found.Wins != player.Wins {
+			changedBefore = append(changedBefore, player)
+		}
+	}
+	for _, player := range after {
+		if found, _ := before.Find(player.Name); found == nil || found.Wins != player.Wins {
+			changedAfter = append(changedAfter, player)
+		}
+	}
+	return changedBefore, changedAfter
+}
+
+// revertEntries undoes entries, newest first. Wins are put back by the amount
+// each entry changed them, so later changes to the same players are kept, and
+// renamed players are renamed back. A player an entry created loses the wins
+// they were created with, and is only removed when that leaves them none. It
+// returns the renames it made, as JournalEntry.Renamed.
+func revertEntries(league League, entries []JournalEntry) (League, map[string]string, error) {
+	var undo JournalEntry
+	for _, entry := range entries {
+		renamedFrom := map[string]bool{}
+		for _, oldName := range entry.Renamed {
+			renamedFrom[oldName] = true
+		}
+
+		for _, after := range entry.After {
+			current, idx := league.Find(after.Name)
+			oldName, renamed := entry.Renamed[after.Name]
+			if !renamed {
+				oldName = after.Name
+			}
+			before, _ := League(entry.Before).Find(oldName)
+			switch {
+			case current == nil:
+				return nil, nil, newOperationError(http.StatusConflict, "player %s changed by entry %d no longer exists", after.Name, entry.ID)
+			case before == nil:
+				if current.Wins <= after.Wins {
+					league = append(league[:idx], league[idx+1:]...)
+					continue
+				}
+				current.Wins -= after.Wins
+				continue
+			case renamed:
+				if existing, _ := league.Find(oldName); existing != nil {
+					return nil, nil, newOperationError(http.StatusConflict, "can't rename %s back, a player called %s exists again", after.Name, oldName)
+				}
+				current.Name = oldName
+				undo.rename(after.Name, oldName)
+			}
+			current.Wins += before.Wins - after.Wins
+			if current.Wins < 0 {
+				current.Wins = 0
+			}
+		}
+		for _, before := range entry.Before {
+			if stayed, _ := League(entry.After).Find(before.Name); stayed != nil || renamedFrom[before.Name] {
+				continue
+			}
+			if existing, _ := league.Find(before.Name); existing != nil {
+				return nil, nil, newOperationError(http.StatusConflict, "player %s removed by entry %d exists again", before.Name, entry.ID)
+			}
+			league = append(league, before)
+		}
+	}
+	return league, undo.Renamed, nil
+}
+
+// RevertRequest is the body of POST /admin/journal/revert. Set ID to undo one
+// entry, or After to undo everything after it.
+type RevertRequest struct {
+	ID    int  `json:"id"`
+	After *int `json:"after"`
+}
+
+// TrashRestoreRequest is the body of POST /admin/trash/restore
+type TrashRestoreRequest struct {
+	Name string `json:"name"`
+}
+
+// journalHandler serves GET /admin/journal?limit=N and POST /admin/journal/revert
+func (p *PlayerServer) journalHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	if p.Journal == nil {
+		http.Error(w, "journal is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	if r.URL.Path == "/admin/journal/revert" {
+		p.revert(w, r)
+		return
+	}
+	if r.URL.Path != "/admin/journal" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	w.Header().Set("content-type", jsonContentType)
+	json.NewEncoder(w).Encode(p.Journal.Entries(limit))
+}
+
+// historyHandler lists the journal's administrative changes (renames, merges,
+// restores and repairs), newest first
+func (p *PlayerServer) historyHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	if p.Journal == nil {
+		http.Error(w, "journal is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	entries := []JournalEntry{}
+	for _, entry := range p.Journal.Entries(0) {
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+		if adminOps[entry.Op] {
+			entries = append(entries, entry)
+		}
+	}
+	w.Header().Set("content-type", jsonContentType)
+	json.NewEncoder(w).Encode(entries)
+}
+
+// revert undoes one journal entry, or everything after one, as a single
+// change that is itself recorded in the journal
+func (p *PlayerServer) revert(w http.ResponseWriter, r *http.Request) {
+	var request RevertRequest
+	if !decodeAdminRequest(w, r, &request) {
+		return
+	}
+	if (request.ID > 0) == (request.After != nil) {
+		http.Error(w, "give either id or after", http.StatusBadRequest)
+		return
+	}
+	after := 0
+	if request.After != nil {
+		after = *request.After
+	}
+
+	updater, ok := p.Store.(LeagueUpdater)
+	if !ok {
+		http.Error(w, "store does not support atomic updates", http.StatusNotImplemented)
+		return
+	}
+
+	var entries []JournalEntry
+	var err error
+	entry := JournalEntry{Op: OpRevert, Actor: p.identity(r)}
+	p.trackChanges(&entry, func() {
+		entries, err = p.Journal.toRevert(request.ID, after)
+		if err != nil {
+			return
+		}
+		for _, reverted := range entries {
+			entry.Reverts = append(entry.Reverts, reverted.ID)
+		}
+		err = applyUpdate(&entry, updater, func(league League) (League, error) {
+			reverted, renamed, err := revertEntries(league, entries)
+			entry.Renamed = renamed
+			return reverted, err
+		})
+	})
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("content-type", jsonContentType)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// trashHandler serves GET /admin/trash, POST /admin/trash/restore and
+// DELETE /admin/trash/{name}
+func (p *PlayerServer) trashHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	if p.Journal == nil {
+		http.Error(w, "journal is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/admin/trash" && r.Method == http.MethodGet:
+		w.Header().Set("content-type", jsonContentType)
+		json.NewEncoder(w).Encode(p.Journal.Trash())
+
+	case r.URL.Path == "/admin/trash/restore":
+		p.undelete(w, r)
+
+	case strings.HasPrefix(r.URL.Path, "/admin/trash/") && r.Method == http.MethodDelete:
+		name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/admin/trash/"))
+		if !p.Journal.Purge(name) {
+			http.Error(w, fmt.Sprintf("player %s is not in the trash", name), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// undelete puts a player from the trash back in the league with the wins they had
+func (p *PlayerServer) undelete(w http.ResponseWriter, r *http.Request) {
+	var request TrashRestoreRequest
+	if !decodeAdminRequest(w, r, &request) {
+		return
+	}
+
+	updater, ok := p.Store.(LeagueUpdater)
+	if !ok {
+		http.Error(w, "store does not support atomic updates", http.StatusNotImplemented)
+		return
+	}
+
+	var restored TrashedPlayer
+	check := p.newRuleCheck()
+	err := p.updateLeague(&JournalEntry{Op: OpUndelete, Actor: p.identity(r)}, updater, func(league League) (League, error) {
+		trashed, ok := p.Journal.trashed(request.Name)
+		if !ok {
+			return nil, newOperationError(http.StatusNotFound, "player %s is not in the trash", request.Name)
+		}
+		if existing, _ := league.Find(trashed.Name); existing != nil {
+			return nil, newOperationError(http.StatusConflict, "a player called %s already exists", trashed.Name)
+		}
+		err := check.check(Mutation{Kind: MutationSet, Player: trashed.Name, Wins: trashed.Wins}, league)
+		if err != nil {
+			return nil, err
+		}
+		restored = trashed
+		return append(league, trashed.Player), nil
+	})
+	if err != nil {
+		check.release()
+		if ruleErr, ok := err.(*RuleError); ok {
+			writeRuleError(w, ruleErr)
+			return
+		}
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("content-type", jsonContentType)
+	json.NewEncoder(w).Encode(restored.Player)
+}
@@ -0,0 +1,390 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newDeletePlayerRequest(name string) *http.Request {
+	req, _ := http.NewRequest(http.MethodDelete, "/store/"+name, nil)
+	return req
+}
+
+func getJournal(t testing.TB, server *PlayerServer, query string) (entries []JournalEntry) {
+	t.Helper()
+	request, _ := http.NewRequest(http.MethodGet, "/admin/journal"+query, nil)
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, asAdmin(t, server, request))
+	assertStatus(t, response.Code, http.StatusOK)
+
+	err := json.NewDecoder(response.Body).Decode(&entries)
+	if err != nil {
+		t.Fatalf("Unable to parse journal, %v", err)
+	}
+	return
+}
+
+func revertRequest(t testing.TB, server *PlayerServer, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/journal/revert", body)))
+	return response
+}
+
+func TestTrash(t *testing.T) {
+	t.Run("keeps deleted players so they can be restored", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newDeletePlayerRequest("Cleo"))
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}})
+
+		trash := server.Journal.Trash()
+		if len(trash) != 1 || trash[0].Player != (Player{"Cleo", 10}) {
+			t.Fatalf("got trash %+v want Cleo with 10 wins", trash)
+		}
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/trash/restore", `{"name": "Cleo"}`)))
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+
+		if trash := server.Journal.Trash(); len(trash) != 0 {
+			t.Errorf("got trash %+v want it empty after restoring", trash)
+		}
+		if entries := server.Journal.Entries(1); entries[0].Op != OpUndelete {
+			t.Errorf("got %+v want the restore recorded", entries[0])
+		}
+	})
+
+	t.Run("won't restore over a player with the same name", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newDeletePlayerRequest("Cleo"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/trash/restore", `{"name": "Cleo"}`)))
+		assertStatus(t, response.Code, http.StatusConflict)
+	})
+
+	t.Run("purges players for good", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newDeletePlayerRequest("Cleo"))
+
+		request, _ := http.NewRequest(http.MethodDelete, "/admin/trash/Cleo", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, request))
+		assertStatus(t, response.Code, http.StatusNoContent)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/trash/restore", `{"name": "Cleo"}`)))
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func TestJournal(t *testing.T) {
+	t.Run("lists changes newest first", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+		server.ServeHTTP(httptest.NewRecorder(), newDeletePlayerRequest("Chris"))
+		server.ServeHTTP(httptest.NewRecorder(), newDeletePlayerRequest("Nobody"))
+
+		entries := getJournal(t, server, "?limit=5")
+		if len(entries) != 2 {
+			t.Fatalf("got %d entries want 2, deleting nobody changes nothing: %+v", len(entries), entries)
+		}
+		if entries[0].Op != MutationDelete || entries[1].Op != MutationWin || entries[1].ID != 1 {
+			t.Errorf("got %+v want the delete then the win", entries)
+		}
+		assertLeague(t, entries[1].Before, []Player{{"Cleo", 10}})
+		assertLeague(t, entries[1].After, []Player{{"Cleo", 11}})
+		assertLeague(t, entries[0].After, []Player{})
+	})
+
+	t.Run("reverts one change and keeps later ones", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Chris"))
+
+		response := revertRequest(t, server, `{"id": 1}`)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 34}, {"Cleo", 11}})
+
+		var entry JournalEntry
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&entry))
+		if entry.Op != OpRevert || len(entry.Reverts) != 1 || entry.Reverts[0] != 1 {
+			t.Errorf("got %+v want a revert of entry 1", entry)
+		}
+
+		response = revertRequest(t, server, `{"id": 1}`)
+		assertStatus(t, response.Code, http.StatusConflict)
+	})
+
+	t.Run("reverts everything after a point", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+		server.ServeHTTP(httptest.NewRecorder(), newDeletePlayerRequest("Chris"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Pepper"))
+		server.ServeHTTP(httptest.NewRecorder(), asAdmin(t, server, newAdminPostRequest("/admin/rename", `{"from": "Cleo", "to": "Cleopatra"}`)))
+
+		response := revertRequest(t, server, `{"after": 1}`)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 11}})
+
+		// Pepper was removed by the revert, Chris came back out of the trash
+		if trash := server.Journal.Trash(); len(trash) != 1 || trash[0].Name != "Pepper" {
+			t.Errorf("got trash %+v want only Pepper", trash)
+		}
+	})
+
+	t.Run("keeps later wins when reverting a player's creation", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newPutPlayerRequest("", []byte(`{"Name": "Pepper", "Wins": 5}`)))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Pepper"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Pepper"))
+
+		response := revertRequest(t, server, `{"id": 1}`)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}, {"Pepper", 2}})
+	})
+
+	t.Run("trashes players removed by any change", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/merge", `{"from": "Cleo", "into": "Chris"}`)))
+		assertStatus(t, response.Code, http.StatusOK)
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newBatchRequest(t, Operation{Op: MutationDelete, Name: "Chris"}))
+		assertStatus(t, response.Code, http.StatusOK)
+
+		trash := server.Journal.Trash()
+		if len(trash) != 2 || trash[0].Name != "Chris" || trash[0].Wins != 43 || trash[1].Name != "Cleo" {
+			t.Errorf("got trash %+v want Chris then Cleo", trash)
+		}
+	})
+
+	t.Run("renames back and keeps wins since the rename", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), asAdmin(t, server, newAdminPostRequest("/admin/rename", `{"from": "Cleo", "to": "Cleopatra"}`)))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleopatra"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleopatra"))
+
+		response := revertRequest(t, server, `{"id": 1}`)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 12}})
+
+		response = revertRequest(t, server, `{"id": 4}`)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleopatra", 12}})
+	})
+
+	t.Run("renames back players renamed in a batch", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newBatchRequest(t,
+			Operation{Op: "rename", Name: "Cleo", To: "Cleopatra"},
+			Operation{Op: "win", Name: "Cleopatra"},
+			Operation{Op: "rename", Name: "Cleopatra", To: "Queen"},
+		))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Queen"))
+
+		response := revertRequest(t, server, `{"id": 1}`)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 11}})
+	})
+
+	t.Run("treats a revert and what it undid as cancelled out", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+		revertRequest(t, server, `{"id": 1}`)
+
+		response := revertRequest(t, server, `{"after": 0}`)
+		assertStatus(t, response.Code, http.StatusConflict)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+	})
+
+	t.Run("redoes a change by reverting its revert", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newDeletePlayerRequest("Cleo"))
+		revertRequest(t, server, `{"id": 1}`)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+
+		response := revertRequest(t, server, `{"id": 2}`)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}})
+	})
+
+	t.Run("needs exactly one of id and after", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := revertRequest(t, server, `{"id": 1, "after": 0}`)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+		response = revertRequest(t, server, `{}`)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("can't revert entries that are no longer kept", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		server.Journal.Size = 1
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+
+		response := revertRequest(t, server, `{"id": 1}`)
+		assertStatus(t, response.Code, http.StatusNotFound)
+		response = revertRequest(t, server, `{"after": 0}`)
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("is kept across restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "journal.json")
+		journal, err := NewJournal(path)
+		assertNoError(t, err)
+		journal.record(JournalEntry{Op: MutationDelete, Actor: "ip:127.0.0.1", Before: []Player{{"Cleo", 10}}, After: []Player{}})
+
+		journal, err = NewJournal(path)
+		assertNoError(t, err)
+		if entries := journal.Entries(0); len(entries) != 1 || entries[0].ID != 1 {
+			t.Errorf("got %+v want the delete back", entries)
+		}
+		if trash := journal.Trash(); len(trash) != 1 || trash[0].Name != "Cleo" {
+			t.Errorf("got trash %+v want Cleo", trash)
+		}
+
+		journal.record(JournalEntry{Op: MutationWin, Before: []Player{}, After: []Player{{"Pepper", 1}}})
+		if entries := journal.Entries(0); entries[0].ID != 2 {
+			t.Errorf("got id %d want numbering to carry on from 2", entries[0].ID)
+		}
+	})
+
+	t.Run("is encrypted with the database key", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "journal.json")
+		key := newTestKey(t)
+		journal, err := NewEncryptedJournal(path, key)
+		assertNoError(t, err)
+		journal.record(JournalEntry{Op: MutationDelete, Before: []Player{{"Cleo", 10}}, After: []Player{}})
+
+		raw, err := os.ReadFile(path)
+		assertNoError(t, err)
+		if bytes.Contains(raw, []byte("Cleo")) {
+			t.Errorf("found a player name in the encrypted journal %s", raw)
+		}
+
+		journal, err = NewEncryptedJournal(path, key)
+		assertNoError(t, err)
+		if trash := journal.Trash(); len(trash) != 1 || trash[0].Name != "Cleo" {
+			t.Errorf("got trash %+v want Cleo", trash)
+		}
+		_, err = NewJournal(path)
+		if err == nil {
+			t.Error("expected an error opening the journal without the key")
+		}
+	})
+
+	t.Run("appends changes and compacts the file now and then", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "journal.json")
+		journal, err := NewJournal(path)
+		assertNoError(t, err)
+		journal.compactEvery = 3
+
+		countLines := func() int {
+			raw, err := os.ReadFile(path)
+			assertNoError(t, err)
+			return bytes.Count(raw, []byte("\n"))
+		}
+		for i := 0; i < 3; i++ {
+			journal.record(JournalEntry{Op: MutationWin, Before: []Player{}, After: []Player{{"Pepper", i + 1}}})
+		}
+		if lines := countLines(); lines != 3 {
+			t.Errorf("got %d lines want one per change", lines)
+		}
+		journal.record(JournalEntry{Op: MutationWin, Before: []Player{}, After: []Player{{"Pepper", 4}}})
+		if lines := countLines(); lines != 1 {
+			t.Errorf("got %d lines want the file compacted to one", lines)
+		}
+
+		journal, err = NewJournal(path)
+		assertNoError(t, err)
+		if entries := journal.Entries(0); len(entries) != 4 || entries[0].ID != 4 {
+			t.Errorf("got %+v want all four entries back", entries)
+		}
+	})
+
+	t.Run("drops a partly written last change", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "journal.json")
+		journal, err := NewJournal(path)
+		assertNoError(t, err)
+		journal.record(JournalEntry{Op: MutationWin, Before: []Player{}, After: []Player{{"Pepper", 1}}})
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		assertNoError(t, err)
+		file.WriteString(`{"entry": {"id": 2, "op": "wi`)
+		file.Close()
+
+		journal, err = NewJournal(path)
+		assertNoError(t, err)
+		if entries := journal.Entries(0); len(entries) != 1 {
+			t.Errorf("got %+v want only the complete entry", entries)
+		}
+	})
+
+	t.Run("keeps at most TrashSize deleted players", func(t *testing.T) {
+		journal, err := NewJournal("")
+		assertNoError(t, err)
+		journal.TrashSize = 2
+
+		for _, name := range []string{"Cleo", "Chris", "Pepper"} {
+			journal.record(JournalEntry{Op: MutationDelete, Before: []Player{{name, 1}}, After: []Player{}})
+		}
+		trash := journal.Trash()
+		if len(trash) != 2 || trash[0].Name != "Pepper" || trash[1].Name != "Chris" {
+			t.Errorf("got trash %+v want the two most recently deleted", trash)
+		}
+	})
+
+	t.Run("is not needed by the rest of the server", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		server.Journal = nil
+
+		server.ServeHTTP(httptest.NewRecorder(), newDeletePlayerRequest("Cleo"))
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}})
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/journal/revert", `{"id": 1}`)))
+		assertStatus(t, response.Code, http.StatusNotImplemented)
+		if !strings.Contains(response.Body.String(), "journal") {
+			t.Errorf("got %q want it to mention the journal", response.Body.String())
+		}
+	})
+}
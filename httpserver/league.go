@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 )
 
 type League []Player
@@ -17,6 +18,16 @@ func(l League) Find(name string) (*Player, int) {
 	return nil, -2
 }
 
+// FindFold is Find ignoring upper and lower case
+func (l League) FindFold(name string) (*Player, int) {
+	for idx, player := range l {
+		if strings.EqualFold(player.Name, name) {
+			return &l[idx], idx
+		}
+	}
+	return nil, -2
+}
+
 
 func NewLeague(rdr *os.File) ([]Player, error) {
 	var league []Player
@@ -0,0 +1,292 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of LiveMessage
+const (
+	// sent by clients
+	LiveAuth        = "auth"
+	LiveSubscribe   = "subscribe"
+	LiveUnsubscribe = "unsubscribe"
+	LiveWin         = "win"
+
+	// sent by the server
+	LiveSnapshot = "snapshot"
+	LiveDiff     = "diff"
+	LiveAck      = "ack"
+	LiveError    = "error"
+)
+
+const (
+	defaultLivePingInterval = 30 * time.Second
+	livePongWait            = 10 * time.Second
+)
+
+// LiveMessage is sent both ways over the /live WebSocket. Clients send auth
+// (with Token), subscribe and unsubscribe (with Players, or none for the whole
+// league) and win (with Player). The server replies with ack or error, sends a
+// snapshot on subscribe and then a diff of Events as the league changes.
+type LiveMessage struct {
+	Type string `json:"type"`
+	// ID is chosen by the client and echoed back in the reply
+	ID      string   `json:"id,omitempty"`
+	Token   string   `json:"token,omitempty"`
+	Players []string `json:"players,omitempty"`
+	Player  string   `json:"player,omitempty"`
+	Wins    int      `json:"wins,omitempty"`
+	League  []Player `json:"league,omitempty"`
+	Events  []Event  `json:"events,omitempty"`
+	Status  int      `json:"status,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// liveClient is the state of one /live connection
+type liveClient struct {
+	conn     *wsConn
+	identity string
+
+	mu sync.Mutex
+	// token is looked up for every win, so logging out or the session
+	// expiring stops the socket recording wins
+	token   string
+	league  bool
+	players map[string]bool
+}
+
+// wants reports whether the client is subscribed to event
+func (c *liveClient) wants(event Event) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.league {
+		return true
+	}
+	return event.Player != "" && c.players[event.Player]
+}
+
+// liveOriginAllowed reports whether a /live handshake comes from this
+// server's own pages or an origin the CORS config names. Clients that aren't
+// browsers don't send an Origin.
+func (p *PlayerServer) liveOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	if p.CORS == nil {
+		return false
+	}
+	// "*" is only good for requests without credentials, which a WebSocket can't promise
+	allowed, ok := p.CORS.allowOrigin(origin)
+	return ok && allowed != "*"
+}
+
+// liveHandler upgrades to a WebSocket carrying LiveMessages
+func (p *PlayerServer) liveHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+
+	// browsers let any page open a WebSocket, with the user's credentials
+	if !p.liveOriginAllowed(r) {
+		log.Println("rejected /live from", r.Header.Get("Origin"))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	sub, _, _, err := p.events.subscribe(0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		p.events.unsubscribe(sub)
+		return
+	}
+
+	client := &liveClient{conn: conn, identity: p.identity(r), players: map[string]bool{}}
+	client.token = bearerToken(r)
+
+	interval := p.livePingInterval
+	extend := func() { conn.conn.SetReadDeadline(time.Now().Add(interval + livePongWait)) }
+	conn.onPong = extend
+	extend()
+
+	done := make(chan struct{})
+	go p.livePump(client, sub, interval, done)
+	defer func() {
+		close(done)
+		p.events.unsubscribe(sub)
+		conn.close(closeNormal, "")
+	}()
+
+	for {
+		op, data, err := conn.readMessage()
+		if err != nil {
+			return
+		}
+		extend()
+
+		if op != opText {
+			conn.close(closeUnsupported, "only text messages are understood")
+			return
+		}
+		var message LiveMessage
+		err = json.Unmarshal(data, &message)
+		if err != nil {
+			client.conn.writeJSON(LiveMessage{Type: LiveError, Status: http.StatusBadRequest, Error: fmt.Sprintf("problem parsing message, %v", err)})
+			continue
+		}
+		client.conn.writeJSON(p.handleLiveMessage(client, message))
+	}
+}
+
+// livePump sends the client its events and keeps the connection alive with pings
+func (p *PlayerServer) livePump(client *liveClient, sub *subscriber, interval time.Duration, done chan struct{}) {
+	ping := time.NewTicker(interval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case event, open := <-sub.events:
+			if !open {
+				// either the server is shutting down or the client fell too far behind
+				client.conn.close(closeGoingAway, "reconnect to resume")
+				return
+			}
+			events := []Event{}
+			if client.wants(event) {
+				events = append(events, event)
+			}
+			// send whatever else is already waiting in the same message
+			for more := true; more; {
+				select {
+				case event, open := <-sub.events:
+					if !open {
+						more = false
+					} else if client.wants(event) {
+						events = append(events, event)
+					}
+				default:
+					more = false
+				}
+			}
+			if len(events) > 0 {
+				client.conn.writeJSON(LiveMessage{Type: LiveDiff, Events: events})
+			}
+		case <-ping.C:
+			client.conn.writeFrame(opPing, nil)
+		case <-done:
+			return
+		}
+	}
+}
+
+// handleLiveMessage carries out one message from a client and returns the reply
+func (p *PlayerServer) handleLiveMessage(client *liveClient, message LiveMessage) LiveMessage {
+	reply := LiveMessage{Type: LiveAck, ID: message.ID}
+	fail := func(status int, err string) LiveMessage {
+		return LiveMessage{Type: LiveError, ID: message.ID, Status: status, Error: err}
+	}
+
+	switch message.Type {
+	case LiveAuth:
+		if _, ok := p.sessions.lookup(message.Token); !ok {
+			return fail(http.StatusUnauthorized, "unknown token, log in with /login")
+		}
+		client.mu.Lock()
+		client.token = message.Token
+		client.mu.Unlock()
+
+	case LiveSubscribe:
+		client.mu.Lock()
+		if len(message.Players) == 0 {
+			client.league = true
+		}
+		for _, name := range message.Players {
+			client.players[p.canonicalName(name)] = true
+		}
+		client.mu.Unlock()
+
+		league := p.Store.GetLeague()
+		if len(message.Players) > 0 {
+			league = playersNamed(league, message.Players...)
+		}
+		return LiveMessage{Type: LiveSnapshot, ID: message.ID, League: league}
+
+	case LiveUnsubscribe:
+		client.mu.Lock()
+		if len(message.Players) == 0 {
+			client.league = false
+			client.players = map[string]bool{}
+		}
+		for _, name := range message.Players {
+			delete(client.players, p.canonicalName(name))
+		}
+		client.mu.Unlock()
+
+	case LiveWin:
+		return p.liveWin(client, message, fail)
+
+	default:
+		return fail(http.StatusBadRequest, fmt.Sprintf("unknown message type %q", message.Type))
+	}
+	return reply
+}
+
+// liveWin records a win for an authenticated client, with the same rate
+// limits and rules as POST /store/{name}
+func (p *PlayerServer) liveWin(client *liveClient, message LiveMessage, fail func(int, string) LiveMessage) LiveMessage {
+	client.mu.Lock()
+	token := client.token
+	client.mu.Unlock()
+	if token == "" {
+		return fail(http.StatusUnauthorized, "send an auth message before recording wins")
+	}
+	user, ok := p.sessions.lookup(token)
+	if !ok {
+		return fail(http.StatusUnauthorized, "session expired or logged out, log in with /login and send a new auth message")
+	}
+	if message.Player == "" {
+		return fail(http.StatusBadRequest, "say which player won")
+	}
+	if store, ok := p.Store.(Repairer); ok && store.ReadOnly() {
+		return fail(http.StatusServiceUnavailable, "database is read only until it is repaired")
+	}
+
+	player := p.canonicalName(message.Player)
+	equivalent := &http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/store/" + player}}
+	if idx, route, ok := matchRouteLimit(p.RateLimits, equivalent); ok && route.Limit.Rate > 0 {
+		allowed, _, _ := p.limiter.allow(fmt.Sprintf("%d|user:%s", idx, user), route.Limit)
+		if !allowed {
+			return fail(http.StatusTooManyRequests, "rate limit exceeded")
+		}
+	}
+
+	check, err := p.checkMutations(Mutation{Kind: MutationWin, Player: player})
+	if err != nil {
+		if ruleErr, ok := err.(*RuleError); ok {
+			return fail(ruleErr.Status, ruleErr.Error())
+		}
+		return fail(http.StatusBadRequest, err.Error())
+	}
+
+	p.trackChanges(&JournalEntry{Op: MutationWin, Actor: "user:" + user}, func() {
+		err = p.recordWin(player)
+	})
+	if err != nil {
+		check.release()
+		return fail(http.StatusBadGateway, err.Error())
+	}
+	return LiveMessage{Type: LiveAck, ID: message.ID, Player: player, Wins: p.Store.GetPlayerScore(player)}
+}
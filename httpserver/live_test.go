@@ -0,0 +1,231 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialLive opens a WebSocket to /live with an in-process client
+func dialLive(t testing.TB, serverURL string, header http.Header) *wsConn {
+	t.Helper()
+	request, _ := http.NewRequest(http.MethodGet, serverURL+"/live", nil)
+	conn, err := net.Dial("tcp", request.URL.Host)
+	assertNoError(t, err)
+
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	key := base64.StdEncoding.EncodeToString(raw)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	assertNoError(t, request.Write(conn))
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	assertNoError(t, err)
+	assertStatus(t, response.StatusCode, http.StatusSwitchingProtocols)
+	if got := response.Header.Get("Sec-WebSocket-Accept"); got != websocketAccept(key) {
+		t.Fatalf("got Sec-WebSocket-Accept %q want %q", got, websocketAccept(key))
+	}
+
+	client := &wsConn{conn: conn, rw: bufio.NewReadWriter(reader, bufio.NewWriter(conn)), client: true}
+	t.Cleanup(func() { client.close(closeNormal, "") })
+	return client
+}
+
+func sendLive(t testing.TB, client *wsConn, message LiveMessage) LiveMessage {
+	t.Helper()
+	assertNoError(t, client.writeJSON(message))
+	return readLive(t, client)
+}
+
+func readLive(t testing.TB, client *wsConn) LiveMessage {
+	t.Helper()
+	client.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := client.readMessage()
+	assertNoError(t, err)
+
+	var message LiveMessage
+	assertNoError(t, json.Unmarshal(data, &message))
+	return message
+}
+
+func loginToken(t testing.TB, server *PlayerServer) string {
+	t.Helper()
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, newLoginRequest("user_a", "passwordA"))
+	assertStatus(t, response.Code, http.StatusOK)
+	return strings.TrimPrefix(response.Body.String(), "Bearer ")
+}
+
+func TestLive(t *testing.T) {
+	server, _, clean := newFileSystemServer(t, importTestLeague)
+	defer clean()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	t.Run("needs a WebSocket handshake", func(t *testing.T) {
+		response, err := http.Get(ts.URL + "/live")
+		assertNoError(t, err)
+		assertStatus(t, response.StatusCode, http.StatusUpgradeRequired)
+	})
+
+	t.Run("refuses handshakes from other sites", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/live", nil)
+		request.Header.Set("Origin", "https://evil.example.com")
+		request.Header.Set("Upgrade", "websocket")
+		request.Header.Set("Connection", "Upgrade")
+		request.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(make([]byte, 16)))
+		request.Header.Set("Sec-WebSocket-Version", "13")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("accepts handshakes from its own pages and CORS origins", func(t *testing.T) {
+		dialLive(t, ts.URL, http.Header{"Origin": {ts.URL}})
+
+		server.CORS = &CORSConfig{AllowedOrigins: []string{"https://board.example.com"}}
+		defer func() { server.CORS = nil }()
+		dialLive(t, ts.URL, http.Header{"Origin": {"https://board.example.com"}})
+	})
+
+	t.Run("sends a snapshot then diffs of the league", func(t *testing.T) {
+		client := dialLive(t, ts.URL, nil)
+
+		reply := sendLive(t, client, LiveMessage{Type: LiveSubscribe, ID: "1"})
+		if reply.Type != LiveSnapshot || reply.ID != "1" || len(reply.League) != 2 {
+			t.Fatalf("got %+v want a snapshot of the league", reply)
+		}
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+
+		diff := readLive(t, client)
+		if diff.Type != LiveDiff || len(diff.Events) != 1 || diff.Events[0].Type != EventWinRecorded || diff.Events[0].Player != "Cleo" {
+			t.Errorf("got %+v want a diff with Cleo's win", diff)
+		}
+	})
+
+	t.Run("only sends events for subscribed players", func(t *testing.T) {
+		client := dialLive(t, ts.URL, nil)
+
+		reply := sendLive(t, client, LiveMessage{Type: LiveSubscribe, Players: []string{"Chris"}})
+		if len(reply.League) != 1 || reply.League[0].Name != "Chris" {
+			t.Fatalf("got %+v want a snapshot of Chris", reply)
+		}
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Chris"))
+
+		diff := readLive(t, client)
+		for _, event := range diff.Events {
+			if event.Player != "Chris" {
+				t.Errorf("got an event for %s, only subscribed to Chris", event.Player)
+			}
+		}
+		if len(diff.Events) == 0 {
+			t.Error("expected Chris's win")
+		}
+	})
+
+	t.Run("records wins once authenticated", func(t *testing.T) {
+		client := dialLive(t, ts.URL, nil)
+
+		reply := sendLive(t, client, LiveMessage{Type: LiveWin, ID: "w1", Player: "Cleo"})
+		if reply.Type != LiveError || reply.Status != http.StatusUnauthorized {
+			t.Errorf("got %+v want a 401 error", reply)
+		}
+
+		reply = sendLive(t, client, LiveMessage{Type: LiveAuth, Token: "made-up"})
+		if reply.Status != http.StatusUnauthorized {
+			t.Errorf("got %+v want a 401 error for a bad token", reply)
+		}
+
+		reply = sendLive(t, client, LiveMessage{Type: LiveAuth, Token: loginToken(t, server)})
+		if reply.Type != LiveAck {
+			t.Fatalf("got %+v want an ack", reply)
+		}
+
+		before := server.Store.GetPlayerScore("Cleo")
+		reply = sendLive(t, client, LiveMessage{Type: LiveWin, ID: "w2", Player: "Cleo"})
+		if reply.Type != LiveAck || reply.ID != "w2" || reply.Wins != before+1 {
+			t.Errorf("got %+v want an ack with %d wins", reply, before+1)
+		}
+	})
+
+	t.Run("accepts a token in the handshake", func(t *testing.T) {
+		client := dialLive(t, ts.URL, http.Header{"Authorization": {"Bearer " + loginToken(t, server)}})
+
+		reply := sendLive(t, client, LiveMessage{Type: LiveWin, Player: "Chris"})
+		if reply.Type != LiveAck {
+			t.Errorf("got %+v want an ack", reply)
+		}
+	})
+
+	t.Run("stops recording wins once the session ends", func(t *testing.T) {
+		token := loginToken(t, server)
+		client := dialLive(t, ts.URL, http.Header{"Authorization": {"Bearer " + token}})
+		server.sessions.revoke(token)
+
+		reply := sendLive(t, client, LiveMessage{Type: LiveWin, Player: "Chris"})
+		if reply.Type != LiveError || reply.Status != http.StatusUnauthorized {
+			t.Errorf("got %+v want a 401 error after logging out", reply)
+		}
+	})
+
+	t.Run("applies the rules to wins", func(t *testing.T) {
+		server.Rules = []Rule{ExistingPlayersOnly{}}
+		defer func() { server.Rules = nil }()
+		client := dialLive(t, ts.URL, nil)
+		sendLive(t, client, LiveMessage{Type: LiveAuth, Token: loginToken(t, server)})
+
+		reply := sendLive(t, client, LiveMessage{Type: LiveWin, Player: "Nobody"})
+		if reply.Type != LiveError || reply.Status != http.StatusNotFound {
+			t.Errorf("got %+v want a 404 error from the rule", reply)
+		}
+	})
+
+	t.Run("pings the client", func(t *testing.T) {
+		server.livePingInterval = 10 * time.Millisecond
+		defer func() { server.livePingInterval = defaultLivePingInterval }()
+		client := dialLive(t, ts.URL, nil)
+
+		client.conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, op, _, err := client.readFrame()
+		assertNoError(t, err)
+		if op != opPing {
+			t.Errorf("got opcode %d want a ping", op)
+		}
+	})
+
+	t.Run("closes connections on shutdown", func(t *testing.T) {
+		client := dialLive(t, ts.URL, nil)
+		sendLive(t, client, LiveMessage{Type: LiveSubscribe})
+
+		server.DrainDelay = 0
+		assertNoError(t, server.Shutdown(context.Background()))
+
+		client.conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, op, payload, err := client.readFrame()
+		assertNoError(t, err)
+		if op != opClose || binary.BigEndian.Uint16(payload) != closeGoingAway {
+			t.Errorf("got opcode %d %v want a going away close", op, payload)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errLockHeld is returned by flockExclusive when another open file holds the lock
+var errLockHeld = errors.New("lock is held")
+
+// DatabaseLockedError is returned when another process already has the database open
+type DatabaseLockedError struct {
+	Path string
+	// PID is the process holding the lock, 0 if it couldn't be found
+	PID int
+	// Running is false when PID has exited but the lock is still held, usually
+	// because a child process inherited the file
+	Running bool
+}
+
+func (e *DatabaseLockedError) Error() string {
+	switch {
+	case e.PID == 0:
+		return fmt.Sprintf("database %s is in use by another process", e.Path)
+	case !e.Running:
+		return fmt.Sprintf("database %s is locked by PID %d, which is no longer running; a process it started may still have the file open", e.Path, e.PID)
+	default:
+		return fmt.Sprintf("database %s is in use by PID %d", e.Path, e.PID)
+	}
+}
+
+// databaseLock is an exclusive advisory lock on a database file. The PID of
+// the holder is kept in a file next to it so others can say who has it.
+type databaseLock struct {
+	file    *os.File
+	pidFile string
+}
+
+// lockDatabase takes the lock on file without waiting
+func lockDatabase(file *os.File) (*databaseLock, error) {
+	pidFile := file.Name() + ".lock"
+
+	err := flockExclusive(file)
+	if errors.Is(err, errLockHeld) {
+		pid := readLockPID(pidFile)
+		return nil, &DatabaseLockedError{Path: file.Name(), PID: pid, Running: pid != 0 && processRunning(pid)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem locking %s, %v", file.Name(), err)
+	}
+
+	// the lock goes when its holder exits, but the PID file stays behind
+	if pid := readLockPID(pidFile); pid != 0 && pid != os.Getpid() {
+		log.Printf("replacing stale lock on %s left by PID %d", file.Name(), pid)
+	}
+
+	err = os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	if err != nil {
+		funlock(file)
+		return nil, fmt.Errorf("problem writing lock file %s, %v", pidFile, err)
+	}
+	return &databaseLock{file: file, pidFile: pidFile}, nil
+}
+
+func readLockPID(pidFile string) int {
+	raw, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// release gives up the lock
+func (l *databaseLock) release() error {
+	os.Remove(l.pidFile)
+	return funlock(l.file)
+}
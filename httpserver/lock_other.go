@@ -0,0 +1,19 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package httpserver
+
+import "os"
+
+// flockExclusive is not supported on this platform, so the database is not locked
+func flockExclusive(file *os.File) error {
+	return nil
+}
+
+func funlock(file *os.File) error {
+	return nil
+}
+
+func processRunning(pid int) bool {
+	return true
+}
@@ -0,0 +1,102 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package httpserver
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+// openAgain opens the database a second time, as another process would
+func openAgain(t testing.TB, file *os.File) *os.File {
+	t.Helper()
+	again, err := os.OpenFile(file.Name(), os.O_RDWR, 0666)
+	assertNoError(t, err)
+	return again
+}
+
+// deadPID returns the PID of a process that has already exited
+func deadPID(t testing.TB) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	assertNoError(t, cmd.Run())
+	return cmd.Process.Pid
+}
+
+func TestDatabaseLock(t *testing.T) {
+	t.Run("stops a second store opening the database", func(t *testing.T) {
+		database, clean := createTempFile(t, "")
+		defer clean()
+
+		store, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		defer store.Close()
+
+		again := openAgain(t, database)
+		defer again.Close()
+		_, err = NewFileSystemPlayerStore(again)
+
+		var locked *DatabaseLockedError
+		if !errors.As(err, &locked) {
+			t.Fatalf("got %v want a %T", err, locked)
+		}
+		if locked.PID != os.Getpid() || !locked.Running {
+			t.Errorf("got %+v want it held by running PID %d", locked, os.Getpid())
+		}
+	})
+
+	t.Run("can be opened again once closed", func(t *testing.T) {
+		database, clean := createTempFile(t, "")
+		defer clean()
+
+		store, err := NewFileSystemPlayerStore(openAgain(t, database))
+		assertNoError(t, err)
+		assertNoError(t, store.Close())
+
+		if _, err := os.Stat(database.Name() + ".lock"); !os.IsNotExist(err) {
+			t.Errorf("expected the lock file to be removed, got %v", err)
+		}
+
+		again, err := NewFileSystemPlayerStore(openAgain(t, database))
+		assertNoError(t, err)
+		again.Close()
+	})
+
+	t.Run("replaces a stale lock file", func(t *testing.T) {
+		database, clean := createTempFile(t, "")
+		defer clean()
+		os.WriteFile(database.Name()+".lock", []byte(strconv.Itoa(deadPID(t))), 0644)
+
+		store, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		defer store.Close()
+
+		if pid := readLockPID(database.Name() + ".lock"); pid != os.Getpid() {
+			t.Errorf("lock file names PID %d want %d", pid, os.Getpid())
+		}
+	})
+
+	t.Run("says when the holder has exited but the lock is still held", func(t *testing.T) {
+		database, clean := createTempFile(t, "")
+		defer clean()
+
+		store, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		defer store.Close()
+		pid := deadPID(t)
+		os.WriteFile(database.Name()+".lock", []byte(strconv.Itoa(pid)), 0644)
+
+		again := openAgain(t, database)
+		defer again.Close()
+		_, err = NewFileSystemPlayerStore(again)
+
+		var locked *DatabaseLockedError
+		if !errors.As(err, &locked) || locked.PID != pid || locked.Running {
+			t.Errorf("got %v want it held by exited PID %d", err, pid)
+		}
+	})
+}
@@ -0,0 +1,264 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// PatchOperation is one step of a JSON Patch (RFC 6902) document. As well as
+// the standard operations, "increment" adds Value to a number.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// playerETag identifies one version of a player for If-Match checks
+func playerETag(player Player) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", player.Name, player.Wins)))
+	return fmt.Sprintf(`"%x"`, sum[:8])
+}
+
+// playerDocument is a player as a JSON object, keyed by field name
+type playerDocument map[string]interface{}
+
+func newPlayerDocument(player Player) playerDocument {
+	return playerDocument{"Name": player.Name, "Wins": float64(player.Wins)}
+}
+
+// toPlayer turns a patched document back into a Player, rejecting anything
+// that isn't a valid player
+func (d playerDocument) toPlayer() (Player, error) {
+	for key := range d {
+		if key != "Name" && key != "Wins" {
+			return Player{}, newOperationError(http.StatusUnprocessableEntity, "unknown field %q", key)
+		}
+	}
+
+	name, ok := d["Name"].(string)
+	if !ok || strings.TrimSpace(name) == "" {
+		return Player{}, newOperationError(http.StatusUnprocessableEntity, "Name must be a non-empty string")
+	}
+	wins, ok := d["Wins"].(float64)
+	if !ok || wins != float64(int(wins)) || wins < 0 {
+		return Player{}, newOperationError(http.StatusUnprocessableEntity, "Wins must be a whole number of at least 0")
+	}
+	return Player{name, int(wins)}, nil
+}
+
+// field maps a JSON pointer such as "/Wins" onto a player field. Like the
+// field names, pointers are case-sensitive.
+func (d playerDocument) field(pointer string) (string, error) {
+	for _, key := range []string{"Name", "Wins"} {
+		if pointer == "/"+key {
+			return key, nil
+		}
+	}
+	return "", newOperationError(http.StatusUnprocessableEntity, "path %q is not a player field", pointer)
+}
+
+// applyMergePatch applies an RFC 7396 JSON Merge Patch
+func applyMergePatch(player Player, body []byte) (Player, error) {
+	var patch map[string]json.RawMessage
+	err := json.Unmarshal(body, &patch)
+	if err != nil {
+		return Player{}, newOperationError(http.StatusBadRequest, "merge patch must be a JSON object, %v", err)
+	}
+
+	doc := newPlayerDocument(player)
+	for key, raw := range patch {
+		field, err := doc.field("/" + key)
+		if err != nil {
+			return Player{}, err
+		}
+
+		var value interface{}
+		json.Unmarshal(raw, &value)
+		if value == nil {
+			delete(doc, field)
+		} else {
+			doc[field] = value
+		}
+	}
+	return doc.toPlayer()
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch
+func applyJSONPatch(player Player, body []byte) (Player, error) {
+	var operations []PatchOperation
+	err := json.Unmarshal(body, &operations)
+	if err != nil {
+		return Player{}, newOperationError(http.StatusBadRequest, "JSON patch must be an array of operations, %v", err)
+	}
+
+	doc := newPlayerDocument(player)
+	for i, operation := range operations {
+		err := doc.apply(operation)
+		if err != nil {
+			return Player{}, fmt.Errorf("operation %d, %w", i, err)
+		}
+	}
+	return doc.toPlayer()
+}
+
+func (d playerDocument) apply(operation PatchOperation) error {
+	field, err := d.field(operation.Path)
+	if err != nil {
+		return err
+	}
+
+	var value interface{}
+	if len(operation.Value) > 0 {
+		err = json.Unmarshal(operation.Value, &value)
+		if err != nil {
+			return newOperationError(http.StatusBadRequest, "bad value, %v", err)
+		}
+	}
+
+	switch operation.Op {
+	case "add", "replace":
+		if operation.Op == "replace" {
+			if _, ok := d[field]; !ok {
+				return newOperationError(http.StatusUnprocessableEntity, "can't replace missing %s", field)
+			}
+		}
+		d[field] = value
+	case "remove":
+		delete(d, field)
+	case "test":
+		current, ok := d[field]
+		if !ok {
+			return newOperationError(http.StatusConflict, "test failed, %s is missing", field)
+		}
+		// decoded JSON values are equal when their types and values are
+		if !reflect.DeepEqual(current, value) {
+			return newOperationError(http.StatusConflict, "test failed, %s is %#v not %#v", field, current, value)
+		}
+	case "copy", "move":
+		from, err := d.field(operation.From)
+		if err != nil {
+			return err
+		}
+		if _, ok := d[from]; !ok {
+			return newOperationError(http.StatusUnprocessableEntity, "can't %s missing %s", operation.Op, from)
+		}
+		d[field] = d[from]
+		if operation.Op == "move" && from != field {
+			delete(d, from)
+		}
+	case "increment":
+		current, ok := d[field].(float64)
+		by, byOK := value.(float64)
+		if !ok || !byOK {
+			return newOperationError(http.StatusUnprocessableEntity, "can only increment a number by a number")
+		}
+		d[field] = current + by
+	default:
+		return newOperationError(http.StatusBadRequest, "unknown patch operation %q", operation.Op)
+	}
+	return nil
+}
+
+// processPatch updates part of a player from a JSON Merge Patch or JSON Patch
+func (p *PlayerServer) processPatch(w http.ResponseWriter, r *http.Request, name string) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var applyPatch func(Player, []byte) (Player, error)
+	switch mediaType {
+	case mergePatchContentType, jsonContentType:
+		applyPatch = applyMergePatch
+	case jsonPatchContentType:
+		applyPatch = applyJSONPatch
+	default:
+		w.Header().Set("Accept-Patch", mergePatchContentType+", "+jsonPatchContentType)
+		http.Error(w, fmt.Sprintf("unsupported patch type %q", mediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	updater, ok := p.Store.(LeagueUpdater)
+	if !ok {
+		http.Error(w, "store does not support PATCH", http.StatusNotImplemented)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var patched Player
+	check := p.newRuleCheck()
+	journal := &JournalEntry{Op: "patch", Actor: p.identity(r)}
+	err = p.updateLeague(journal, updater, func(league League) (League, error) {
+		current, idx := league.Find(name)
+		if current == nil {
+			return nil, newOperationError(http.StatusNotFound, "player %s does not exist", name)
+		}
+
+		if match := r.Header.Get("If-Match"); match != "" && match != "*" && match != playerETag(*current) {
+			return nil, newOperationError(http.StatusPreconditionFailed, "player %s has changed", name)
+		}
+
+		patched, err = applyPatch(*current, body)
+		if err != nil {
+			return nil, err
+		}
+
+		var mutations []Mutation
+		if patched.Wins != current.Wins {
+			mutations = append(mutations, Mutation{Kind: MutationSet, Player: current.Name, Wins: patched.Wins})
+		}
+		if patched.Name != current.Name {
+			existing, _ := league.Find(patched.Name)
+			if p.NormalizeNames {
+				existing, _ = league.FindFold(patched.Name)
+			}
+			if existing != nil && existing != current {
+				return nil, newOperationError(http.StatusConflict, "player %s already exists", patched.Name)
+			}
+			mutations = append(mutations, Mutation{Kind: MutationRename, Player: current.Name, NewName: patched.Name})
+			journal.rename(current.Name, patched.Name)
+		}
+		for _, m := range mutations {
+			err := check.check(m, league)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		league[idx] = patched
+		return league, nil
+	})
+
+	if err != nil {
+		check.release()
+		var ruleErr *RuleError
+		if errors.As(err, &ruleErr) {
+			writeRuleError(w, ruleErr)
+			return
+		}
+		var opErr *operationError
+		if errors.As(err, &opErr) {
+			http.Error(w, err.Error(), opErr.status)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", jsonContentType)
+	w.Header().Set("ETag", playerETag(patched))
+	json.NewEncoder(w).Encode(patched)
+}
@@ -0,0 +1,124 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newPatchRequest(name, contentType, body string) *http.Request {
+	req, _ := http.NewRequest(http.MethodPatch, "/store/"+name, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+func getPlayerFromResponse(t testing.TB, response *httptest.ResponseRecorder) (player Player) {
+	t.Helper()
+	err := json.NewDecoder(response.Body).Decode(&player)
+	if err != nil {
+		t.Fatalf("Unable to parse player from response %q, %v", response.Body, err)
+	}
+	return
+}
+
+func TestPatch(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		want        Player
+	}{
+		{"merge patch sets wins", mergePatchContentType, `{"Wins": 50}`, http.StatusOK,
+			Player{"Cleo", 50}},
+		{"merge patch renames", mergePatchContentType, `{"Name": "Cleopatra"}`, http.StatusOK,
+			Player{"Cleopatra", 10}},
+		{"json patch replaces wins", jsonPatchContentType, `[{"op": "replace", "path": "/Wins", "value": 12}]`, http.StatusOK,
+			Player{"Cleo", 12}},
+		{"json patch adds to wins", jsonPatchContentType, `[{"op": "increment", "path": "/Wins", "value": 5}]`, http.StatusOK,
+			Player{"Cleo", 15}},
+		{"json patch test guards a change", jsonPatchContentType, `[{"op": "test", "path": "/Wins", "value": 10}, {"op": "replace", "path": "/Name", "value": "C"}]`, http.StatusOK,
+			Player{"C", 10}},
+		{"failed test is a conflict", jsonPatchContentType, `[{"op": "test", "path": "/Wins", "value": 9}, {"op": "replace", "path": "/Wins", "value": 0}]`, http.StatusConflict, Player{}},
+		{"test compares types as well as values", jsonPatchContentType, `[{"op": "test", "path": "/Wins", "value": "10"}, {"op": "replace", "path": "/Wins", "value": 0}]`, http.StatusConflict, Player{}},
+		{"test fails on a missing field", jsonPatchContentType, `[{"op": "remove", "path": "/Wins"}, {"op": "test", "path": "/Wins", "value": null}, {"op": "add", "path": "/Wins", "value": 0}]`, http.StatusConflict, Player{}},
+		{"paths are case-sensitive", jsonPatchContentType, `[{"op": "replace", "path": "/wins", "value": 0}]`, http.StatusUnprocessableEntity, Player{}},
+		{"renaming onto another player is a conflict", mergePatchContentType, `{"Name": "Chris"}`, http.StatusConflict, Player{}},
+		{"negative wins are rejected", mergePatchContentType, `{"Wins": -3}`, http.StatusUnprocessableEntity, Player{}},
+		{"removing the name is rejected", jsonPatchContentType, `[{"op": "remove", "path": "/Name"}]`, http.StatusUnprocessableEntity, Player{}},
+		{"unknown fields are rejected", mergePatchContentType, `{"Team": "red"}`, http.StatusUnprocessableEntity, Player{}},
+		{"other content types are unsupported", "text/plain", `Wins=3`, http.StatusUnsupportedMediaType, Player{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server, store, clean := newFileSystemServer(t, importTestLeague)
+			defer clean()
+
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, newPatchRequest("Cleo", c.contentType, c.body))
+
+			assertStatus(t, response.Code, c.status)
+			if c.status != http.StatusOK {
+				assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+				return
+			}
+
+			got := getPlayerFromResponse(t, response)
+			if got != c.want {
+				t.Errorf("got patched player %v want %v", got, c.want)
+			}
+			assertHeader(t, response, "ETag", playerETag(got))
+			if found, _ := store.GetLeague().Find(got.Name); found == nil || *found != got {
+				t.Errorf("store has %v, want %v", store.GetLeague(), got)
+			}
+		})
+	}
+
+	t.Run("missing players are not found", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPatchRequest("Nobody", mergePatchContentType, `{"Wins": 1}`))
+
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("If-Match detects a stale ETag", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newGetScoreRequest("Cleo"))
+		etag := response.Header().Get("ETag")
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+
+		request := newPatchRequest("Cleo", mergePatchContentType, `{"Wins": 1}`)
+		request.Header.Set("If-Match", etag)
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusPreconditionFailed)
+
+		request = newPatchRequest("Cleo", mergePatchContentType, `{"Wins": 1}`)
+		request.Header.Set("If-Match", playerETag(Player{"Cleo", 11}))
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("renames have to pass the rules", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		server.Rules = []Rule{NamePolicy{DefaultNamePattern}}
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPatchRequest("Cleo", mergePatchContentType, `{"Name": "<Cleo>"}`))
+
+		assertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		assertHeader(t, response, "X-Blocked-By-Rule", "name-policy")
+	})
+}
@@ -0,0 +1,137 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Profile is optional extra information about a player
+type Profile struct {
+	DisplayName string `json:",omitempty"`
+	AvatarURL   string `json:",omitempty"`
+	Team        string `json:",omitempty"`
+	// JoinDate is a date in the form 2006-01-02
+	JoinDate   string            `json:",omitempty"`
+	Tags       []string          `json:",omitempty"`
+	Attributes map[string]string `json:",omitempty"`
+}
+
+// ProfileStore is implemented by stores that keep player profiles
+type ProfileStore interface {
+	GetProfile(name string) (Profile, bool)
+	SetProfile(name string, profile Profile) error
+	RenameProfile(from, to string) error
+}
+
+const (
+	maxProfileText       = 200
+	maxProfileTags       = 32
+	maxProfileAttributes = 64
+)
+
+// Validate checks a profile is safe to store
+func (p Profile) Validate() error {
+	for field, value := range map[string]string{"DisplayName": p.DisplayName, "Team": p.Team, "AvatarURL": p.AvatarURL} {
+		if len(value) > maxProfileText {
+			return fmt.Errorf("%s is longer than %d characters", field, maxProfileText)
+		}
+	}
+
+	if p.AvatarURL != "" {
+		u, err := url.Parse(p.AvatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("AvatarURL must be an http or https URL")
+		}
+	}
+
+	if p.JoinDate != "" {
+		_, err := time.Parse("2006-01-02", p.JoinDate)
+		if err != nil {
+			return fmt.Errorf("JoinDate must look like 2006-01-02")
+		}
+	}
+
+	if len(p.Tags) > maxProfileTags {
+		return fmt.Errorf("no more than %d tags allowed", maxProfileTags)
+	}
+	for _, tag := range p.Tags {
+		if strings.TrimSpace(tag) == "" || len(tag) > maxProfileText {
+			return fmt.Errorf("tags must be between 1 and %d characters", maxProfileText)
+		}
+	}
+
+	if len(p.Attributes) > maxProfileAttributes {
+		return fmt.Errorf("no more than %d attributes allowed", maxProfileAttributes)
+	}
+	for key, value := range p.Attributes {
+		if strings.TrimSpace(key) == "" || len(key) > maxProfileText || len(value) > maxProfileText {
+			return fmt.Errorf("attribute names and values must be at most %d characters", maxProfileText)
+		}
+	}
+	return nil
+}
+
+// profileHandler serves GET and PUT /players/{name}/profile, and passes
+// /players/{name}/stats on to statsHandler
+func (p *PlayerServer) profileHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	name := strings.TrimPrefix(r.URL.Path, "/players/")
+	if strings.HasSuffix(name, "/stats") {
+		p.statsHandler(w, r, p.canonicalName(strings.TrimSuffix(name, "/stats")))
+		return
+	}
+	if !strings.HasSuffix(name, "/profile") {
+		http.NotFound(w, r)
+		return
+	}
+	name = p.canonicalName(strings.TrimSuffix(name, "/profile"))
+
+	store, ok := p.Store.(ProfileStore)
+	if !ok {
+		http.Error(w, "store does not keep profiles", http.StatusNotImplemented)
+		return
+	}
+	if player, _ := p.Store.GetLeague().Find(name); player == nil {
+		http.Error(w, fmt.Sprintf("player %s does not exist", name), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		profile, _ := store.GetProfile(name)
+		w.Header().Set("content-type", jsonContentType)
+		json.NewEncoder(w).Encode(profile)
+
+	case http.MethodPut:
+		var profile Profile
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&profile)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("problem parsing profile, %v", err), http.StatusBadRequest)
+			return
+		}
+		err = profile.Validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		err = store.SetProfile(name, profile)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", jsonContentType)
+		json.NewEncoder(w).Encode(profile)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
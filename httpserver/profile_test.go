@@ -0,0 +1,201 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newProfileRequest(method, name, body string) *http.Request {
+	req, _ := http.NewRequest(method, "/players/"+name+"/profile", strings.NewReader(body))
+	return req
+}
+
+func getProfileFromResponse(t testing.TB, body io.Reader) (profile Profile) {
+	t.Helper()
+	err := json.NewDecoder(body).Decode(&profile)
+	if err != nil {
+		t.Fatalf("Unable to parse profile, %v", err)
+	}
+	return
+}
+
+const testProfile = `{
+	"DisplayName": "Cleo the Great",
+	"AvatarURL": "https://example.com/cleo.png",
+	"Team": "red",
+	"JoinDate": "2024-03-01",
+	"Tags": ["founder"],
+	"Attributes": {"hand": "left"}
+}`
+
+func TestProfiles(t *testing.T) {
+	wantProfile := Profile{
+		DisplayName: "Cleo the Great",
+		AvatarURL:   "https://example.com/cleo.png",
+		Team:        "red",
+		JoinDate:    "2024-03-01",
+		Tags:        []string{"founder"},
+		Attributes:  map[string]string{"hand": "left"},
+	}
+
+	t.Run("stores and returns a profile", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newProfileRequest(http.MethodPut, "Cleo", testProfile))
+		assertStatus(t, response.Code, http.StatusOK)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newProfileRequest(http.MethodGet, "Cleo", ""))
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := getProfileFromResponse(t, response.Body)
+		if !reflect.DeepEqual(got, wantProfile) {
+			t.Errorf("got profile %+v want %+v", got, wantProfile)
+		}
+	})
+
+	t.Run("players start with an empty profile", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newProfileRequest(http.MethodGet, "Chris", ""))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		assertResponseBody(t, response.Body.String(), "{}\n")
+	})
+
+	t.Run("rejects bad profiles", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		for _, body := range []string{
+			`{"AvatarURL": "javascript:alert(1)"}`,
+			`{"JoinDate": "yesterday"}`,
+			`{"Tags": [""]}`,
+		} {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, newProfileRequest(http.MethodPut, "Cleo", body))
+			assertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		}
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newProfileRequest(http.MethodPut, "Cleo", `{"Shoe": 9}`))
+		assertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("unknown players have no profile", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newProfileRequest(http.MethodPut, "Nobody", testProfile))
+
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("follows the player when they are renamed", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newProfileRequest(http.MethodPut, "Cleo", testProfile))
+		server.ServeHTTP(httptest.NewRecorder(), asAdmin(t, server, newAdminPostRequest("/admin/rename", `{"from": "Cleo", "to": "Cleopatra"}`)))
+
+		got, ok := store.GetProfile("Cleopatra")
+		if !ok || !reflect.DeepEqual(got, wantProfile) {
+			t.Errorf("got profile %+v for the new name", got)
+		}
+		if _, ok := store.GetProfile("Cleo"); ok {
+			t.Errorf("old name still has a profile")
+		}
+	})
+
+	t.Run("stays with the renamed player when a new player takes the old name", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newProfileRequest(http.MethodPut, "Cleo", testProfile))
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newBatchRequest(t,
+			Operation{Op: MutationRename, Name: "Cleo", To: "Cleopatra"},
+			Operation{Op: MutationWin, Name: "Cleo"},
+		))
+		assertStatus(t, response.Code, http.StatusOK)
+
+		if got, _ := store.GetProfile("Cleopatra"); !reflect.DeepEqual(got, wantProfile) {
+			t.Errorf("got profile %+v for the new name", got)
+		}
+		if got, ok := store.GetProfile("Cleo"); ok {
+			t.Errorf("the new Cleo got profile %+v", got)
+		}
+	})
+
+	t.Run("is dropped when the player is deleted", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newProfileRequest(http.MethodPut, "Cleo", testProfile))
+		server.ServeHTTP(httptest.NewRecorder(), newDeletePlayerRequest("Cleo"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+
+		if got, ok := store.GetProfile("Cleo"); ok {
+			t.Errorf("a new Cleo inherited profile %+v", got)
+		}
+	})
+
+	t.Run("is dropped when a change removes the player", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newProfileRequest(http.MethodPut, "Cleo", testProfile))
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newBatchRequest(t, Operation{Op: MutationDelete, Name: "Cleo"}))
+		assertStatus(t, response.Code, http.StatusOK)
+
+		if got, ok := store.GetProfile("Cleo"); ok {
+			t.Errorf("deleted player still has profile %+v", got)
+		}
+	})
+
+	t.Run("is kept in the database file", func(t *testing.T) {
+		database, clean := createTempFile(t, importTestLeague)
+		defer clean()
+		store, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+
+		assertNoError(t, store.SetProfile("Cleo", wantProfile))
+
+		reloaded, err := NewFileSystemPlayerStore(database)
+		assertNoError(t, err)
+		got, _ := reloaded.GetProfile("Cleo")
+		if !reflect.DeepEqual(got, wantProfile) {
+			t.Errorf("got profile %+v after reload", got)
+		}
+		assertLeague(t, reloaded.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+	})
+}
+
+func TestLegacyDatabaseIsUpgraded(t *testing.T) {
+	file, clean := createTempFile(t, importTestLeague)
+	defer clean()
+
+	_, err := NewFileSystemPlayerStore(file)
+	assertNoError(t, err)
+
+	file.Seek(0, 0)
+	var got database
+	err = json.NewDecoder(file).Decode(&got)
+	assertNoError(t, err)
+
+	if got.Version != currentSchemaVersion {
+		t.Errorf("got version %d want %d", got.Version, currentSchemaVersion)
+	}
+	assertLeague(t, got.League, []Player{{"Cleo", 10}, {"Chris", 33}})
+}
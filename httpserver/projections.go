@@ -0,0 +1,168 @@
+package httpserver
+
+import (
+	"sort"
+	"time"
+)
+
+// LeagueProjection is the league table
+type LeagueProjection struct {
+	players League
+}
+
+func (p *LeagueProjection) Reset() {
+	p.players = League{}
+}
+
+func (p *LeagueProjection) Apply(event DomainEvent) {
+	player, idx := p.players.Find(event.Player)
+	switch event.Type {
+	case PlayerCreated:
+		if player == nil {
+			p.players = append(p.players, Player{event.Player, event.Wins})
+		}
+	case WinRecorded:
+		if player != nil {
+			player.Wins++
+		}
+	case PlayerOverwritten:
+		if player != nil {
+			player.Wins = event.Wins
+		}
+	case PlayerDeleted:
+		if player != nil {
+			p.players = append(p.players[:idx], p.players[idx+1:]...)
+		}
+	case PlayerRenamed:
+		renamed, _ := p.players.Find(event.From)
+		if renamed != nil && player == nil {
+			renamed.Name = event.Player
+		}
+	}
+}
+
+// League returns the players with the most wins first
+func (p *LeagueProjection) League() League {
+	league := append(League{}, p.players...)
+	sort.SliceStable(league, func(i, j int) bool { return league[i].Wins > league[j].Wins })
+	return league
+}
+
+// Find returns a player by name
+func (p *LeagueProjection) Find(name string) (Player, bool) {
+	player, _ := p.players.Find(name)
+	if player == nil {
+		return Player{}, false
+	}
+	return *player, true
+}
+
+// PlayerStats is what is known about a player over their whole history,
+// including any time before they were deleted
+type PlayerStats struct {
+	Name string `json:"name"`
+	Wins int    `json:"wins"`
+	// WinsRecorded counts wins recorded one at a time, not wins set by overwriting
+	WinsRecorded int        `json:"wins_recorded"`
+	BestWins     int        `json:"best_wins"`
+	Created      time.Time  `json:"created"`
+	LastWin      *time.Time `json:"last_win,omitempty"`
+	Overwrites   int        `json:"overwrites"`
+	Deletions    int        `json:"deletions"`
+	Deleted      bool       `json:"deleted"`
+	// PreviousNames are the names the player had before, oldest first
+	PreviousNames []string `json:"previous_names,omitempty"`
+}
+
+// StatsProjection keeps PlayerStats for everyone who has ever played
+type StatsProjection struct {
+	stats map[string]*PlayerStats
+}
+
+func (p *StatsProjection) Reset() {
+	p.stats = map[string]*PlayerStats{}
+}
+
+func (p *StatsProjection) Apply(event DomainEvent) {
+	if event.Type == PlayerRenamed {
+		p.rename(event)
+		return
+	}
+
+	stats, ok := p.stats[event.Player]
+	if !ok {
+		stats = &PlayerStats{Name: event.Player, Created: event.Time}
+		p.stats[event.Player] = stats
+	}
+
+	switch event.Type {
+	case PlayerCreated:
+		if stats.Deleted {
+			stats.Created = event.Time
+		}
+		stats.Deleted = false
+		stats.Wins = event.Wins
+	case WinRecorded:
+		stats.Wins++
+		stats.WinsRecorded++
+		when := event.Time
+		stats.LastWin = &when
+	case PlayerOverwritten:
+		stats.Wins = event.Wins
+		stats.Overwrites++
+	case PlayerDeleted:
+		stats.Deleted = true
+		stats.Deletions++
+		stats.Wins = 0
+	}
+	if stats.Wins > stats.BestWins {
+		stats.BestWins = stats.Wins
+	}
+}
+
+// rename carries the stats over to the new name, replacing any kept for a
+// deleted player who had it
+func (p *StatsProjection) rename(event DomainEvent) {
+	stats, ok := p.stats[event.From]
+	if !ok {
+		return
+	}
+	delete(p.stats, event.From)
+	stats.Name = event.Player
+	stats.PreviousNames = append(stats.PreviousNames, event.From)
+	p.stats[event.Player] = stats
+}
+
+// Stats returns a player's stats
+func (p *StatsProjection) Stats(name string) (PlayerStats, bool) {
+	stats, ok := p.stats[name]
+	if !ok {
+		return PlayerStats{}, false
+	}
+	copied := *stats
+	return copied, true
+}
+
+// HistoryProjection keeps every event for each player
+type HistoryProjection struct {
+	events map[string][]DomainEvent
+}
+
+func (p *HistoryProjection) Reset() {
+	p.events = map[string][]DomainEvent{}
+}
+
+func (p *HistoryProjection) Apply(event DomainEvent) {
+	if event.Type == PlayerRenamed {
+		// the history goes with the player, as their stats do
+		p.events[event.Player] = append(p.events[event.From], event)
+		delete(p.events, event.From)
+		return
+	}
+	p.events[event.Player] = append(p.events[event.Player], event)
+}
+
+// History returns a player's events, oldest first
+func (p *HistoryProjection) History(name string) []DomainEvent {
+	return append([]DomainEvent{}, p.events[name]...)
+}
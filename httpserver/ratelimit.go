@@ -0,0 +1,148 @@
+package httpserver
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: Rate tokens per second, holding at most Burst
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RouteLimit applies Limit to requests whose path starts with Prefix.
+// An empty Method matches every method.
+type RouteLimit struct {
+	Method string
+	Prefix string
+	Limit  RateLimit
+}
+
+// DefaultRateLimits are strict on recording wins and loose on reading the league
+var DefaultRateLimits = []RouteLimit{
+	{Method: http.MethodPost, Prefix: "/store/", Limit: RateLimit{Rate: 1, Burst: 5}},
+	{Prefix: "/batch", Limit: RateLimit{Rate: 1, Burst: 5}},
+	{Prefix: "/store/", Limit: RateLimit{Rate: 10, Burst: 20}},
+	{Prefix: "/list", Limit: RateLimit{Rate: 20, Burst: 40}},
+	{Prefix: "/login", Limit: RateLimit{Rate: 1, Burst: 5}},
+}
+
+// rateLimitSweepInterval is how often idle buckets are looked for
+const rateLimitSweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+// rateLimiter holds one bucket per route and client
+type rateLimiter struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{now: time.Now, buckets: map[string]*bucket{}}
+}
+
+// allow takes a token from the bucket for key. It returns whether the request
+// may go ahead, the tokens left and how long until the bucket is full again.
+func (l *rateLimiter) allow(key string, limit RateLimit) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	untilFull := time.Duration((float64(limit.Burst) - b.tokens) / limit.Rate * float64(time.Second))
+	b.full = now.Add(untilFull)
+	return allowed, int(b.tokens), untilFull
+}
+
+// sweep drops buckets that have been idle long enough to have refilled, which
+// is indistinguishable from a new bucket. It runs at most once per interval.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// size is the number of buckets being tracked
+func (l *rateLimiter) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// matchRouteLimit returns the first RouteLimit that applies to r
+func matchRouteLimit(limits []RouteLimit, r *http.Request) (int, RouteLimit, bool) {
+	for i, route := range limits {
+		if route.Method != "" && route.Method != r.Method {
+			continue
+		}
+		if strings.HasPrefix(r.URL.Path, route.Prefix) {
+			return i, route, true
+		}
+	}
+	return 0, RouteLimit{}, false
+}
+
+// rateLimit rejects requests with 429 once a client has used up its bucket for
+// the matching route in p.RateLimits
+func (p *PlayerServer) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idx, route, ok := matchRouteLimit(p.RateLimits, r)
+		if !ok || route.Limit.Rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := fmt.Sprintf("%d|%s", idx, p.identity(r))
+		allowed, remaining, untilFull := p.limiter.allow(key, route.Limit)
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(route.Limit.Burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(untilFull.Seconds()))))
+
+		if !allowed {
+			log.Println("rate limited", r.Method, r.URL, p.identity(r))
+			retryAfter := math.Ceil(1 / route.Limit.Rate)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,126 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	current time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.current }
+func (c *fakeClock) advance(d time.Duration) { c.current = c.current.Add(d) }
+
+func newRateLimitedServer(limits []RouteLimit) (*PlayerServer, *fakeClock) {
+	store := newStore(map[string]int{"Pepper": 20})
+	server := NewPlayerServer(&store)
+	server.RateLimits = limits
+
+	clock := &fakeClock{current: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	server.limiter.now = clock.now
+	return server, clock
+}
+
+func serveFrom(server *PlayerServer, request *http.Request, remoteAddr string) *httptest.ResponseRecorder {
+	request.RemoteAddr = remoteAddr
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, request)
+	return response
+}
+
+func TestRateLimit(t *testing.T) {
+	winLimit := []RouteLimit{
+		{Method: http.MethodPost, Prefix: "/store/", Limit: RateLimit{Rate: 1, Burst: 2}},
+	}
+
+	t.Run("rejects requests over the burst with 429", func(t *testing.T) {
+		server, _ := newRateLimitedServer(winLimit)
+
+		for i := 0; i < 2; i++ {
+			response := serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.1:1234")
+			assertStatus(t, response.Code, http.StatusAccepted)
+		}
+
+		response := serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.1:1234")
+		assertStatus(t, response.Code, http.StatusTooManyRequests)
+		assertHeader(t, response, "X-RateLimit-Limit", "2")
+		assertHeader(t, response, "X-RateLimit-Remaining", "0")
+		assertHeader(t, response, "Retry-After", "1")
+	})
+
+	t.Run("refills the bucket over time", func(t *testing.T) {
+		server, clock := newRateLimitedServer(winLimit)
+
+		serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.1:1234")
+		serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.1:1234")
+		clock.advance(time.Second)
+
+		response := serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.1:1234")
+		assertStatus(t, response.Code, http.StatusAccepted)
+	})
+
+	t.Run("keeps separate buckets per client", func(t *testing.T) {
+		server, _ := newRateLimitedServer(winLimit)
+
+		serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.1:1234")
+		serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.1:1234")
+
+		response := serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.2:1234")
+		assertStatus(t, response.Code, http.StatusAccepted)
+	})
+
+	t.Run("keys logged in clients by user rather than IP", func(t *testing.T) {
+		server, _ := newRateLimitedServer(winLimit)
+		token, _ := server.IssueToken("user_a")
+
+		for i := 0; i < 2; i++ {
+			request := newPostWinRequest("Pepper")
+			request.Header.Set("Authorization", "Bearer "+token)
+			serveFrom(server, request, "10.0.0.1:1234")
+		}
+
+		request := newPostWinRequest("Pepper")
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := serveFrom(server, request, "10.0.0.2:1234")
+		assertStatus(t, response.Code, http.StatusTooManyRequests)
+
+		response = serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.1:1234")
+		assertStatus(t, response.Code, http.StatusAccepted)
+	})
+
+	t.Run("only limits matching routes", func(t *testing.T) {
+		server, _ := newRateLimitedServer(winLimit)
+
+		for i := 0; i < 5; i++ {
+			response := serveFrom(server, newGetScoreRequest("Pepper"), "10.0.0.1:1234")
+			assertStatus(t, response.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("evicts idle buckets", func(t *testing.T) {
+		server, clock := newRateLimitedServer(winLimit)
+
+		serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.1:1234")
+		serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.2:1234")
+		if got := server.limiter.size(); got != 2 {
+			t.Fatalf("got %d buckets want 2", got)
+		}
+
+		clock.advance(rateLimitSweepInterval)
+		serveFrom(server, newPostWinRequest("Pepper"), "10.0.0.3:1234")
+
+		if got := server.limiter.size(); got != 1 {
+			t.Errorf("got %d buckets after sweep want 1", got)
+		}
+	})
+}
+
+func assertHeader(t testing.TB, response *httptest.ResponseRecorder, name, want string) {
+	t.Helper()
+	if got := response.Header().Get(name); got != want {
+		t.Errorf("got header %s %q want %q", name, got, want)
+	}
+}
@@ -0,0 +1,235 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hello/client"
+	"log"
+	"sync"
+	"time"
+)
+
+// RemotePlayerStore is a PlayerStore that keeps the league on another
+// PlayerServer, through its HTTP API.
+//
+// PlayerStore has no way to return errors, so a RemotePlayerStore handles them
+// like this: a failed read answers from the cache if it still holds the
+// answer, and otherwise with no wins or an empty league. A failed write is
+// not tried again later, and is returned by the FallibleStore methods so the
+// PlayerServer can report it. Every failure is passed to OnError, and the last
+// one is kept for Err and the /readyz checks.
+type RemotePlayerStore struct {
+	// Client calls the remote server. Log it in to act as a user there.
+	Client *client.Client
+	// Timeout limits each call to the remote server, retries included
+	Timeout time.Duration
+	// CacheTTL is how long scores and the league are answered from the local
+	// cache before asking the remote server again. 0 turns the cache off.
+	CacheTTL time.Duration
+	// OnError is told about every failed call, it logs them when nil
+	OnError func(op string, err error)
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	league   League
+	leagueAt time.Time
+	scores   map[string]cachedScore
+	swept    time.Time
+	lastErr  error
+	writeErr error
+}
+
+type cachedScore struct {
+	wins int
+	at   time.Time
+}
+
+// NewRemotePlayerStore creates a RemotePlayerStore for the server at baseURL
+func NewRemotePlayerStore(baseURL string) *RemotePlayerStore {
+	return &RemotePlayerStore{
+		Client:   client.New(baseURL),
+		Timeout:  5 * time.Second,
+		CacheTTL: time.Second,
+		now:      time.Now,
+		scores:   map[string]cachedScore{},
+	}
+}
+
+func (s *RemotePlayerStore) callContext() (context.Context, context.CancelFunc) {
+	if s.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), s.Timeout)
+}
+
+// fresh reports whether something cached at was cached recently enough to use
+func (s *RemotePlayerStore) fresh(at time.Time) bool {
+	return s.CacheTTL > 0 && !at.IsZero() && s.now().Sub(at) < s.CacheTTL
+}
+
+// failed records err from op and passes it to OnError, returning the wrapped error
+func (s *RemotePlayerStore) failed(op string, err error, write bool) error {
+	err = fmt.Errorf("remote store %s, %w", op, err)
+	s.mu.Lock()
+	s.lastErr = err
+	if write {
+		s.writeErr = err
+	}
+	s.mu.Unlock()
+
+	if s.OnError != nil {
+		s.OnError(op, err)
+		return err
+	}
+	log.Println(err)
+	return err
+}
+
+// Err returns the last error from the remote server, nil if there has been none
+func (s *RemotePlayerStore) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+func (s *RemotePlayerStore) GetPlayerScore(name string) int {
+	s.mu.Lock()
+	cached, ok := s.scores[name]
+	s.mu.Unlock()
+	if ok && s.fresh(cached.at) {
+		return cached.wins
+	}
+
+	ctx, cancel := s.callContext()
+	defer cancel()
+	wins, err := s.Client.GetScore(ctx, name)
+	if err != nil && !errors.Is(err, client.ErrNotFound) {
+		s.failed("getting score for "+name, err, false)
+		return cached.wins
+	}
+
+	if s.CacheTTL > 0 {
+		s.mu.Lock()
+		s.forgetExpired()
+		s.scores[name] = cachedScore{wins: wins, at: s.now()}
+		s.mu.Unlock()
+	}
+	return wins
+}
+
+// forgetExpired drops expired scores, at most once every CacheTTL, so that
+// asking for many different names doesn't fill up memory
+func (s *RemotePlayerStore) forgetExpired() {
+	now := s.now()
+	if now.Sub(s.swept) < s.CacheTTL {
+		return
+	}
+	for name, cached := range s.scores {
+		if !s.fresh(cached.at) {
+			delete(s.scores, name)
+		}
+	}
+	s.swept = now
+}
+
+func (s *RemotePlayerStore) GetLeague() League {
+	s.mu.Lock()
+	league, at := s.league, s.leagueAt
+	s.mu.Unlock()
+	if s.fresh(at) {
+		return append(League{}, league...)
+	}
+
+	ctx, cancel := s.callContext()
+	defer cancel()
+	players, err := s.Client.League(ctx)
+	if err != nil {
+		s.failed("getting league", err, false)
+		return append(League{}, league...)
+	}
+
+	league = make(League, len(players))
+	for i, player := range players {
+		league[i] = Player{player.Name, player.Wins}
+	}
+	s.mu.Lock()
+	s.league, s.leagueAt = league, s.now()
+	s.mu.Unlock()
+	return append(League{}, league...)
+}
+
+func (s *RemotePlayerStore) RecordWin(name string) {
+	s.TryRecordWin(name)
+}
+
+func (s *RemotePlayerStore) RecordNewPlayer(player Player) {
+	s.TryRecordNewPlayer(player)
+}
+
+func (s *RemotePlayerStore) DeletePlayer(name string) {
+	s.TryDeletePlayer(name)
+}
+
+// TryRecordWin is RecordWin, returning the error if the remote server couldn't record it
+func (s *RemotePlayerStore) TryRecordWin(name string) error {
+	return s.write("recording win for "+name, func(ctx context.Context) error {
+		return s.Client.RecordWin(ctx, name)
+	}, name)
+}
+
+// TryRecordNewPlayer is RecordNewPlayer, returning the error if the remote server couldn't store it
+func (s *RemotePlayerStore) TryRecordNewPlayer(player Player) error {
+	return s.write("setting "+player.Name, func(ctx context.Context) error {
+		return s.Client.PutPlayers(ctx, client.Player{Name: player.Name, Wins: player.Wins})
+	}, player.Name)
+}
+
+// TryDeletePlayer is DeletePlayer, returning the error if the remote server couldn't delete them
+func (s *RemotePlayerStore) TryDeletePlayer(name string) error {
+	return s.write("deleting "+name, func(ctx context.Context) error {
+		return s.Client.Delete(ctx, name)
+	}, name)
+}
+
+// write sends a change and forgets what the cache knew about the players it touched
+func (s *RemotePlayerStore) write(op string, send func(context.Context) error, names ...string) error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+	err := send(ctx)
+
+	s.mu.Lock()
+	s.leagueAt = time.Time{}
+	for _, name := range names {
+		delete(s.scores, name)
+	}
+	if err == nil {
+		s.writeErr = nil
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return s.failed(op, err, true)
+	}
+	return nil
+}
+
+// CheckRead pings the remote server
+func (s *RemotePlayerStore) CheckRead() error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+	return s.Client.Ping(ctx)
+}
+
+// CheckWrite returns the error from the last write, if it failed
+func (s *RemotePlayerStore) CheckWrite() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeErr
+}
+
+// Close does nothing, there is nothing local to release
+func (s *RemotePlayerStore) Close() error {
+	return nil
+}
@@ -0,0 +1,160 @@
+package httpserver
+
+import (
+	"errors"
+	"hello/client"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newRemoteStore starts a server backed by an event sourced store and returns
+// a RemotePlayerStore pointing at it, along with the store behind it
+func newRemoteStore(t testing.TB) (*RemotePlayerStore, *EventSourcedPlayerStore, *httptest.Server, *fakeClock) {
+	t.Helper()
+	backend := newEventSourcedStore(t)
+	remote := httptest.NewServer(NewPlayerServer(backend))
+	t.Cleanup(remote.Close)
+
+	clock := &fakeClock{current: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store := NewRemotePlayerStore(remote.URL)
+	store.now = clock.now
+	store.Client.RetryBase = time.Millisecond
+	store.Client.RetryMax = time.Millisecond
+	store.OnError = func(string, error) {}
+	return store, backend, remote, clock
+}
+
+func TestRemotePlayerStore(t *testing.T) {
+	t.Run("changes the league on the remote server", func(t *testing.T) {
+		store, backend, _, _ := newRemoteStore(t)
+
+		store.RecordWin("Pepper")
+		store.RecordWin("Pepper")
+		store.RecordNewPlayer(Player{"Cleo", 10})
+		store.RecordNewPlayer(Player{"Chris", 33})
+		store.DeletePlayer("Chris")
+
+		assertLeague(t, backend.GetLeague(), League{{"Cleo", 10}, {"Pepper", 2}})
+		assertLeague(t, store.GetLeague(), League{{"Cleo", 10}, {"Pepper", 2}})
+		assertScoreEquals(t, store.GetPlayerScore("Pepper"), 2)
+		assertScoreEquals(t, store.GetPlayerScore("Nobody"), 0)
+		assertNoError(t, store.Err())
+	})
+
+	t.Run("answers from the cache until it expires", func(t *testing.T) {
+		store, backend, _, clock := newRemoteStore(t)
+		store.CacheTTL = time.Minute
+		backend.RecordNewPlayer(Player{"Cleo", 10})
+
+		assertScoreEquals(t, store.GetPlayerScore("Cleo"), 10)
+		assertLeague(t, store.GetLeague(), League{{"Cleo", 10}})
+
+		backend.RecordWin("Cleo")
+		assertScoreEquals(t, store.GetPlayerScore("Cleo"), 10)
+		assertLeague(t, store.GetLeague(), League{{"Cleo", 10}})
+
+		clock.advance(time.Minute)
+		assertScoreEquals(t, store.GetPlayerScore("Cleo"), 11)
+		assertLeague(t, store.GetLeague(), League{{"Cleo", 11}})
+	})
+
+	t.Run("forgets expired scores", func(t *testing.T) {
+		store, _, _, clock := newRemoteStore(t)
+		store.CacheTTL = time.Minute
+		for _, name := range []string{"a", "b", "c"} {
+			store.GetPlayerScore(name)
+		}
+
+		clock.advance(2 * time.Minute)
+		store.GetPlayerScore("d")
+
+		if len(store.scores) != 1 {
+			t.Errorf("got %d cached scores want only d's", len(store.scores))
+		}
+	})
+
+	t.Run("forgets cached players it changes", func(t *testing.T) {
+		store, _, _, _ := newRemoteStore(t)
+		store.CacheTTL = time.Minute
+		store.RecordNewPlayer(Player{"Cleo", 10})
+
+		assertScoreEquals(t, store.GetPlayerScore("Cleo"), 10)
+		store.RecordWin("Cleo")
+		assertScoreEquals(t, store.GetPlayerScore("Cleo"), 11)
+		assertLeague(t, store.GetLeague(), League{{"Cleo", 11}})
+	})
+
+	t.Run("answers from an old cache when the remote server is down", func(t *testing.T) {
+		store, backend, remote, clock := newRemoteStore(t)
+		var failures []string
+		store.OnError = func(op string, err error) { failures = append(failures, op) }
+		backend.RecordNewPlayer(Player{"Cleo", 10})
+
+		store.GetPlayerScore("Cleo")
+		store.GetLeague()
+		remote.Close()
+		clock.advance(time.Hour)
+
+		assertScoreEquals(t, store.GetPlayerScore("Cleo"), 10)
+		assertLeague(t, store.GetLeague(), League{{"Cleo", 10}})
+		assertScoreEquals(t, store.GetPlayerScore("Chris"), 0)
+		if len(failures) != 3 {
+			t.Errorf("got failures %v want one for each read", failures)
+		}
+		if store.Err() == nil || store.CheckRead() == nil {
+			t.Error("expected the remote server to be reported down")
+		}
+	})
+
+	t.Run("reports failed writes until one succeeds", func(t *testing.T) {
+		store, _, remote, _ := newRemoteStore(t)
+		server := remote.Config.Handler.(*PlayerServer)
+		server.Rules = []Rule{ExistingPlayersOnly{}}
+
+		store.RecordWin("Nobody")
+		err := store.CheckWrite()
+		if !errors.Is(err, client.ErrBlocked) {
+			t.Fatalf("got %v want the blocked win reported", err)
+		}
+
+		store.RecordNewPlayer(Player{"Nobody", 1})
+		assertNoError(t, store.CheckWrite())
+	})
+
+	t.Run("lets one server front another", func(t *testing.T) {
+		store, backend, _, _ := newRemoteStore(t)
+		front := NewPlayerServer(store)
+
+		front.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Pepper"))
+
+		response := httptest.NewRecorder()
+		front.ServeHTTP(response, newGetScoreRequest("Pepper"))
+		assertStatus(t, response.Code, http.StatusOK)
+		assertResponseBody(t, response.Body.String(), "1")
+		assertScoreEquals(t, backend.GetPlayerScore("Pepper"), 1)
+	})
+
+	t.Run("fronts failed writes with a 502", func(t *testing.T) {
+		store, _, remote, _ := newRemoteStore(t)
+		remote.Config.Handler.(*PlayerServer).Rules = []Rule{ExistingPlayersOnly{}}
+		front := NewPlayerServer(store)
+		front.Rules = []Rule{NewMaxWinsPerHour(1)}
+
+		response := httptest.NewRecorder()
+		front.ServeHTTP(response, newPostWinRequest("Nobody"))
+		assertStatus(t, response.Code, http.StatusBadGateway)
+
+		// the failed win doesn't count against the limit
+		remote.Config.Handler.(*PlayerServer).Rules = nil
+		response = httptest.NewRecorder()
+		front.ServeHTTP(response, newPostWinRequest("Nobody"))
+		assertStatus(t, response.Code, http.StatusAccepted)
+
+		remote.Close()
+		response = httptest.NewRecorder()
+		front.ServeHTTP(response, newPutPlayerRequest("", []byte(`{"Name": "Cleo", "Wins": 3}`)))
+		assertStatus(t, response.Code, http.StatusBadGateway)
+	})
+}
@@ -0,0 +1,175 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// RenameRequest is the body of POST /admin/rename
+type RenameRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MergeRequest is the body of POST /admin/merge. From's wins are added to Into
+// and From is removed, along with their profile.
+type MergeRequest struct {
+	From string `json:"from"`
+	Into string `json:"into"`
+}
+
+// canonicalName returns the name of an existing player that matches name
+// ignoring case, when NormalizeNames is on
+func (p *PlayerServer) canonicalName(name string) string {
+	if !p.NormalizeNames {
+		return name
+	}
+	return canonicalIn(p.Store.GetLeague(), name)
+}
+
+// canonicalIn finds the spelling of name already used in league, if any
+func canonicalIn(league League, name string) string {
+	if player, _ := league.Find(name); player != nil {
+		return name
+	}
+	if player, _ := league.FindFold(name); player != nil {
+		return player.Name
+	}
+	return name
+}
+
+// renamePlayer moves all of from's data to a new name
+func renamePlayer(league League, from, to string, normalize bool) (League, error) {
+	if strings.TrimSpace(to) == "" {
+		return nil, newOperationError(http.StatusBadRequest, "new name is empty")
+	}
+	player, _ := league.Find(from)
+	if player == nil {
+		return nil, newOperationError(http.StatusNotFound, "player %s does not exist", from)
+	}
+
+	existing, _ := league.Find(to)
+	if normalize {
+		existing, _ = league.FindFold(to)
+	}
+	if existing != nil && existing != player {
+		return nil, newOperationError(http.StatusConflict, "player %s already exists, merge them instead", existing.Name)
+	}
+
+	player.Name = to
+	return league, nil
+}
+
+// mergePlayers adds from's wins to into and removes from
+func mergePlayers(league League, from, into string) (League, error) {
+	if from == into {
+		return nil, newOperationError(http.StatusBadRequest, "can't merge %s into itself", from)
+	}
+	source, idx := league.Find(from)
+	if source == nil {
+		return nil, newOperationError(http.StatusNotFound, "player %s does not exist", from)
+	}
+	target, _ := league.Find(into)
+	if target == nil {
+		return nil, newOperationError(http.StatusNotFound, "player %s does not exist", into)
+	}
+
+	target.Wins += source.Wins
+	return append(league[:idx], league[idx+1:]...), nil
+}
+
+// renameHandler renames a player, keeping their wins
+func (p *PlayerServer) renameHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	var request RenameRequest
+	if !decodeAdminRequest(w, r, &request) {
+		return
+	}
+
+	entry := JournalEntry{Op: MutationRename}
+	entry.rename(request.From, request.To)
+	p.applyAdminChange(w, r, entry,
+		[]Mutation{{Kind: MutationRename, Player: request.From, NewName: request.To}},
+		func(league League) (League, error) {
+			return renamePlayer(league, request.From, request.To, p.NormalizeNames)
+		})
+}
+
+// mergeHandler folds one player into another
+func (p *PlayerServer) mergeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	var request MergeRequest
+	if !decodeAdminRequest(w, r, &request) {
+		return
+	}
+
+	p.applyAdminChange(w, r, JournalEntry{Op: OpMerge},
+		[]Mutation{{Kind: MutationDelete, Player: request.From}, {Kind: MutationSet, Player: request.Into}},
+		func(league League) (League, error) {
+			return mergePlayers(league, request.From, request.Into)
+		})
+}
+
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("problem parsing request, %v", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// applyAdminChange runs change atomically against the store, records it in the
+// journal and replies with the recorded entry
+func (p *PlayerServer) applyAdminChange(w http.ResponseWriter, r *http.Request, entry JournalEntry, mutations []Mutation, change func(League) (League, error)) {
+	updater, ok := p.Store.(LeagueUpdater)
+	if !ok {
+		http.Error(w, "store does not support atomic updates", http.StatusNotImplemented)
+		return
+	}
+
+	check := p.newRuleCheck()
+	entry.Actor = p.identity(r)
+	err := p.updateLeague(&entry, updater, func(league League) (League, error) {
+		for _, m := range mutations {
+			err := check.check(m, league)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return change(league)
+	})
+
+	if err != nil {
+		check.release()
+		if ruleErr, ok := err.(*RuleError); ok {
+			writeRuleError(w, ruleErr)
+			return
+		}
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("content-type", jsonContentType)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// playersNamed copies the players with the given names out of league
+func playersNamed(league League, names ...string) []Player {
+	players := []Player{}
+	for _, name := range names {
+		if player, _ := league.Find(name); player != nil {
+			players = append(players, *player)
+		}
+	}
+	return players
+}
@@ -0,0 +1,192 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newAdminPostRequest(path, body string) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	return req
+}
+
+// asAdmin logs request in as an admin of server
+func asAdmin(t testing.TB, server *PlayerServer, request *http.Request) *http.Request {
+	t.Helper()
+	token, err := server.IssueToken("admin")
+	assertNoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+token)
+	return request
+}
+
+func getHistoryFromResponse(t testing.TB, response *httptest.ResponseRecorder) (entries []JournalEntry) {
+	t.Helper()
+	err := json.NewDecoder(response.Body).Decode(&entries)
+	if err != nil {
+		t.Fatalf("Unable to parse history, %v", err)
+	}
+	return
+}
+
+func TestRename(t *testing.T) {
+	t.Run("keeps the player's wins under the new name", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/rename", `{"from": "Cleo", "to": "Cleopatra"}`)))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleopatra", 10}})
+	})
+
+	t.Run("won't rename onto an existing player", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/rename", `{"from": "Cleo", "to": "Chris"}`)))
+
+		assertStatus(t, response.Code, http.StatusConflict)
+		assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+	})
+
+	t.Run("missing players are not found", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/rename", `{"from": "Nobody", "to": "Somebody"}`)))
+
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func TestMerge(t *testing.T) {
+	t.Run("adds the wins together and removes the old player", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, `[{"Name": "Pepper", "Wins": 10}, {"Name": "pepper", "Wins": 4}]`)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/merge", `{"from": "pepper", "into": "Pepper"}`)))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Pepper", 14}})
+	})
+
+	t.Run("drops the old player's profile", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, `[{"Name": "Pepper", "Wins": 10}, {"Name": "pepper", "Wins": 4}]`)
+		defer clean()
+		assertNoError(t, store.SetProfile("pepper", Profile{Team: "red"}))
+
+		server.ServeHTTP(httptest.NewRecorder(), asAdmin(t, server, newAdminPostRequest("/admin/merge", `{"from": "pepper", "into": "Pepper"}`)))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("pepper"))
+
+		if got, ok := store.GetProfile("pepper"); ok {
+			t.Errorf("a new pepper inherited profile %+v", got)
+		}
+	})
+
+	t.Run("won't merge a player into itself", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/merge", `{"from": "Cleo", "into": "Cleo"}`)))
+
+		assertStatus(t, response.Code, http.StatusBadRequest)
+	})
+}
+
+func TestAdminHistory(t *testing.T) {
+	server, _, clean := newFileSystemServer(t, `[{"Name": "Pepper", "Wins": 10}, {"Name": "pepper", "Wins": 4}]`)
+	defer clean()
+
+	server.ServeHTTP(httptest.NewRecorder(), asAdmin(t, server, newAdminPostRequest("/admin/merge", `{"from": "pepper", "into": "Pepper"}`)))
+	server.ServeHTTP(httptest.NewRecorder(), asAdmin(t, server, newAdminPostRequest("/admin/rename", `{"from": "Pepper", "to": "Salt"}`)))
+	server.ServeHTTP(httptest.NewRecorder(), asAdmin(t, server, newAdminPostRequest("/admin/rename", `{"from": "Nobody", "to": "Salt"}`)))
+	// wins are in the journal but aren't administrative changes
+	server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Salt"))
+
+	request, _ := http.NewRequest(http.MethodGet, "/admin/history", nil)
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, asAdmin(t, server, request))
+
+	entries := getHistoryFromResponse(t, response)
+	if len(entries) != 2 {
+		t.Fatalf("got %d history entries want 2: %+v", len(entries), entries)
+	}
+
+	rename, merge := entries[0], entries[1]
+	if rename.Op != "rename" || rename.Renamed["Salt"] != "Pepper" {
+		t.Errorf("got rename entry %+v", rename)
+	}
+	assertLeague(t, rename.Before, []Player{{"Pepper", 14}})
+	assertLeague(t, rename.After, []Player{{"Salt", 14}})
+
+	if merge.Op != OpMerge || merge.ID != 1 {
+		t.Errorf("got merge entry %+v", merge)
+	}
+	assertLeague(t, merge.Before, []Player{{"Pepper", 10}, {"pepper", 4}})
+	assertLeague(t, merge.After, []Player{{"Pepper", 14}})
+}
+
+func TestNormalizeNames(t *testing.T) {
+	t.Run("records wins against the existing spelling", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, `[{"Name": "Pepper", "Wins": 10}]`)
+		defer clean()
+		server.NormalizeNames = true
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("pepper"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("PEPPER"))
+
+		assertLeague(t, store.GetLeague(), []Player{{"Pepper", 12}})
+	})
+
+	t.Run("stops renames that only differ by case from another player", func(t *testing.T) {
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		server.NormalizeNames = true
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, newAdminPostRequest("/admin/rename", `{"from": "Cleo", "to": "CHRIS"}`)))
+
+		assertStatus(t, response.Code, http.StatusConflict)
+	})
+
+	t.Run("applies to batches", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		server.NormalizeNames = true
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newBatchRequest(t, Operation{Op: "win", Name: "cleo"}, Operation{Op: "set", Name: "CHRIS", Wins: 1}))
+
+		assertStatus(t, response.Code, http.StatusOK)
+		assertLeague(t, store.GetLeague(), []Player{{"Cleo", 11}, {"Chris", 1}})
+	})
+
+	t.Run("applies to players put in the league", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, `[{"Name": "Pepper", "Wins": 10}]`)
+		defer clean()
+		server.NormalizeNames = true
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPutPlayerRequest("", []byte(`[{"Name": "pepper", "Wins": 3}, {"Name": "floyd", "Wins": 1}, {"Name": "FLOYD", "Wins": 2}]`)))
+
+		assertStatus(t, response.Code, http.StatusAccepted)
+		assertLeague(t, store.GetLeague(), []Player{{"Pepper", 3}, {"floyd", 2}})
+	})
+
+	t.Run("is off by default", func(t *testing.T) {
+		server, store, clean := newFileSystemServer(t, `[{"Name": "Pepper", "Wins": 10}]`)
+		defer clean()
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("pepper"))
+
+		assertLeague(t, store.GetLeague(), []Player{{"Pepper", 10}, {"pepper", 1}})
+	})
+}
@@ -0,0 +1,320 @@
+package httpserver
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Kinds of Mutation
+const (
+	MutationWin    = "win"
+	MutationSet    = "set"
+	MutationDelete = "delete"
+	MutationRename = "rename"
+)
+
+// Mutation describes a change a request wants to make to the league
+type Mutation struct {
+	Kind   string
+	Player string
+	// Wins is the new win count for MutationSet
+	Wins int
+	// NewName is the name a player is given by MutationRename
+	NewName string
+}
+
+// Rule decides whether a mutation may go ahead. league is the league before the change.
+type Rule interface {
+	Name() string
+	Check(m Mutation, league League) error
+}
+
+// releaser is implemented by rules that count the mutations they allow, such
+// as MaxWinsPerHour. Check counts a mutation as soon as it passes, so
+// concurrent requests can't all pass before any of them is counted, and
+// Release gives the count back when the mutation isn't applied after all.
+type releaser interface {
+	Release(m Mutation)
+}
+
+// RuleError is returned when a rule blocks a mutation
+type RuleError struct {
+	Rule   string
+	Status int
+	Reason string
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("blocked by rule %s: %s", e.Rule, e.Reason)
+}
+
+// ruleCheck runs mutations through a server's rules and remembers the ones
+// that passed, so they can be released if the change isn't applied
+type ruleCheck struct {
+	rules  []Rule
+	passed []Mutation
+}
+
+func (p *PlayerServer) newRuleCheck() *ruleCheck {
+	return &ruleCheck{rules: p.Rules}
+}
+
+// check runs m through every rule. league is the league before m.
+func (c *ruleCheck) check(m Mutation, league League) error {
+	for i, rule := range c.rules {
+		err := rule.Check(m, league)
+		if err != nil {
+			releaseRules(c.rules[:i], m)
+			return err
+		}
+	}
+	c.passed = append(c.passed, m)
+	return nil
+}
+
+// release gives back everything that passed, for a change that wasn't applied
+func (c *ruleCheck) release() {
+	for _, m := range c.passed {
+		releaseRules(c.rules, m)
+	}
+	c.passed = nil
+}
+
+func releaseRules(rules []Rule, m Mutation) {
+	for _, rule := range rules {
+		if r, ok := rule.(releaser); ok {
+			r.Release(m)
+		}
+	}
+}
+
+// checkMutations runs every mutation through p.Rules. Either all of them pass,
+// or none of them are counted and the first failure is returned.
+func (p *PlayerServer) checkMutations(mutations ...Mutation) (*ruleCheck, error) {
+	check := p.newRuleCheck()
+	if len(p.Rules) == 0 {
+		return check, nil
+	}
+
+	league := p.Store.GetLeague()
+	for _, m := range mutations {
+		err := check.check(m, league)
+		if err != nil {
+			check.release()
+			return nil, err
+		}
+	}
+	return check, nil
+}
+
+// allowMutations checks mutations against p.Rules, writing an error response
+// naming the rule if one of them is blocked. The check is released if the
+// change then can't be made.
+func (p *PlayerServer) allowMutations(w http.ResponseWriter, mutations ...Mutation) (*ruleCheck, bool) {
+	check, err := p.checkMutations(mutations...)
+	if err == nil {
+		return check, true
+	}
+
+	writeRuleError(w, err)
+	return nil, false
+}
+
+func writeRuleError(w http.ResponseWriter, err error) {
+	log.Println(err)
+	status := http.StatusBadRequest
+	if ruleErr, ok := err.(*RuleError); ok {
+		status = ruleErr.Status
+		w.Header().Set("X-Blocked-By-Rule", ruleErr.Rule)
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// MaxWinsPerHour stops a player from being given more than Limit wins in any hour
+type MaxWinsPerHour struct {
+	Limit int
+
+	now       func() time.Time
+	mu        sync.Mutex
+	wins      map[string][]time.Time
+	lastSweep time.Time
+}
+
+// maxWinsSweepInterval is how often players with no wins in the last hour are forgotten
+const maxWinsSweepInterval = time.Minute
+
+// NewMaxWinsPerHour creates a MaxWinsPerHour rule
+func NewMaxWinsPerHour(limit int) *MaxWinsPerHour {
+	return &MaxWinsPerHour{Limit: limit, now: time.Now, wins: map[string][]time.Time{}}
+}
+
+func (r *MaxWinsPerHour) Name() string { return "max-wins-per-hour" }
+
+// Check counts the win as it allows it, Release gives it back
+func (r *MaxWinsPerHour) Check(m Mutation, league League) error {
+	if m.Kind != MutationWin {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep()
+
+	recent := r.recent(m.Player)
+	if len(recent) >= r.Limit {
+		return &RuleError{
+			Rule:   r.Name(),
+			Status: http.StatusTooManyRequests,
+			Reason: fmt.Sprintf("%s already has %d wins in the last hour", m.Player, r.Limit),
+		}
+	}
+	r.wins[m.Player] = append(recent, r.now())
+	return nil
+}
+
+// Release forgets the latest win counted for the player, after it wasn't recorded
+func (r *MaxWinsPerHour) Release(m Mutation) {
+	if m.Kind != MutationWin {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wins := r.wins[m.Player]
+	if len(wins) <= 1 {
+		delete(r.wins, m.Player)
+		return
+	}
+	r.wins[m.Player] = wins[:len(wins)-1]
+}
+
+// recent drops wins older than an hour and returns the rest
+func (r *MaxWinsPerHour) recent(player string) []time.Time {
+	cutoff := r.now().Add(-time.Hour)
+	wins := r.wins[player]
+
+	kept := wins[:0]
+	for _, at := range wins {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(r.wins, player)
+		return nil
+	}
+	r.wins[player] = kept
+	return kept
+}
+
+// sweep forgets players with no wins in the last hour. It runs at most once
+// per interval.
+func (r *MaxWinsPerHour) sweep() {
+	now := r.now()
+	if now.Sub(r.lastSweep) < maxWinsSweepInterval {
+		return
+	}
+	r.lastSweep = now
+
+	for player := range r.wins {
+		r.recent(player)
+	}
+}
+
+// tracked is the number of players whose wins are being counted
+func (r *MaxWinsPerHour) tracked() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.wins)
+}
+
+// ExistingPlayersOnly stops wins being recorded for players that are not in
+// the league. Without it RecordWin creates the player.
+type ExistingPlayersOnly struct{}
+
+func (ExistingPlayersOnly) Name() string { return "existing-players-only" }
+
+func (r ExistingPlayersOnly) Check(m Mutation, league League) error {
+	if m.Kind != MutationWin {
+		return nil
+	}
+
+	if player, _ := league.Find(m.Player); player == nil {
+		return &RuleError{
+			Rule:   r.Name(),
+			Status: http.StatusNotFound,
+			Reason: fmt.Sprintf("player %s does not exist and auto-create is off", m.Player),
+		}
+	}
+	return nil
+}
+
+// StandardRules are the rules a server runs with: wins can't go below zero,
+// names must match namePattern if it isn't nil, players are only created by
+// their first win when autoCreate is set, and a positive maxWinsPerHour limits wins.
+func StandardRules(namePattern *regexp.Regexp, autoCreate bool, maxWinsPerHour int) []Rule {
+	rules := []Rule{NonNegativeWins{}}
+	if namePattern != nil {
+		rules = append(rules, NamePolicy{Pattern: namePattern})
+	}
+	if !autoCreate {
+		rules = append(rules, ExistingPlayersOnly{})
+	}
+	if maxWinsPerHour > 0 {
+		rules = append(rules, NewMaxWinsPerHour(maxWinsPerHour))
+	}
+	return rules
+}
+
+// NamePolicy rejects player names that don't match Pattern. A nil Pattern
+// allows every name.
+type NamePolicy struct {
+	Pattern *regexp.Regexp
+}
+
+// DefaultNamePattern allows letters, digits, spaces, dots, dashes and underscores
+var DefaultNamePattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} ._-]{0,63}$`)
+
+func (NamePolicy) Name() string { return "name-policy" }
+
+func (r NamePolicy) Check(m Mutation, league League) error {
+	if m.Kind == MutationDelete || r.Pattern == nil {
+		// let badly named players be cleaned up
+		return nil
+	}
+
+	name := m.Player
+	if m.Kind == MutationRename {
+		name = m.NewName
+	}
+
+	if !r.Pattern.MatchString(name) {
+		return &RuleError{
+			Rule:   r.Name(),
+			Status: http.StatusUnprocessableEntity,
+			Reason: fmt.Sprintf("name %q does not match %s", name, r.Pattern),
+		}
+	}
+	return nil
+}
+
+// NonNegativeWins rejects setting a player's wins below zero
+type NonNegativeWins struct{}
+
+func (NonNegativeWins) Name() string { return "non-negative-wins" }
+
+func (r NonNegativeWins) Check(m Mutation, league League) error {
+	if m.Kind == MutationSet && m.Wins < 0 {
+		return &RuleError{
+			Rule:   r.Name(),
+			Status: http.StatusUnprocessableEntity,
+			Reason: fmt.Sprintf("%s can't have %d wins", m.Player, m.Wins),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRules(t *testing.T) {
+	t.Run("caps wins per player per hour", func(t *testing.T) {
+		store := newStore(map[string]int{})
+		server := NewPlayerServer(&store)
+		clock := &fakeClock{current: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+		maxWins := NewMaxWinsPerHour(2)
+		maxWins.now = clock.now
+		server.Rules = []Rule{maxWins}
+
+		for i := 0; i < 2; i++ {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, newPostWinRequest("Pepper"))
+			assertStatus(t, response.Code, http.StatusAccepted)
+		}
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPostWinRequest("Pepper"))
+		assertStatus(t, response.Code, http.StatusTooManyRequests)
+		assertHeader(t, response, "X-Blocked-By-Rule", "max-wins-per-hour")
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newPostWinRequest("Floyd"))
+		assertStatus(t, response.Code, http.StatusAccepted)
+
+		clock.advance(time.Hour)
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newPostWinRequest("Pepper"))
+		assertStatus(t, response.Code, http.StatusAccepted)
+
+		if len(store.winCalls) != 4 {
+			t.Errorf("got %d calls to RecordWin want 4", len(store.winCalls))
+		}
+	})
+
+	t.Run("counts wins as they are allowed so concurrent requests can't pass the cap", func(t *testing.T) {
+		maxWins := NewMaxWinsPerHour(5)
+
+		var allowed int32
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if maxWins.Check(Mutation{Kind: MutationWin, Player: "Pepper"}, nil) == nil {
+					atomic.AddInt32(&allowed, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if allowed != 5 {
+			t.Errorf("got %d wins allowed want 5", allowed)
+		}
+	})
+
+	t.Run("gives back wins that weren't recorded", func(t *testing.T) {
+		maxWins := NewMaxWinsPerHour(1)
+		win := Mutation{Kind: MutationWin, Player: "Pepper"}
+
+		assertNoError(t, maxWins.Check(win, nil))
+		maxWins.Release(win)
+		assertNoError(t, maxWins.Check(win, nil))
+		if maxWins.Check(win, nil) == nil {
+			t.Error("expected the second counted win to be blocked")
+		}
+	})
+
+	t.Run("forgets players with no wins in the last hour", func(t *testing.T) {
+		clock := &fakeClock{current: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+		maxWins := NewMaxWinsPerHour(2)
+		maxWins.now = clock.now
+
+		for _, name := range []string{"Pepper", "Floyd", "Cleo"} {
+			assertNoError(t, maxWins.Check(Mutation{Kind: MutationWin, Player: name}, nil))
+		}
+		clock.advance(time.Hour + maxWinsSweepInterval)
+		assertNoError(t, maxWins.Check(Mutation{Kind: MutationWin, Player: "Chris"}, nil))
+
+		if tracked := maxWins.tracked(); tracked != 1 {
+			t.Errorf("got %d players tracked want only Chris", tracked)
+		}
+	})
+
+	t.Run("rejects wins for unknown players when auto-create is off", func(t *testing.T) {
+		store := StubPlayerStore{league: League{{"Pepper", 3}}}
+		server := NewPlayerServer(&store)
+		server.Rules = []Rule{ExistingPlayersOnly{}}
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPostWinRequest("Nobody"))
+		assertStatus(t, response.Code, http.StatusNotFound)
+		assertHeader(t, response, "X-Blocked-By-Rule", "existing-players-only")
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newPostWinRequest("Pepper"))
+		assertStatus(t, response.Code, http.StatusAccepted)
+
+		if len(store.winCalls) != 1 {
+			t.Errorf("got %d calls to RecordWin want 1", len(store.winCalls))
+		}
+	})
+
+	t.Run("rejects names that break the naming policy", func(t *testing.T) {
+		store := newStore(map[string]int{})
+		server := NewPlayerServer(&store)
+		server.Rules = []Rule{NamePolicy{DefaultNamePattern}}
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPostWinRequest("<script>"))
+		assertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		assertHeader(t, response, "X-Blocked-By-Rule", "name-policy")
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newPostWinRequest("Chris_2"))
+		assertStatus(t, response.Code, http.StatusAccepted)
+	})
+
+	t.Run("allows any name without a pattern", func(t *testing.T) {
+		store := newStore(map[string]int{})
+		server := NewPlayerServer(&store)
+		server.Rules = []Rule{NamePolicy{}}
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPostWinRequest("<script>"))
+		assertStatus(t, response.Code, http.StatusAccepted)
+
+		for _, rule := range StandardRules(nil, true, 0) {
+			if rule.Name() == "name-policy" {
+				t.Error("got a name policy without a pattern")
+			}
+		}
+	})
+
+	t.Run("blocks a whole PUT if any player breaks a rule", func(t *testing.T) {
+		store := newStore(map[string]int{})
+		server := NewPlayerServer(&store)
+		server.Rules = []Rule{NonNegativeWins{}}
+
+		body, _ := json.Marshal([]Player{{"Cleo", 3}, {"Chris", -1}})
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newPutPlayerRequest("Cleo", body))
+
+		assertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		if len(store.NewUserCalls) != 0 {
+			t.Errorf("got %d calls to RecordNewPlayer want 0", len(store.NewUserCalls))
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// currentSchemaVersion is the version of the database file this code writes.
+// Older files are upgraded by the migrations below.
+const currentSchemaVersion = 2
+
+// database is everything FileSystemPlayerStore keeps on disk
+type database struct {
+	Version  int                `json:"version"`
+	Checksum string             `json:"checksum,omitempty"`
+	League   League             `json:"league"`
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+}
+
+// newDatabase builds the current version of the database, checksum and all
+func newDatabase(league League, profiles map[string]Profile) database {
+	return database{Version: currentSchemaVersion, Checksum: databaseChecksum(league, profiles), League: league, Profiles: profiles}
+}
+
+// databaseChecksum covers the league and profiles as they encode to JSON, so
+// formatting a file by hand doesn't change it but editing a value does
+func databaseChecksum(league League, profiles map[string]Profile) string {
+	content, _ := json.Marshal(database{League: league, Profiles: profiles})
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// migration upgrades the raw JSON of a database from version from to from+1
+type migration struct {
+	from        int
+	description string
+	apply       func(raw []byte) ([]byte, error)
+}
+
+// migrations must be in order, one for every version before currentSchemaVersion
+var migrations = []migration{
+	{0, "wrap the bare array of players in a versioned object", migrateBareLeague},
+	{1, "add a checksum of the league and profiles", migrateAddChecksum},
+}
+
+func init() {
+	if len(migrations) != currentSchemaVersion {
+		panic(fmt.Sprintf("have %d migrations for schema version %d", len(migrations), currentSchemaVersion))
+	}
+	for i, m := range migrations {
+		if m.from != i {
+			panic(fmt.Sprintf("migration %d upgrades from version %d", i, m.from))
+		}
+	}
+}
+
+// migrateBareLeague turns version 0, a bare array of players, into version 1
+func migrateBareLeague(raw []byte) ([]byte, error) {
+	var league League
+	err := json.Unmarshal(raw, &league)
+	if err != nil {
+		return nil, err
+	}
+	if league == nil {
+		league = League{}
+	}
+	return json.Marshal(map[string]interface{}{"version": 1, "league": league})
+}
+
+// migrateAddChecksum stamps version 1 with the checksum version 2 expects
+func migrateAddChecksum(raw []byte) ([]byte, error) {
+	var db database
+	err := json.Unmarshal(raw, &db)
+	if err != nil {
+		return nil, err
+	}
+	db.Version = 2
+	db.Checksum = databaseChecksum(db.League, db.Profiles)
+	return json.Marshal(db)
+}
+
+// schemaVersion works out which version a database file is in
+func schemaVersion(raw []byte) (int, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return 0, nil
+	}
+
+	var header struct {
+		Version *int `json:"version"`
+	}
+	err := json.Unmarshal(trimmed, &header)
+	if err != nil {
+		return 0, fmt.Errorf("problem parsing league, %v", err)
+	}
+	if header.Version == nil {
+		return 0, fmt.Errorf("problem parsing league, no version in database")
+	}
+	return *header.Version, nil
+}
+
+// migrate upgrades raw to the current schema, returning the version it started at
+func migrate(raw []byte) ([]byte, int, error) {
+	version, err := schemaVersion(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > currentSchemaVersion {
+		return nil, version, fmt.Errorf("database is version %d, this server only understands up to %d", version, currentSchemaVersion)
+	}
+
+	for _, m := range migrations[version:] {
+		raw, err = m.apply(bytes.TrimSpace(raw))
+		if err != nil {
+			return nil, version, fmt.Errorf("problem migrating from version %d (%s), %v", m.from, m.description, err)
+		}
+	}
+	return raw, version, nil
+}
+
+// loadDatabase parses a database file of any known version, upgrading it to
+// the current one. It returns the version the file was in.
+func loadDatabase(raw []byte) (database, int, error) {
+	upgraded, version, err := migrate(raw)
+	if err != nil {
+		return database{}, version, err
+	}
+
+	var db database
+	err = json.Unmarshal(upgraded, &db)
+	if err != nil {
+		return database{}, version, fmt.Errorf("problem parsing league, %v", err)
+	}
+	return db, version, nil
+}
+
+// backupDatabase keeps a copy of a database before it is migrated
+func backupDatabase(path string, raw []byte, version int) (string, error) {
+	return copyDatabase(path, raw, fmt.Sprintf("v%d", version), "migrating")
+}
+
+// copyDatabase keeps the raw contents of a database next to it, before
+// something like a migration or repair changes it
+func copyDatabase(path string, raw []byte, tag, reason string) (string, error) {
+	backup := fmt.Sprintf("%s.%s-%s.bak", path, tag, time.Now().UTC().Format("20060102T150405.000000000Z"))
+	err := os.WriteFile(backup, raw, 0600)
+	if err != nil {
+		return "", fmt.Errorf("problem backing up %s before %s, %v", path, reason, err)
+	}
+	log.Printf("backed up %s to %s before %s", path, backup, reason)
+	return backup, nil
+}
@@ -0,0 +1,90 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// schemaFixtures has a database file for every version this code has written
+var schemaFixtures = map[int]struct {
+	file     string
+	profiles map[string]Profile
+}{
+	0: {"testdata/v0.json", map[string]Profile{}},
+	1: {"testdata/v1.json", map[string]Profile{"Cleo": {DisplayName: "Cleo the Great", Team: "red"}}},
+	2: {"testdata/v2.json", map[string]Profile{"Cleo": {DisplayName: "Cleo the Great", Team: "red"}}},
+}
+
+func TestSchemaFixturesCoverEveryVersion(t *testing.T) {
+	for version := 0; version <= currentSchemaVersion; version++ {
+		if _, ok := schemaFixtures[version]; !ok {
+			t.Errorf("no fixture for schema version %d", version)
+		}
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	for version, fixture := range schemaFixtures {
+		t.Run(fmt.Sprintf("upgrades version %d", version), func(t *testing.T) {
+			raw, err := os.ReadFile(fixture.file)
+			assertNoError(t, err)
+			file, clean := createTempFile(t, string(raw))
+			defer clean()
+
+			store, err := NewFileSystemPlayerStore(file)
+			assertNoError(t, err)
+
+			assertLeague(t, store.GetLeague(), []Player{{"Chris", 33}, {"Cleo", 10}})
+			if !reflect.DeepEqual(store.profiles, fixture.profiles) {
+				t.Errorf("got profiles %+v want %+v", store.profiles, fixture.profiles)
+			}
+
+			file.Seek(0, 0)
+			var onDisk database
+			assertNoError(t, json.NewDecoder(file).Decode(&onDisk))
+			if onDisk.Version != currentSchemaVersion {
+				t.Errorf("file is version %d after loading, want %d", onDisk.Version, currentSchemaVersion)
+			}
+
+			backups, _ := filepath.Glob(file.Name() + ".v*.bak")
+			if version == currentSchemaVersion && len(backups) != 0 {
+				t.Errorf("current version files shouldn't be backed up, got %v", backups)
+			}
+			if version != currentSchemaVersion {
+				if len(backups) != 1 {
+					t.Fatalf("got backups %v want one", backups)
+				}
+				backedUp, _ := os.ReadFile(backups[0])
+				if string(backedUp) != string(raw) {
+					t.Errorf("backup doesn't match the original file")
+				}
+			}
+		})
+	}
+
+	t.Run("refuses files from a newer version", func(t *testing.T) {
+		file, clean := createTempFile(t, fmt.Sprintf(`{"version": %d, "league": []}`, currentSchemaVersion+1))
+		defer clean()
+
+		_, err := NewFileSystemPlayerStore(file)
+
+		if err == nil {
+			t.Errorf("expected an error loading a newer database")
+		}
+	})
+
+	t.Run("refuses objects without a version", func(t *testing.T) {
+		file, clean := createTempFile(t, `{"league": []}`)
+		defer clean()
+
+		_, err := NewFileSystemPlayerStore(file)
+
+		if err == nil {
+			t.Errorf("expected an error loading an unversioned object")
+		}
+	})
+}
@@ -1,4 +1,3 @@
-
 package httpserver
 
 import (
@@ -8,10 +7,25 @@ import (
 // needed to isolate a fix bug with using seek
 type tape struct {
 	file *os.File
+	// key encrypts everything written when it is set
+	key *DatabaseKey
 }
 
 func (t *tape) Write(p []byte) (n int, err error) {
+	data := p
+	if t.key != nil {
+		data, err = t.key.seal(p)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	t.file.Truncate(0) //New: basically empties a file
 	t.file.Seek(0, 0)
-	return t.file.Write(p)
-}
\ No newline at end of file
+	_, err = t.file.Write(data)
+	if err != nil {
+		return 0, err
+	}
+	// make sure the write has reached the disk before reporting success
+	return len(p), t.file.Sync()
+}
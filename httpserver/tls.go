@@ -0,0 +1,212 @@
+package httpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a certificate/key pair from disk, reloading it whenever
+// either file changes
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.changed() {
+		err := c.reloadLocked()
+		if err != nil {
+			// keep serving the old certificate until the new one is usable
+			log.Printf("problem reloading certificate %s, %v", c.certFile, err)
+		}
+	}
+	return c.cert, nil
+}
+
+func (c *certReloader) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reloadLocked()
+}
+
+func (c *certReloader) reloadLocked() error {
+	certTime, keyTime, err := c.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("problem loading key pair %s %s, %v", c.certFile, c.keyFile, err)
+	}
+
+	c.cert = &cert
+	c.certTime = certTime
+	c.keyTime = keyTime
+	return nil
+}
+
+func (c *certReloader) changed() bool {
+	certTime, keyTime, err := c.modTimes()
+	if err != nil {
+		return false
+	}
+	return !certTime.Equal(c.certTime) || !keyTime.Equal(c.keyTime)
+}
+
+func (c *certReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("problem reading certificate %s, %v", c.certFile, err)
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("problem reading key %s, %v", c.keyFile, err)
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// tlsEnabled reports whether the server has been given a certificate to serve
+func (p *PlayerServer) tlsEnabled() bool {
+	return p.TLSCertFile != "" && p.TLSKeyFile != ""
+}
+
+// configureTLS fills in p.TLSConfig from the configured certificate files
+func (p *PlayerServer) configureTLS() error {
+	reloader, err := newCertReloader(p.TLSCertFile, p.TLSKeyFile)
+	if err != nil {
+		return err
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if p.ClientCAFile != "" {
+		pem, err := os.ReadFile(p.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("problem reading client CA file %s, %v", p.ClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %s", p.ClientCAFile)
+		}
+		// Client certificates are only enforced on admin routes, see adminOnly
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = pool
+	}
+
+	p.TLSConfig = config
+	return nil
+}
+
+// ListenAndServe listens on p.Addr, serving HTTPS when a certificate is
+// configured. If RedirectAddr is set a second, plain HTTP listener is started
+// that only redirects to HTTPS.
+func (p *PlayerServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", p.Addr)
+	if err != nil {
+		return err
+	}
+
+	if p.tlsEnabled() && p.RedirectAddr != "" {
+		p.redirect = &http.Server{Addr: p.RedirectAddr, Handler: redirectToHTTPS(p.Addr)}
+		go func() {
+			err := p.redirect.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("problem serving HTTP redirects on %s, %v", p.RedirectAddr, err)
+			}
+		}()
+	}
+
+	return p.Serve(listener)
+}
+
+// Serve accepts connections on listener, over TLS when a certificate is configured
+func (p *PlayerServer) Serve(listener net.Listener) error {
+	if !p.tlsEnabled() {
+		return p.Server.Serve(listener)
+	}
+
+	err := p.configureTLS()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	return p.Server.ServeTLS(listener, "", "")
+}
+
+// redirectToHTTPS sends every request to the same path on the HTTPS address
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Println(r.Method, r.URL, r.RemoteAddr)
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+// adminOnly requires a verified client certificate when ClientCAFile is set,
+// otherwise a token from logging in as one of adminUsers
+func (p *PlayerServer) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p.ClientCAFile != "" {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				log.Println("rejected admin request without client certificate", r.Method, r.URL, r.RemoteAddr)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		username, ok := p.authenticatedUser(r)
+		if !ok {
+			log.Println("rejected admin request without a token", r.Method, r.URL, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !adminUsers[username] {
+			log.Println("rejected admin request from", username, r.Method, r.URL, r.RemoteAddr)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
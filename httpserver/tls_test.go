@@ -0,0 +1,235 @@
+package httpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+	keyPair tls.Certificate
+}
+
+// newTestCert makes a certificate for 127.0.0.1, self-signed when parent is nil
+func newTestCert(t testing.TB, serial int64, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assertNoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "league test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assertNoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assertNoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assertNoError(t, err)
+
+	c := &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+	c.keyPair, err = tls.X509KeyPair(c.certPEM, c.keyPEM)
+	assertNoError(t, err)
+	return c
+}
+
+func (c *testCert) writeFiles(t testing.TB, dir string, modTime time.Time) (string, string) {
+	t.Helper()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assertNoError(t, os.WriteFile(certFile, c.certPEM, 0600))
+	assertNoError(t, os.WriteFile(keyFile, c.keyPEM, 0600))
+	assertNoError(t, os.Chtimes(certFile, modTime, modTime))
+	assertNoError(t, os.Chtimes(keyFile, modTime, modTime))
+	return certFile, keyFile
+}
+
+// startTLSServer serves server on a random local port and returns its address
+func startTLSServer(t testing.TB, server *PlayerServer) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assertNoError(t, err)
+
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Server.Close() })
+	return listener.Addr().String()
+}
+
+func newTLSClient(roots *x509.CertPool, clientCerts ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: clientCerts,
+	}}}
+}
+
+func servedSerial(t testing.TB, addr string, roots *x509.CertPool) int64 {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots})
+	assertNoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLS(t *testing.T) {
+	t.Run("serves HTTPS from the configured files", func(t *testing.T) {
+		cert := newTestCert(t, 1, true, nil)
+		certFile, keyFile := cert.writeFiles(t, t.TempDir(), time.Now())
+
+		store := newStore(map[string]int{"Pepper": 20})
+		server := NewPlayerServer(&store)
+		server.TLSCertFile, server.TLSKeyFile = certFile, keyFile
+		addr := startTLSServer(t, server)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(cert.cert)
+		response, err := newTLSClient(roots).Get("https://" + addr + "/store/Pepper")
+		assertNoError(t, err)
+		defer response.Body.Close()
+
+		assertStatus(t, response.StatusCode, http.StatusOK)
+	})
+
+	t.Run("reloads the certificate when the files change", func(t *testing.T) {
+		dir := t.TempDir()
+		first := newTestCert(t, 1, true, nil)
+		certFile, keyFile := first.writeFiles(t, dir, time.Now().Add(-time.Minute))
+
+		store := newStore(map[string]int{})
+		server := NewPlayerServer(&store)
+		server.TLSCertFile, server.TLSKeyFile = certFile, keyFile
+		addr := startTLSServer(t, server)
+
+		second := newTestCert(t, 2, true, nil)
+		roots := x509.NewCertPool()
+		roots.AddCert(first.cert)
+		roots.AddCert(second.cert)
+
+		if got := servedSerial(t, addr, roots); got != 1 {
+			t.Fatalf("got serial %d before reload want 1", got)
+		}
+
+		second.writeFiles(t, dir, time.Now())
+
+		if got := servedSerial(t, addr, roots); got != 2 {
+			t.Errorf("got serial %d after reload want 2", got)
+		}
+	})
+
+	t.Run("admin routes need a client certificate when a client CA is set", func(t *testing.T) {
+		dir := t.TempDir()
+		ca := newTestCert(t, 1, true, nil)
+		serverCert := newTestCert(t, 2, false, ca)
+		clientCert := newTestCert(t, 3, false, ca)
+		certFile, keyFile := serverCert.writeFiles(t, dir, time.Now())
+		caFile := filepath.Join(dir, "ca.pem")
+		assertNoError(t, os.WriteFile(caFile, ca.certPEM, 0600))
+
+		store := newStore(map[string]int{"Pepper": 20})
+		server := NewPlayerServer(&store)
+		server.TLSCertFile, server.TLSKeyFile, server.ClientCAFile = certFile, keyFile, caFile
+		addr := startTLSServer(t, server)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+
+		response, err := newTLSClient(roots).Get("https://" + addr + "/store/Pepper")
+		assertNoError(t, err)
+		response.Body.Close()
+		assertStatus(t, response.StatusCode, http.StatusOK)
+
+		response, err = newTLSClient(roots).Get("https://" + addr + "/shutdown")
+		assertNoError(t, err)
+		response.Body.Close()
+		assertStatus(t, response.StatusCode, http.StatusForbidden)
+
+		response, err = newTLSClient(roots, clientCert.keyPair).Get("https://" + addr + "/shutdown")
+		assertNoError(t, err)
+		response.Body.Close()
+		assertStatus(t, response.StatusCode, http.StatusAccepted)
+	})
+}
+
+func TestAdminOnly(t *testing.T) {
+	store := newStore(map[string]int{"Pepper": 20})
+	server := NewPlayerServer(&store)
+	history := func(token string) int {
+		request, _ := http.NewRequest(http.MethodGet, "/admin/history", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	t.Run("needs a token without a client CA", func(t *testing.T) {
+		assertStatus(t, history(""), http.StatusUnauthorized)
+		assertStatus(t, history("made-up"), http.StatusUnauthorized)
+	})
+
+	t.Run("needs the token of an admin", func(t *testing.T) {
+		assertStatus(t, history(loginToken(t, server)), http.StatusForbidden)
+
+		token, err := server.IssueToken("admin")
+		assertNoError(t, err)
+		assertStatus(t, history(token), http.StatusOK)
+	})
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	cases := []struct {
+		httpsAddr string
+		url       string
+		want      string
+	}{
+		{":443", "http://league.example/list?x=1", "https://league.example/list?x=1"},
+		{":8443", "http://league.example:8080/store/Pepper", "https://league.example:8443/store/Pepper"},
+	}
+
+	for _, c := range cases {
+		request, _ := http.NewRequest(http.MethodGet, c.url, nil)
+		response := httptest.NewRecorder()
+
+		redirectToHTTPS(c.httpsAddr).ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusPermanentRedirect)
+		if got := response.Header().Get("Location"); got != c.want {
+			t.Errorf("got redirect to %q want %q", got, c.want)
+		}
+	}
+}
@@ -0,0 +1,872 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Formats a Webhook can be sent in
+const (
+	// WebhookJSON posts the Event as it is
+	WebhookJSON = "json"
+	// WebhookSlack posts {"text": "..."} for Slack style incoming webhooks
+	WebhookSlack = "slack"
+)
+
+// Statuses of a Delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+	DeliveryCancelled = "cancelled"
+)
+
+// webhookEvents are the event types a Webhook can ask for
+var webhookEvents = map[string]bool{
+	EventPlayerCreated:   true,
+	EventWinRecorded:     true,
+	EventScoreChanged:    true,
+	EventPlayerDeleted:   true,
+	EventLeagueReordered: true,
+	EventLeaderChanged:   true,
+}
+
+// Webhook is a URL that is sent league events
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events are the event types to send, all of them when empty
+	Events []string `json:"events,omitempty"`
+	Format string   `json:"format"`
+	// Secret signs every delivery. It is only shown when the webhook is created.
+	Secret  string    `json:"secret,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+func (h Webhook) wants(event Event) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, wanted := range h.Events {
+		if wanted == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the URL, event types and format
+func (h Webhook) Validate() error {
+	target, err := url.Parse(h.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	for _, event := range h.Events {
+		if !webhookEvents[event] {
+			return fmt.Errorf("unknown event type %q", event)
+		}
+	}
+	if h.Format != WebhookJSON && h.Format != WebhookSlack {
+		return fmt.Errorf("format must be %s or %s", WebhookJSON, WebhookSlack)
+	}
+	return nil
+}
+
+// Delivery is one event being sent to one webhook
+type Delivery struct {
+	ID          string     `json:"id"`
+	WebhookID   string     `json:"webhook_id"`
+	Event       Event      `json:"event"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	NextAttempt time.Time  `json:"next_attempt"`
+	LastStatus  int        `json:"last_status,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Finished    *time.Time `json:"finished,omitempty"`
+}
+
+// webhooksCompactEvery is how many changes are appended to the webhooks file
+// before it is rewritten with just the current state
+const webhooksCompactEvery = 1000
+
+// webhookState is everything the dispatcher keeps in its file
+type webhookState struct {
+	NextID   int        `json:"next_id"`
+	Webhooks []Webhook  `json:"webhooks"`
+	Outbox   []Delivery `json:"outbox"`
+	Log      []Delivery `json:"log"`
+}
+
+// webhookRecord is one line of the webhooks file: the whole state, written
+// when the file is compacted, or a change to apply on top of the lines before it
+type webhookRecord struct {
+	State *webhookState `json:"state,omitempty"`
+	// Queued are deliveries added to the outbox
+	Queued []Delivery `json:"queued,omitempty"`
+	NextID int        `json:"next_id,omitempty"`
+	// Delivery is an attempted delivery, moved to the log once it is Finished
+	Delivery *Delivery `json:"delivery,omitempty"`
+}
+
+// WebhookDispatcher sends events to registered webhooks. Registrations and
+// undelivered events are kept in a file so they survive a restart. Queued and
+// attempted deliveries are appended to the file, which is rewritten when
+// webhooks are registered or removed and from time to time so it doesn't
+// grow for ever. Each webhook is sent its deliveries on its own, so a slow
+// receiver doesn't hold up the others.
+type WebhookDispatcher struct {
+	// RetryBase is the wait before the first retry, doubling after each failure up to RetryMax
+	RetryBase   time.Duration
+	RetryMax    time.Duration
+	MaxAttempts int
+	// LogSize is how many finished deliveries are remembered
+	LogSize int
+	// AllowPrivateTargets lets webhooks go to loopback, link-local and private
+	// addresses. They are refused by default, so that whoever registers a
+	// webhook can't use the server to reach internal services.
+	AllowPrivateTargets bool
+
+	path   string
+	key    *DatabaseKey
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	state   webhookState
+	running bool
+	// busy holds the webhooks a delivery is being sent to
+	busy map[string]bool
+
+	// unsaved are the changes not yet appended to the file, oldest first
+	unsaved      []webhookRecord
+	rewrite      bool
+	lines        int
+	compactEvery int
+	// saving is held while writing the file, so changes go in the order they were made
+	saving sync.Mutex
+
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	workers  sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewWebhookDispatcher loads the webhooks and outbox kept at path, which is
+// created when first needed
+func NewWebhookDispatcher(path string) (*WebhookDispatcher, error) {
+	return NewEncryptedWebhookDispatcher(path, nil)
+}
+
+// NewEncryptedWebhookDispatcher is NewWebhookDispatcher with the file encrypted
+// with key as the database is, since it holds webhook secrets and league
+// events. A plaintext file is encrypted the first time it is opened with a key.
+func NewEncryptedWebhookDispatcher(path string, key *DatabaseKey) (*WebhookDispatcher, error) {
+	d := &WebhookDispatcher{
+		RetryBase:    5 * time.Second,
+		RetryMax:     time.Hour,
+		MaxAttempts:  10,
+		LogSize:      1000,
+		path:         path,
+		key:          key,
+		now:          time.Now,
+		state:        webhookState{NextID: 1, Webhooks: []Webhook{}, Outbox: []Delivery{}, Log: []Delivery{}},
+		busy:         map[string]bool{},
+		compactEvery: webhooksCompactEvery,
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	// checked when connecting, as a public name can resolve to a private address
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: 10 * time.Second, Control: d.checkDial}).DialContext
+	d.client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem reading webhooks from %s, %v", path, err)
+	}
+	lines := bytes.Split(raw, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		d.rewrite = d.rewrite || (key != nil && !isSealed(line))
+
+		err := d.replay(line)
+		if errors.Is(err, ErrWrongKey) {
+			return nil, fmt.Errorf("problem opening webhooks in %s, %w", path, err)
+		}
+		if err != nil && i == len(lines)-1 {
+			// the last change was only partly written when the server stopped
+			log.Printf("dropping damaged last line of webhooks %s, %v", path, err)
+			d.rewrite = true
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("problem parsing webhooks in %s at line %d, %w", path, i+1, err)
+		}
+		d.lines++
+	}
+
+	if d.rewrite {
+		err = d.flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// replay applies one line of a webhooks file
+func (d *WebhookDispatcher) replay(line []byte) error {
+	plain, err := unseal(line, d.key)
+	if err != nil {
+		return err
+	}
+	var record webhookRecord
+	err = json.Unmarshal(plain, &record)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case record.State != nil:
+		d.state = *record.State
+	case len(record.Queued) > 0:
+		d.state.Outbox = append(d.state.Outbox, record.Queued...)
+		d.state.NextID = record.NextID
+	case record.Delivery != nil:
+		d.apply(*record.Delivery)
+	default:
+		// files from before changes were appended hold just the state
+		return json.Unmarshal(plain, &d.state)
+	}
+	return nil
+}
+
+// apply puts an attempted delivery back in the outbox, or moves it to the log
+// once it is finished. Callers hold d.mu.
+func (d *WebhookDispatcher) apply(delivery Delivery) {
+	for i := range d.state.Outbox {
+		if d.state.Outbox[i].ID != delivery.ID {
+			continue
+		}
+		if delivery.Finished == nil {
+			d.state.Outbox[i] = delivery
+			return
+		}
+		d.state.Outbox = append(d.state.Outbox[:i], d.state.Outbox[i+1:]...)
+		break
+	}
+	if delivery.Finished == nil {
+		return
+	}
+	d.state.Log = append(d.state.Log, delivery)
+	if len(d.state.Log) > d.LogSize {
+		d.state.Log = append([]Delivery{}, d.state.Log[len(d.state.Log)-d.LogSize:]...)
+	}
+}
+
+// flush appends the unsaved changes to the file, or rewrites it as a single
+// record of the whole state when asked to or once it holds enough records
+func (d *WebhookDispatcher) flush() error {
+	d.saving.Lock()
+	defer d.saving.Unlock()
+
+	d.mu.Lock()
+	records := d.unsaved
+	d.unsaved = nil
+	compact := d.rewrite || d.lines+len(records) > d.compactEvery
+	if compact {
+		d.rewrite = false
+		records = []webhookRecord{{State: &d.state}}
+	}
+	raw, err := d.encode(records)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	if compact {
+		err = writeFileAtomic(d.path, raw)
+	} else {
+		err = appendFile(d.path, raw)
+	}
+	if err != nil {
+		if compact {
+			d.mu.Lock()
+			d.rewrite = true
+			d.mu.Unlock()
+		}
+		return fmt.Errorf("problem saving webhooks to %s, %v", d.path, err)
+	}
+	if compact {
+		d.lines = 0
+	}
+	d.lines += len(records)
+	return nil
+}
+
+// persist is flush for changes nobody is waiting on, so problems are only logged
+func (d *WebhookDispatcher) persist() {
+	if err := d.flush(); err != nil {
+		log.Println(err)
+	}
+}
+
+// encode turns records into lines of the file, encrypted if there is a key.
+// Callers hold d.mu.
+func (d *WebhookDispatcher) encode(records []webhookRecord) ([]byte, error) {
+	var raw []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		if d.key != nil {
+			line, err = d.key.seal(line)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			line = append(line, '\n')
+		}
+		raw = append(raw, line...)
+	}
+	return raw, nil
+}
+
+// appendFile adds data to the end of the file at path, syncing it to disk
+func appendFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Rekey rewrites the file encrypted with key, or in plaintext when key is nil
+func (d *WebhookDispatcher) Rekey(key *DatabaseKey) error {
+	d.mu.Lock()
+	old := d.key
+	d.key = key
+	d.rewrite = true
+	d.mu.Unlock()
+
+	err := d.flush()
+	if err != nil {
+		d.mu.Lock()
+		d.key = old
+		d.mu.Unlock()
+	}
+	return err
+}
+
+func (d *WebhookDispatcher) newID(prefix string) string {
+	id := fmt.Sprintf("%s_%d", prefix, d.state.NextID)
+	d.state.NextID++
+	return id
+}
+
+// Register adds a webhook, making up a secret if it has none
+func (d *WebhookDispatcher) Register(hook Webhook) (Webhook, error) {
+	if hook.Format == "" {
+		hook.Format = WebhookJSON
+	}
+	err := hook.Validate()
+	if err != nil {
+		return Webhook{}, err
+	}
+	if !d.AllowPrivateTargets {
+		target, _ := url.Parse(hook.URL)
+		if privateHost(target.Hostname()) {
+			return Webhook{}, fmt.Errorf("url must not be a loopback, link-local or private address")
+		}
+	}
+	if hook.Secret == "" {
+		raw := make([]byte, 24)
+		_, err = rand.Read(raw)
+		if err != nil {
+			return Webhook{}, err
+		}
+		hook.Secret = hex.EncodeToString(raw)
+	}
+
+	d.mu.Lock()
+	hook.ID = d.newID("wh")
+	hook.Created = d.now()
+	d.state.Webhooks = append(d.state.Webhooks, hook)
+	d.rewrite = true
+	d.mu.Unlock()
+	return hook, d.flush()
+}
+
+// checkDial stops deliveries connecting to private addresses, unless they are allowed
+func (d *WebhookDispatcher) checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !d.AllowPrivateTargets && privateHost(host) {
+		return fmt.Errorf("refusing to send a webhook to private address %s", host)
+	}
+	return nil
+}
+
+// privateHost reports whether host is localhost or a loopback, link-local,
+// private or unspecified IP address
+func privateHost(host string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// Remove deletes a webhook. Its pending deliveries are cancelled when they come due.
+func (d *WebhookDispatcher) Remove(id string) (bool, error) {
+	d.mu.Lock()
+	removed := false
+	for i, hook := range d.state.Webhooks {
+		if hook.ID == id {
+			d.state.Webhooks = append(d.state.Webhooks[:i], d.state.Webhooks[i+1:]...)
+			d.rewrite = true
+			removed = true
+			break
+		}
+	}
+	d.mu.Unlock()
+	if !removed {
+		return false, nil
+	}
+	return true, d.flush()
+}
+
+// Webhooks lists the registered webhooks without their secrets
+func (d *WebhookDispatcher) Webhooks() []Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hooks := make([]Webhook, len(d.state.Webhooks))
+	for i, hook := range d.state.Webhooks {
+		hook.Secret = ""
+		hooks[i] = hook
+	}
+	return hooks
+}
+
+// Deliveries returns the outbox, oldest first, and up to limit finished deliveries, newest first
+func (d *WebhookDispatcher) Deliveries(limit int) (pending []Delivery, finished []Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending = append([]Delivery{}, d.state.Outbox...)
+	if limit <= 0 || limit > len(d.state.Log) {
+		limit = len(d.state.Log)
+	}
+	finished = make([]Delivery, 0, limit)
+	for i := len(d.state.Log) - 1; i >= len(d.state.Log)-limit; i-- {
+		finished = append(finished, d.state.Log[i])
+	}
+	return pending, finished
+}
+
+// Enqueue puts a delivery in the outbox for every webhook that wants each
+// event and appends them to the file
+func (d *WebhookDispatcher) Enqueue(events ...Event) {
+	if d.queue(events...) {
+		d.persist()
+	}
+}
+
+// queue puts a delivery in the outbox for every webhook that wants each event,
+// leaving them to be saved by flush. It reports whether any were queued.
+func (d *WebhookDispatcher) queue(events ...Event) bool {
+	d.mu.Lock()
+	queued := []Delivery{}
+	for _, event := range events {
+		for _, hook := range d.state.Webhooks {
+			if !hook.wants(event) {
+				continue
+			}
+			queued = append(queued, Delivery{
+				ID:          d.newID("dl"),
+				WebhookID:   hook.ID,
+				Event:       event,
+				Status:      DeliveryPending,
+				NextAttempt: d.now(),
+			})
+		}
+	}
+	if len(queued) > 0 {
+		d.state.Outbox = append(d.state.Outbox, queued...)
+		d.unsaved = append(d.unsaved, webhookRecord{Queued: queued, NextID: d.state.NextID})
+	}
+	d.mu.Unlock()
+
+	if len(queued) == 0 {
+		return false
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Start sends deliveries in the background until Stop is called
+func (d *WebhookDispatcher) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running {
+		return
+	}
+	d.running = true
+	go d.run()
+}
+
+// Stop waits for the deliveries in progress, if any, and stops sending.
+// Undelivered events stay in the outbox for next time.
+func (d *WebhookDispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		d.mu.Lock()
+		running := d.running
+		d.mu.Unlock()
+		if running {
+			<-d.done
+		}
+	})
+}
+
+func (d *WebhookDispatcher) run() {
+	defer close(d.done)
+	for {
+		due, wait := d.due()
+		for _, deliveries := range due {
+			d.workers.Add(1)
+			go d.deliver(deliveries)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-d.wake:
+		case <-timer.C:
+		case <-d.stop:
+			timer.Stop()
+			d.workers.Wait()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// deliver sends one webhook its due deliveries in order, then lets run know
+// it can be given more
+func (d *WebhookDispatcher) deliver(deliveries []Delivery) {
+	defer d.workers.Done()
+	defer func() {
+		d.mu.Lock()
+		delete(d.busy, deliveries[0].WebhookID)
+		d.mu.Unlock()
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}()
+
+	for _, delivery := range deliveries {
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+		d.attempt(delivery)
+	}
+}
+
+// due returns the deliveries ready to be tried for each webhook that isn't
+// already being sent some, marking those webhooks busy, and how long until the
+// next delivery is due
+func (d *WebhookDispatcher) due() ([][]Delivery, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	wait := time.Hour
+	byHook := map[string]int{}
+	due := [][]Delivery{}
+	for _, delivery := range d.state.Outbox {
+		if d.busy[delivery.WebhookID] {
+			continue
+		}
+		if until := delivery.NextAttempt.Sub(now); until > 0 {
+			if until < wait {
+				wait = until
+			}
+			continue
+		}
+		i, ok := byHook[delivery.WebhookID]
+		if !ok {
+			i = len(due)
+			byHook[delivery.WebhookID] = i
+			due = append(due, nil)
+		}
+		due[i] = append(due[i], delivery)
+	}
+	for hook := range byHook {
+		d.busy[hook] = true
+	}
+	return due, wait
+}
+
+// attempt sends one delivery and records how it went
+func (d *WebhookDispatcher) attempt(delivery Delivery) {
+	d.mu.Lock()
+	var hook *Webhook
+	for i := range d.state.Webhooks {
+		if d.state.Webhooks[i].ID == delivery.WebhookID {
+			copied := d.state.Webhooks[i]
+			hook = &copied
+		}
+	}
+	d.mu.Unlock()
+
+	if hook == nil {
+		delivery.Status = DeliveryCancelled
+		delivery.LastError = "webhook was removed"
+		d.finish(delivery)
+		return
+	}
+
+	status, err := d.send(*hook, delivery)
+	delivery.Attempts++
+	delivery.LastStatus = status
+	delivery.LastError = ""
+	if err != nil {
+		delivery.LastError = err.Error()
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryDelivered
+		d.finish(delivery)
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = DeliveryFailed
+		log.Printf("giving up on webhook delivery %s to %s after %d attempts, %v", delivery.ID, hook.URL, delivery.Attempts, err)
+		d.finish(delivery)
+	default:
+		delivery.NextAttempt = d.now().Add(d.backoff(delivery.Attempts))
+		d.update(delivery)
+	}
+}
+
+// backoff is how long to wait after the given number of failed attempts
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	wait := d.RetryBase
+	for i := 1; i < attempts && wait < d.RetryMax; i++ {
+		wait *= 2
+	}
+	if wait > d.RetryMax {
+		wait = d.RetryMax
+	}
+	return wait
+}
+
+// update saves a delivery that is still in the outbox
+func (d *WebhookDispatcher) update(delivery Delivery) {
+	d.mu.Lock()
+	d.apply(delivery)
+	d.unsaved = append(d.unsaved, webhookRecord{Delivery: &delivery})
+	d.mu.Unlock()
+	d.persist()
+}
+
+// finish moves a delivery from the outbox to the log
+func (d *WebhookDispatcher) finish(delivery Delivery) {
+	finished := d.now()
+	delivery.Finished = &finished
+	d.update(delivery)
+}
+
+// send posts a delivery, returning the status code it got back
+func (d *WebhookDispatcher) send(hook Webhook, delivery Delivery) (int, error) {
+	body, err := webhookBody(hook, delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := d.now().Unix()
+	request.Header.Set("Content-Type", jsonContentType)
+	request.Header.Set("User-Agent", "league-webhooks/1")
+	request.Header.Set("X-League-Event", delivery.Event.Type)
+	request.Header.Set("X-League-Delivery", delivery.ID)
+	request.Header.Set("X-League-Signature", fmt.Sprintf("t=%d,sha256=%s", timestamp, webhookSignature(hook.Secret, timestamp, body)))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver answered %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// webhookBody is the payload for event in the hook's format
+func webhookBody(hook Webhook, event Event) ([]byte, error) {
+	if hook.Format == WebhookSlack {
+		return json.Marshal(map[string]string{"text": describeEvent(event)})
+	}
+	return json.Marshal(event)
+}
+
+// describeEvent puts an event into words for chat
+func describeEvent(event Event) string {
+	switch event.Type {
+	case EventWinRecorded:
+		return fmt.Sprintf("%s won, now on %d wins", event.Player, event.Wins)
+	case EventPlayerCreated:
+		return fmt.Sprintf("%s joined the league", event.Player)
+	case EventPlayerDeleted:
+		return fmt.Sprintf("%s left the league", event.Player)
+	case EventScoreChanged:
+		return fmt.Sprintf("%s now has %d wins", event.Player, event.Wins)
+	case EventLeaderChanged:
+		return fmt.Sprintf("%s took first place with %d wins", event.Player, event.Wins)
+	default:
+		return "The league table changed"
+	}
+}
+
+// webhookSignature is the hex HMAC-SHA256 of "<timestamp>.<body>"
+func webhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks an X-League-Signature header against the body
+// a receiver was sent. Signatures older than maxAge are rejected so they can't
+// be replayed.
+func VerifyWebhookSignature(secret, header string, body []byte, maxAge time.Duration) error {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "sha256":
+			signature = kv[1]
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("signature is too old")
+	}
+
+	want := webhookSignature(secret, timestamp, body)
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// webhooksHandler manages webhooks: GET and POST /admin/webhooks,
+// DELETE /admin/webhooks/{id} and GET /admin/webhooks/deliveries
+func (p *PlayerServer) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL, r.RemoteAddr)
+	if p.Webhooks == nil {
+		http.Error(w, "webhooks are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/webhooks"), "/")
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		w.Header().Set("content-type", jsonContentType)
+		json.NewEncoder(w).Encode(p.Webhooks.Webhooks())
+
+	case rest == "" && r.Method == http.MethodPost:
+		var hook Webhook
+		if !decodeAdminRequest(w, r, &hook) {
+			return
+		}
+		hook, err := p.Webhooks.Register(hook)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("content-type", jsonContentType)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(hook)
+
+	case rest == "deliveries" && r.Method == http.MethodGet:
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		pending, finished := p.Webhooks.Deliveries(limit)
+		w.Header().Set("content-type", jsonContentType)
+		json.NewEncoder(w).Encode(map[string][]Delivery{"pending": pending, "log": finished})
+
+	case rest != "" && r.Method == http.MethodDelete:
+		removed, err := p.Webhooks.Remove(rest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(w, "no webhook "+rest, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,418 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is an httptest server that records what it is sent
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []receivedWebhook
+	// fail is how many requests to answer with a 500 before succeeding
+	fail int
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t testing.TB) *webhookReceiver {
+	t.Helper()
+	receiver := &webhookReceiver{}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.requests = append(receiver.requests, receivedWebhook{r.Header.Clone(), body})
+		if receiver.fail > 0 {
+			receiver.fail--
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func (r *webhookReceiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook{}, r.requests...)
+}
+
+func newTestDispatcher(t testing.TB) (*WebhookDispatcher, string) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "webhooks")
+	assertNoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "webhooks.json")
+	dispatcher, err := NewWebhookDispatcher(path)
+	assertNoError(t, err)
+	dispatcher.RetryBase = 5 * time.Millisecond
+	// receivers in tests run on 127.0.0.1
+	dispatcher.AllowPrivateTargets = true
+	t.Cleanup(dispatcher.Stop)
+	return dispatcher, path
+}
+
+// waitFor polls until done reports true
+func waitFor(t testing.TB, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	server, _, clean := newFileSystemServer(t, importTestLeague)
+	defer clean()
+	server.Webhooks, _ = newTestDispatcher(t)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodPost, "/admin/webhooks", strings.NewReader(body))
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, request))
+		return response
+	}
+
+	var created Webhook
+	t.Run("registers a webhook and shows its secret once", func(t *testing.T) {
+		response := post(`{"url": "https://chat.example/hooks/1", "events": ["leader_changed"], "format": "slack"}`)
+
+		assertStatus(t, response.Code, http.StatusCreated)
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&created))
+		if created.ID == "" || created.Secret == "" {
+			t.Errorf("got %+v want an id and a secret", created)
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/admin/webhooks", nil)
+		list := httptest.NewRecorder()
+		server.ServeHTTP(list, asAdmin(t, server, request))
+
+		var hooks []Webhook
+		assertNoError(t, json.NewDecoder(list.Body).Decode(&hooks))
+		if len(hooks) != 1 || hooks[0].ID != created.ID || hooks[0].Secret != "" {
+			t.Errorf("got %+v want the webhook without its secret", hooks)
+		}
+	})
+
+	t.Run("rejects bad webhooks", func(t *testing.T) {
+		for _, body := range []string{
+			`{"url": "ftp://chat.example"}`,
+			`{"url": "https://chat.example", "events": ["lunch_served"]}`,
+			`{"url": "https://chat.example", "format": "xml"}`,
+		} {
+			assertStatus(t, post(body).Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("removes webhooks", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/admin/webhooks/"+created.ID, nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, asAdmin(t, server, request))
+		assertStatus(t, response.Code, http.StatusNoContent)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Run("sends signed events the webhook asked for", func(t *testing.T) {
+		receiver := newWebhookReceiver(t)
+		server, _, clean := newFileSystemServer(t, importTestLeague)
+		defer clean()
+		dispatcher, _ := newTestDispatcher(t)
+		server.Webhooks = dispatcher
+		hook, err := dispatcher.Register(Webhook{URL: receiver.URL, Events: []string{EventWinRecorded}})
+		assertNoError(t, err)
+		dispatcher.Start()
+
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Cleo"))
+		server.ServeHTTP(httptest.NewRecorder(), newPostWinRequest("Floyd"))
+
+		waitFor(t, "the delivery", func() bool { return len(receiver.received()) == 1 })
+		got := receiver.received()[0]
+
+		assertNoError(t, VerifyWebhookSignature(hook.Secret, got.header.Get("X-League-Signature"), got.body, time.Minute))
+		if got.header.Get("X-League-Event") != EventWinRecorded {
+			t.Errorf("got event header %q want %q", got.header.Get("X-League-Event"), EventWinRecorded)
+		}
+		var event Event
+		assertNoError(t, json.Unmarshal(got.body, &event))
+		if event.Player != "Cleo" || event.Wins != 11 {
+			t.Errorf("got %+v want Cleo's 11th win", event)
+		}
+
+		waitFor(t, "the delivery log", func() bool {
+			_, finished := dispatcher.Deliveries(0)
+			return len(finished) == 1 && finished[0].Status == DeliveryDelivered
+		})
+	})
+
+	t.Run("posts text for slack webhooks", func(t *testing.T) {
+		receiver := newWebhookReceiver(t)
+		dispatcher, _ := newTestDispatcher(t)
+		_, err := dispatcher.Register(Webhook{URL: receiver.URL, Format: WebhookSlack})
+		assertNoError(t, err)
+		dispatcher.Start()
+
+		dispatcher.Enqueue(Event{ID: 1, Type: EventLeaderChanged, Player: "Cleo", Wins: 34})
+
+		waitFor(t, "the delivery", func() bool { return len(receiver.received()) == 1 })
+		assertResponseBody(t, string(receiver.received()[0].body), `{"text":"Cleo took first place with 34 wins"}`)
+	})
+
+	t.Run("retries with backoff until the receiver answers", func(t *testing.T) {
+		receiver := newWebhookReceiver(t)
+		receiver.fail = 2
+		dispatcher, _ := newTestDispatcher(t)
+		_, err := dispatcher.Register(Webhook{URL: receiver.URL})
+		assertNoError(t, err)
+		dispatcher.Start()
+
+		dispatcher.Enqueue(Event{ID: 1, Type: EventWinRecorded, Player: "Cleo", Wins: 11})
+
+		waitFor(t, "the delivery", func() bool {
+			_, finished := dispatcher.Deliveries(0)
+			return len(finished) == 1
+		})
+		_, finished := dispatcher.Deliveries(0)
+		if finished[0].Status != DeliveryDelivered || finished[0].Attempts != 3 {
+			t.Errorf("got %+v want delivered on the third attempt", finished[0])
+		}
+	})
+
+	t.Run("refuses private addresses unless they are allowed", func(t *testing.T) {
+		dispatcher, _ := newTestDispatcher(t)
+		dispatcher.AllowPrivateTargets = false
+
+		for _, target := range []string{"http://127.0.0.1:8080/", "http://localhost/", "http://169.254.169.254/latest", "https://10.1.2.3/", "http://192.168.0.1/", "http://[::1]/"} {
+			_, err := dispatcher.Register(Webhook{URL: target})
+			if err == nil {
+				t.Errorf("registered %s want it refused", target)
+			}
+		}
+
+		// addresses are checked again when connecting, which catches names
+		// that resolve to private addresses
+		receiver := newWebhookReceiver(t)
+		dispatcher.AllowPrivateTargets = true
+		_, err := dispatcher.Register(Webhook{URL: receiver.URL})
+		assertNoError(t, err)
+		dispatcher.AllowPrivateTargets = false
+		dispatcher.MaxAttempts = 1
+		dispatcher.Start()
+
+		dispatcher.Enqueue(Event{ID: 1, Type: EventWinRecorded})
+
+		waitFor(t, "the delivery to fail", func() bool {
+			_, finished := dispatcher.Deliveries(0)
+			return len(finished) == 1
+		})
+		_, finished := dispatcher.Deliveries(0)
+		if finished[0].Status != DeliveryFailed || !strings.Contains(finished[0].LastError, "private address") || len(receiver.received()) != 0 {
+			t.Errorf("got %+v want it refused before connecting", finished[0])
+		}
+	})
+
+	t.Run("gives up after MaxAttempts", func(t *testing.T) {
+		receiver := newWebhookReceiver(t)
+		receiver.fail = 100
+		dispatcher, _ := newTestDispatcher(t)
+		dispatcher.MaxAttempts = 3
+		_, err := dispatcher.Register(Webhook{URL: receiver.URL})
+		assertNoError(t, err)
+		dispatcher.Start()
+
+		dispatcher.Enqueue(Event{ID: 1, Type: EventWinRecorded})
+
+		waitFor(t, "the delivery to fail", func() bool {
+			_, finished := dispatcher.Deliveries(0)
+			return len(finished) == 1
+		})
+		_, finished := dispatcher.Deliveries(0)
+		if finished[0].Status != DeliveryFailed || finished[0].LastStatus != http.StatusInternalServerError || len(receiver.received()) != 3 {
+			t.Errorf("got %+v after %d requests, want failed after 3", finished[0], len(receiver.received()))
+		}
+	})
+
+	t.Run("encrypts its file with the database key", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "webhooks.json")
+		key := newTestKey(t)
+		dispatcher, err := NewEncryptedWebhookDispatcher(path, key)
+		assertNoError(t, err)
+		_, err = dispatcher.Register(Webhook{URL: "https://hooks.example.com/league"})
+		assertNoError(t, err)
+		dispatcher.Enqueue(Event{ID: 7, Type: EventPlayerCreated, Player: "Floyd"})
+
+		raw, err := os.ReadFile(path)
+		assertNoError(t, err)
+		if bytes.Contains(raw, []byte("Floyd")) || bytes.Contains(raw, []byte("hooks.example.com")) {
+			t.Errorf("found league data in the encrypted file %s", raw)
+		}
+
+		restarted, err := NewEncryptedWebhookDispatcher(path, key)
+		assertNoError(t, err)
+		if pending, _ := restarted.Deliveries(0); len(pending) != 1 {
+			t.Errorf("got %d pending deliveries want 1", len(pending))
+		}
+		_, err = NewWebhookDispatcher(path)
+		if err == nil {
+			t.Error("expected an error opening the file without the key")
+		}
+	})
+
+	t.Run("keeps undelivered events across a restart", func(t *testing.T) {
+		receiver := newWebhookReceiver(t)
+		dispatcher, path := newTestDispatcher(t)
+		_, err := dispatcher.Register(Webhook{URL: receiver.URL})
+		assertNoError(t, err)
+
+		// never started, as if the server stopped before sending
+		dispatcher.Enqueue(Event{ID: 7, Type: EventPlayerCreated, Player: "Floyd"})
+		dispatcher.Stop()
+
+		restarted, err := NewWebhookDispatcher(path)
+		assertNoError(t, err)
+		restarted.AllowPrivateTargets = true
+		defer restarted.Stop()
+		pending, _ := restarted.Deliveries(0)
+		if len(pending) != 1 {
+			t.Fatalf("got %d pending deliveries after restart want 1", len(pending))
+		}
+
+		restarted.Start()
+		waitFor(t, "the delivery", func() bool { return len(receiver.received()) == 1 })
+	})
+}
+
+func TestWebhookFile(t *testing.T) {
+	t.Run("appends deliveries instead of rewriting the file", func(t *testing.T) {
+		dispatcher, path := newTestDispatcher(t)
+		_, err := dispatcher.Register(Webhook{URL: "https://hooks.example.com/league"})
+		assertNoError(t, err)
+		registered, err := os.ReadFile(path)
+		assertNoError(t, err)
+
+		dispatcher.Enqueue(Event{ID: 7, Type: EventPlayerCreated, Player: "Floyd"})
+		dispatcher.Enqueue(Event{ID: 8, Type: EventWinRecorded, Player: "Floyd", Wins: 1})
+
+		raw, err := os.ReadFile(path)
+		assertNoError(t, err)
+		if !bytes.HasPrefix(raw, registered) || bytes.Count(raw, []byte("\n")) != 3 {
+			t.Errorf("got file\n%s\nwant the two deliveries appended to\n%s", raw, registered)
+		}
+	})
+
+	t.Run("replays finished deliveries after a restart", func(t *testing.T) {
+		receiver := newWebhookReceiver(t)
+		dispatcher, path := newTestDispatcher(t)
+		_, err := dispatcher.Register(Webhook{URL: receiver.URL})
+		assertNoError(t, err)
+		dispatcher.Start()
+
+		dispatcher.Enqueue(Event{ID: 7, Type: EventPlayerCreated, Player: "Floyd"})
+		waitFor(t, "the delivery log", func() bool {
+			_, finished := dispatcher.Deliveries(0)
+			return len(finished) == 1
+		})
+		dispatcher.Stop()
+
+		restarted, err := NewWebhookDispatcher(path)
+		assertNoError(t, err)
+		pending, finished := restarted.Deliveries(0)
+		if len(pending) != 0 || len(finished) != 1 || finished[0].Status != DeliveryDelivered {
+			t.Errorf("got pending %+v and log %+v want one delivered", pending, finished)
+		}
+	})
+
+	t.Run("drops a damaged last line", func(t *testing.T) {
+		dispatcher, path := newTestDispatcher(t)
+		_, err := dispatcher.Register(Webhook{URL: "https://hooks.example.com/league"})
+		assertNoError(t, err)
+		dispatcher.Enqueue(Event{ID: 7, Type: EventPlayerCreated, Player: "Floyd"})
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		assertNoError(t, err)
+		file.WriteString(`{"queued":[{"id":"dl_`)
+		file.Close()
+
+		restarted, err := NewWebhookDispatcher(path)
+		assertNoError(t, err)
+		if pending, _ := restarted.Deliveries(0); len(pending) != 1 {
+			t.Errorf("got %d pending deliveries want 1", len(pending))
+		}
+	})
+}
+
+func TestWebhookSlowReceiver(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := newWebhookReceiver(t)
+
+	dispatcher, _ := newTestDispatcher(t)
+	_, err := dispatcher.Register(Webhook{URL: slow.URL})
+	assertNoError(t, err)
+	_, err = dispatcher.Register(Webhook{URL: fast.URL})
+	assertNoError(t, err)
+	dispatcher.Start()
+
+	dispatcher.Enqueue(Event{ID: 1, Type: EventWinRecorded, Player: "Cleo", Wins: 11})
+	dispatcher.Enqueue(Event{ID: 2, Type: EventWinRecorded, Player: "Cleo", Wins: 12})
+
+	waitFor(t, "both deliveries to the fast receiver", func() bool { return len(fast.received()) == 2 })
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	dispatcher := &WebhookDispatcher{RetryBase: time.Second, RetryMax: 5 * time.Second}
+
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 20: 5 * time.Second} {
+		if got := dispatcher.backoff(attempts); got != want {
+			t.Errorf("after %d attempts got %v want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"type":"win_recorded"}`)
+	now := time.Now().Unix()
+	header := "t=" + strconv.FormatInt(now, 10) + ",sha256=" + webhookSignature("secret", now, body)
+
+	assertNoError(t, VerifyWebhookSignature("secret", header, body, time.Minute))
+
+	if VerifyWebhookSignature("secret", header, []byte(`{"type":"player_deleted"}`), time.Minute) == nil {
+		t.Error("expected a changed body to fail")
+	}
+	if VerifyWebhookSignature("other", header, body, time.Minute) == nil {
+		t.Error("expected the wrong secret to fail")
+	}
+
+	old := now - 3600
+	oldHeader := "t=" + strconv.FormatInt(old, 10) + ",sha256=" + webhookSignature("secret", old, body)
+	if VerifyWebhookSignature("secret", oldHeader, body, time.Minute) == nil {
+		t.Error("expected an old signature to fail")
+	}
+}
@@ -0,0 +1,318 @@
+package httpserver
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is mixed into Sec-WebSocket-Accept, see RFC 6455 section 1.3
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// WebSocket close codes
+const (
+	closeNormal        = 1000
+	closeGoingAway     = 1001
+	closeProtocolError = 1002
+	closeUnsupported   = 1003
+	closeTooBig        = 1009
+	closeTryAgainLater = 1013
+)
+
+// maxWebSocketMessage is the largest message, in bytes, accepted from a client
+const maxWebSocketMessage = 64 << 10
+
+// errWebSocketClosed is returned by readMessage once the other side has closed
+var errWebSocketClosed = errors.New("websocket closed")
+
+// wsConn is one end of a WebSocket connection
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	// client is set on the client end, which masks what it sends
+	client bool
+	// onPong is called for every pong received
+	onPong func()
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// websocketAccept is the Sec-WebSocket-Accept answer to a Sec-WebSocket-Key
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether a comma separated header contains token
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection. On failure it has already written an error response.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "this endpoint only speaks WebSocket", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "bad Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("bad websocket key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection can't be upgraded", http.StatusInternalServerError)
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	err = rw.Flush()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// closeError is returned when the other side breaks the protocol
+type closeError struct {
+	code   uint16
+	reason string
+}
+
+func (e *closeError) Error() string {
+	return fmt.Sprintf("websocket error %d: %s", e.code, e.reason)
+}
+
+// readFrame reads a single frame
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	_, err = io.ReadFull(c.rw, head[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0F
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, &closeError{closeProtocolError, "reserved bits set"}
+	}
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, &closeError{closeProtocolError, "frames from clients must be masked and frames from servers must not"}
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.rw, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.rw, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if length > maxWebSocketMessage {
+		return false, 0, nil, &closeError{closeTooBig, "message too big"}
+	}
+	if op >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, &closeError{closeProtocolError, "bad control frame"}
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(c.rw, mask[:])
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.rw, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// readMessage returns the next text or binary message, answering pings and
+// putting fragmented messages back together on the way
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var op byte
+	var message []byte
+
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			if closeErr, ok := err.(*closeError); ok {
+				c.close(closeErr.code, closeErr.reason)
+			}
+			return 0, nil, err
+		}
+
+		switch frameOp {
+		case opPing:
+			err = c.writeFrame(opPong, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if c.onPong != nil {
+				c.onPong()
+			}
+			continue
+		case opClose:
+			code := uint16(closeNormal)
+			if len(payload) >= 2 {
+				code = binary.BigEndian.Uint16(payload)
+			}
+			c.close(code, "")
+			return 0, nil, errWebSocketClosed
+		case opText, opBinary:
+			if op != 0 {
+				c.close(closeProtocolError, "expected a continuation frame")
+				return 0, nil, errWebSocketClosed
+			}
+			op = frameOp
+		case opContinuation:
+			if op == 0 {
+				c.close(closeProtocolError, "unexpected continuation frame")
+				return 0, nil, errWebSocketClosed
+			}
+		default:
+			c.close(closeProtocolError, "unknown opcode")
+			return 0, nil, errWebSocketClosed
+		}
+
+		if len(message)+len(payload) > maxWebSocketMessage {
+			c.close(closeTooBig, "message too big")
+			return 0, nil, errWebSocketClosed
+		}
+		message = append(message, payload...)
+		if fin {
+			return op, message, nil
+		}
+	}
+}
+
+// writeFrame sends a single, final frame
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errWebSocketClosed
+	}
+
+	frame := []byte{0x80 | op}
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		frame = append(append(frame, maskBit|127), ext[:]...)
+	}
+
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.rw.Write(append(frame, payload...))
+	if err == nil {
+		err = c.rw.Flush()
+	}
+	return err
+}
+
+// writeJSON sends v as a text message
+func (c *wsConn) writeJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(opText, data)
+}
+
+// close sends a close frame, if one hasn't been sent already, and drops the connection
+func (c *wsConn) close(code uint16, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+
+	c.writeFrame(opClose, payload)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
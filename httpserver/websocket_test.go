@@ -0,0 +1,123 @@
+package httpserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// newWebSocketPair connects a server and client wsConn in memory
+func newWebSocketPair(t testing.TB) (server, client *wsConn) {
+	t.Helper()
+	serverSide, clientSide := net.Pipe()
+	server = &wsConn{conn: serverSide, rw: bufio.NewReadWriter(bufio.NewReader(serverSide), bufio.NewWriter(serverSide))}
+	client = &wsConn{conn: clientSide, rw: bufio.NewReadWriter(bufio.NewReader(clientSide), bufio.NewWriter(clientSide)), client: true}
+	t.Cleanup(func() {
+		serverSide.Close()
+		clientSide.Close()
+	})
+	return server, client
+}
+
+// writeRawFrame sends a frame with exactly the given header bits, unlike writeFrame
+func writeRawFrame(c *wsConn, fin bool, op byte, masked bool, payload []byte) {
+	first := op
+	if fin {
+		first |= 0x80
+	}
+	second := byte(len(payload))
+	frame := []byte{first, second}
+	if masked {
+		frame[1] |= 0x80
+		frame = append(frame, 0, 0, 0, 0) // a zero mask leaves the payload as it is
+	}
+	c.rw.Write(append(frame, payload...))
+	c.rw.Flush()
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// the example from RFC 6455 section 1.3
+	got := websocketAccept("dGhlIHNhbXBsZSBub25jZQ==")
+	want := "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+	if got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestWebSocketConn(t *testing.T) {
+	t.Run("sends messages both ways", func(t *testing.T) {
+		server, client := newWebSocketPair(t)
+		long := make([]byte, 40000)
+
+		go client.writeFrame(opText, []byte("hello"))
+		op, data, err := server.readMessage()
+		assertNoError(t, err)
+		if op != opText || string(data) != "hello" {
+			t.Errorf("got %d %q want a text hello", op, data)
+		}
+
+		go server.writeFrame(opBinary, long)
+		_, data, err = client.readMessage()
+		assertNoError(t, err)
+		if len(data) != len(long) {
+			t.Errorf("got %d bytes want %d", len(data), len(long))
+		}
+	})
+
+	t.Run("puts fragmented messages back together", func(t *testing.T) {
+		server, client := newWebSocketPair(t)
+
+		go func() {
+			writeRawFrame(client, false, opText, true, []byte("hel"))
+			writeRawFrame(client, true, opPing, true, nil)
+			writeRawFrame(client, true, opContinuation, true, []byte("lo"))
+		}()
+		// the ping in the middle is answered while the message is read
+		go client.readFrame()
+
+		_, data, err := server.readMessage()
+		assertNoError(t, err)
+		if string(data) != "hello" {
+			t.Errorf("got %q want hello", data)
+		}
+	})
+
+	t.Run("answers pings with pongs", func(t *testing.T) {
+		server, client := newWebSocketPair(t)
+		go server.readMessage()
+
+		client.writeFrame(opPing, []byte("are you there"))
+		_, op, payload, err := client.readFrame()
+		assertNoError(t, err)
+		if op != opPong || string(payload) != "are you there" {
+			t.Errorf("got %d %q want a pong echoing the ping", op, payload)
+		}
+	})
+
+	t.Run("closes on unmasked client frames", func(t *testing.T) {
+		server, client := newWebSocketPair(t)
+
+		go writeRawFrame(client, true, opText, false, []byte("hi"))
+		done := make(chan error)
+		go func() {
+			_, _, err := server.readMessage()
+			done <- err
+		}()
+
+		_, op, payload, err := client.readFrame()
+		assertNoError(t, err)
+		if op != opClose || binary.BigEndian.Uint16(payload) != closeProtocolError {
+			t.Errorf("got %d %v want a protocol error close", op, payload)
+		}
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("expected an error reading an unmasked frame")
+			}
+		case <-time.After(time.Second):
+			t.Error("server did not give up on the connection")
+		}
+	})
+}
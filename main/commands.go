@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"hello/httpserver"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// runCommand handles the backup, restore, keygen, rotate-key and fsck subcommands. It reports false
+// when args do not start with one, so main carries on and serves.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "backup":
+		backupCommand(args[1:])
+	case "restore":
+		restoreCommand(args[1:])
+	case "keygen":
+		keygenCommand()
+	case "rotate-key":
+		rotateKeyCommand(args[1:])
+	case "fsck":
+		fsckCommand(args[1:])
+	default:
+		return false
+	}
+	return true
+}
+
+func commandFlags(name string) (*flag.FlagSet, *string, *string, *string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	server := flags.String("server", "", "URL of a running server to ask, e.g. http://localhost:5000. Without it the database file is used directly.")
+	dir := flags.String("backup-dir", "backups", "directory backups are kept in")
+	keyFile := flags.String("db-key-file", "", "file holding the database key, otherwise $"+httpserver.DatabaseKeyEnv+" is used if set")
+	return flags, server, dir, keyFile
+}
+
+// adminTokenFlag adds -token, the admin token sent to -server
+func adminTokenFlag(flags *flag.FlagSet) *string {
+	return flags.String("token", os.Getenv("LEAGUE_TOKEN"), "token from logging in as an admin, sent to -server, otherwise $LEAGUE_TOKEN is used if set")
+}
+
+// backupCommand takes a backup, through a running server if given one
+func backupCommand(args []string) {
+	flags, server, dir, keyFile := commandFlags("backup")
+	token := adminTokenFlag(flags)
+	flags.Parse(args)
+
+	if *server != "" {
+		fmt.Print(callAdmin(*server, *token, "/admin/backup", nil))
+		return
+	}
+
+	withStore(*keyFile, false, func(store *httpserver.FileSystemPlayerStore) error {
+		backup, err := httpserver.NewBackupManager(*dir, store).Create()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", backup.Name)
+		return nil
+	})
+}
+
+// restoreCommand rolls the league back to a backup given by name or RFC 3339 time
+func restoreCommand(args []string) {
+	flags, server, dir, keyFile := commandFlags("restore")
+	token := adminTokenFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: restore [flags] <backup name | time, e.g. 2026-03-01T12:00:00Z>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	target := flags.Arg(0)
+
+	if *server != "" {
+		param := "backup"
+		if _, err := time.Parse(time.RFC3339, target); err == nil {
+			param = "at"
+		}
+		fmt.Print(callAdmin(*server, *token, "/admin/restore", url.Values{param: {target}}))
+		return
+	}
+
+	withStore(*keyFile, false, func(store *httpserver.FileSystemPlayerStore) error {
+		backup, err := httpserver.NewBackupManager(*dir, store).Restore(target)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("restored %s\n", backup.Name)
+		return nil
+	})
+}
+
+// keygenCommand prints a new random database key
+func keygenCommand() {
+	key, err := httpserver.GenerateDatabaseKey()
+	if err != nil {
+		log.Fatalf("problem generating key, %v", err)
+	}
+	fmt.Println(key)
+}
+
+// rotateKeyCommand re-encrypts the database file, its backups, the journal and
+// the webhooks file with a new key. The server should be stopped first.
+func rotateKeyCommand(args []string) {
+	flags := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	keyFile := flags.String("db-key-file", "", "file holding the current key, otherwise $"+httpserver.DatabaseKeyEnv+" is used if set")
+	newKeyFile := flags.String("new-key-file", "", "file holding the key to change to, leave empty to decrypt the database")
+	dir := flags.String("backup-dir", "backups", "directory backups are kept in, they are re-encrypted too")
+	journalFile := flags.String("journal-file", "journal.json", "journal to re-encrypt too")
+	webhooksFile := flags.String("webhooks-file", "webhooks.json", "webhooks file to re-encrypt too")
+	flags.Parse(args)
+
+	oldKey, err := httpserver.LoadDatabaseKey(*keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var newKey *httpserver.DatabaseKey
+	if *newKeyFile != "" {
+		newKey, err = httpserver.LoadDatabaseKey(*newKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	withStore(*keyFile, false, func(store *httpserver.FileSystemPlayerStore) error {
+		// backups first, so if this stops part way the database still opens with
+		// the old key and running it again finishes the job
+		rekeyed, err := httpserver.NewBackupManager(*dir, store).Rekey(oldKey, newKey)
+		if err != nil {
+			return err
+		}
+		err = rekeyFile(*journalFile, oldKey, newKey, func(path string, key *httpserver.DatabaseKey) (rekeyer, error) {
+			return httpserver.NewEncryptedJournal(path, key)
+		})
+		if err != nil {
+			return err
+		}
+		err = rekeyFile(*webhooksFile, oldKey, newKey, func(path string, key *httpserver.DatabaseKey) (rekeyer, error) {
+			return httpserver.NewEncryptedWebhookDispatcher(path, key)
+		})
+		if err != nil {
+			return err
+		}
+		err = store.Rekey(newKey)
+		if err != nil {
+			return err
+		}
+		if newKey == nil {
+			fmt.Printf("%s and %d backups are no longer encrypted\n", dbFileName, rekeyed)
+			return nil
+		}
+		fmt.Printf("%s and %d backups are now encrypted with key %s\n", dbFileName, rekeyed, newKey.ID())
+		return nil
+	})
+}
+
+type rekeyer interface {
+	Rekey(key *httpserver.DatabaseKey) error
+}
+
+// rekeyFile re-encrypts a file kept alongside the database with newKey. Files
+// that don't exist, or that an earlier run already re-encrypted, are skipped.
+func rekeyFile(path string, oldKey, newKey *httpserver.DatabaseKey, open func(string, *httpserver.DatabaseKey) (rekeyer, error)) error {
+	if _, err := os.Stat(path); path == "" || os.IsNotExist(err) {
+		return nil
+	}
+	file, err := open(path, oldKey)
+	if errors.Is(err, httpserver.ErrWrongKey) && newKey != nil {
+		if _, newErr := open(path, newKey); newErr == nil {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return file.Rekey(newKey)
+}
+
+// fsckCommand checks the database file and optionally repairs it. It exits
+// with status 1 when problems are left.
+func fsckCommand(args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	keyFile := flags.String("db-key-file", "", "file holding the database key, otherwise $"+httpserver.DatabaseKeyEnv+" is used if set")
+	repair := flags.Bool("repair", false, "fix the problems found, keeping a copy of the file first")
+	duplicates := flags.String("duplicates", httpserver.DefaultRepairOptions.Duplicates, "how to repair duplicate players: merge, first or highest")
+	negativeWins := flags.String("negative-wins", httpserver.DefaultRepairOptions.NegativeWins, "how to repair negative wins: zero or drop")
+	flags.Parse(args)
+
+	withStore(*keyFile, true, func(store *httpserver.FileSystemPlayerStore) error {
+		problems := store.Problems()
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s is ok\n", dbFileName)
+			return nil
+		}
+
+		if !*repair {
+			return fmt.Errorf("%d problems found, run with -repair to fix them", len(problems))
+		}
+		fixed, err := store.Repair(httpserver.RepairOptions{Duplicates: *duplicates, NegativeWins: *negativeWins})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("repaired %d problems\n", len(fixed))
+		return nil
+	})
+}
+
+// withStore opens the database file and runs do on it. The store is closed,
+// releasing the database lock, before exiting with status 1 if do fails.
+func withStore(keyFile string, readOnlyIfCorrupt bool, do func(*httpserver.FileSystemPlayerStore) error) {
+	store := openStore(keyFile, readOnlyIfCorrupt)
+	err := do(store)
+	if closeErr := store.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("problem closing %s, %v", dbFileName, closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func openStore(keyFile string, readOnlyIfCorrupt bool) *httpserver.FileSystemPlayerStore {
+	key, err := httpserver.LoadDatabaseKey(keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := os.OpenFile(dbFileName, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		log.Fatalf("problem opening %s %v", dbFileName, err)
+	}
+
+	open := httpserver.NewEncryptedFileSystemPlayerStore
+	if readOnlyIfCorrupt {
+		open = httpserver.NewReadOnlyFileSystemPlayerStore
+	}
+	store, err := open(db, key)
+	if err != nil {
+		log.Fatalf("problem creating file system player store, %v ", err)
+	}
+	return store
+}
+
+// openEventStore opens, or starts, the event log at path and replays it
+func openEventStore(path string) *httpserver.EventSourcedPlayerStore {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		log.Fatalf("problem opening %s %v", path, err)
+	}
+
+	events, err := httpserver.NewFileEventLog(file)
+	if err != nil {
+		log.Fatalf("problem reading event log, %v", err)
+	}
+	store, err := httpserver.NewEventSourcedPlayerStore(events)
+	if err != nil {
+		log.Fatalf("problem creating event sourced player store, %v", err)
+	}
+	return store
+}
+
+// callAdmin POSTs to an admin route on a running server and returns the body
+func callAdmin(server, token, path string, query url.Values) string {
+	target := strings.TrimSuffix(server, "/") + path
+	if query != nil {
+		target += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequest(http.MethodPost, target, nil)
+	if err != nil {
+		log.Fatalf("problem calling %s, %v", target, err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Fatalf("problem calling %s, %v", target, err)
+	}
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode >= 300 {
+		log.Fatalf("%s returned %s: %s", target, response.Status, strings.TrimSpace(string(body)))
+	}
+	return string(body)
+}
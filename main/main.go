@@ -2,34 +2,194 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"hello/httpserver"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
 )
 
 
 const dbFileName = "game.db.json"
 func main() {
-	db, err := os.OpenFile(dbFileName, os.O_RDWR|os.O_CREATE, 0666)
-	if err != nil{
-		log.Fatalf("problem opening %s %v", dbFileName, err)
+	if runCommand(os.Args[1:]) {
+		return
 	}
 
-	store, err := httpserver.NewFileSystemPlayerStore(db)
+	drainDelay := flag.Duration("drain-delay", 5*time.Second, "how long /readyz fails before the server stops on shutdown")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for open requests on shutdown")
+	addr := flag.String("addr", ":5000", "address to serve the player API on")
+	certFile := flag.String("tls-cert", "", "certificate file, turns on HTTPS")
+	keyFile := flag.String("tls-key", "", "private key file for -tls-cert")
+	redirectAddr := flag.String("redirect-addr", "", "plain HTTP address that redirects to HTTPS")
+	sessionTTL := flag.Duration("session-ttl", httpserver.DefaultSessionTTL, "how long a token from /login lasts")
+	clientCAFile := flag.String("client-ca", "", "CA file for client certificates required on admin routes, otherwise they need an admin's token")
+	rateLimit := flag.Bool("rate-limit", false, "apply the default per client rate limits")
+	maxWinsPerHour := flag.Int("max-wins-per-hour", 0, "most wins a player can be given in an hour, 0 for no limit")
+	autoCreate := flag.Bool("auto-create", true, "create players the first time they win")
+	namePattern := flag.String("name-pattern", "", "regular expression player names must match, e.g. "+httpserver.DefaultNamePattern.String()+", empty allows any name")
+	corsOrigins := flag.String("cors-origins", "", "comma separated origins allowed to call the API from a browser, e.g. https://*.example.com")
+	corsCredentials := flag.Bool("cors-credentials", false, "allow browsers to send credentials on cross origin requests")
+	corsMaxAge := flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache CORS preflight responses")
+	compressMinSize := flag.Int("compress-min-size", 1400, "smallest response body to gzip/deflate, 0 compresses every body, negative turns compression off")
+	normalizeNames := flag.Bool("normalize-names", false, "treat player names that only differ by case as the same player")
+	migrateOnly := flag.Bool("migrate-only", false, "upgrade the database file to the current version and exit")
+	backupDir := flag.String("backup-dir", "backups", "directory backups are kept in")
+	backupInterval := flag.Duration("backup-interval", 0, "how often to take a backup, 0 for never")
+	backupKeep := flag.Int("backup-keep", 7, "how many backups to keep, 0 for all of them")
+	backupMaxAge := flag.Duration("backup-max-age", 0, "remove backups older than this, 0 for no limit")
+	dbKeyFile := flag.String("db-key-file", "", "file holding the key the database is encrypted with, otherwise $"+httpserver.DatabaseKeyEnv+" is used if set")
+	webhooksFile := flag.String("webhooks-file", "webhooks.json", "where registered webhooks and undelivered events are kept, empty turns webhooks off")
+	webhooksAllowPrivate := flag.Bool("webhooks-allow-private", false, "allow webhooks to loopback, link-local and private addresses")
+	journalFile := flag.String("journal-file", "journal.json", "where recent changes and deleted players are kept so they can be undone, empty keeps them in memory")
+	storeKind := flag.String("store", "file", "how the league is kept: file for "+dbFileName+", events for an append-only event log without backups, fsck, profiles or encryption, remote for another server")
+	remoteURL := flag.String("remote-url", "", "server used by -store=remote, e.g. http://league.internal:5000")
+	remoteTimeout := flag.Duration("remote-timeout", 5*time.Second, "how long each call to the -remote-url server may take")
+	remoteCacheTTL := flag.Duration("remote-cache-ttl", time.Second, "how long reads from the -remote-url server are cached, 0 for not at all")
+	eventsFile := flag.String("events-file", "league.events", "event log used by -store=events")
+	readOnlyIfCorrupt := flag.Bool("read-only-if-corrupt", false, "start read only instead of failing when the database is corrupt, then repair it with /admin/fsck")
+	flag.Parse()
 
-	if err != nil {
-		log.Fatalf("problem creating file system player store, %v ", err)
+	var store interface {
+		httpserver.PlayerStore
+		Close() error
+	}
+	switch *storeKind {
+	case "file":
+		store = openStore(*dbKeyFile, *readOnlyIfCorrupt)
+		if *migrateOnly {
+			if err := store.Close(); err != nil {
+				log.Fatalf("problem closing the store, %v", err)
+			}
+			log.Printf("%s is up to date", dbFileName)
+			return
+		}
+	case "events":
+		if *backupInterval > 0 {
+			log.Fatal("-store=events can't be backed up, use -store=file for -backup-interval")
+		}
+		store = openEventStore(*eventsFile)
+	case "remote":
+		if *remoteURL == "" {
+			log.Fatal("-store=remote needs -remote-url")
+		}
+		remote := httpserver.NewRemotePlayerStore(*remoteURL)
+		remote.Timeout = *remoteTimeout
+		remote.CacheTTL = *remoteCacheTTL
+		store = remote
+	default:
+		log.Fatalf("-store must be file, events or remote, not %q", *storeKind)
+	}
+
+	// closing the store releases the database lock, which log.Fatal would skip
+	fatalf := func(format string, args ...interface{}) {
+		store.Close()
+		log.Fatalf(format, args...)
 	}
 
 	server := httpserver.NewPlayerServer(store)
+	server.Addr = *addr
+	server.DrainDelay = *drainDelay
+	server.TLSCertFile = *certFile
+	server.TLSKeyFile = *keyFile
+	server.RedirectAddr = *redirectAddr
+	server.ClientCAFile = *clientCAFile
+	server.SessionTTL = *sessionTTL
+	server.NormalizeNames = *normalizeNames
+	if *rateLimit {
+		server.RateLimits = httpserver.DefaultRateLimits
+	}
+
+	if *compressMinSize < 0 {
+		server.Compression = nil
+	} else {
+		server.Compression.MinSize = *compressMinSize
+	}
+
+	if *corsOrigins != "" {
+		server.CORS = &httpserver.CORSConfig{
+			AllowedOrigins:   strings.Split(*corsOrigins, ","),
+			AllowCredentials: *corsCredentials,
+			MaxAge:           *corsMaxAge,
+		}
+		if err := server.CORS.Validate(); err != nil {
+			fatalf("problem with -cors-origins, %v", err)
+		}
+	}
+
+	var pattern *regexp.Regexp
+	if *namePattern != "" {
+		var err error
+		pattern, err = regexp.Compile(*namePattern)
+		if err != nil {
+			fatalf("problem with -name-pattern %q, %v", *namePattern, err)
+		}
+	}
+	server.Rules = httpserver.StandardRules(pattern, *autoCreate, *maxWinsPerHour)
+
+	if snapshotter, ok := store.(httpserver.Snapshotter); ok {
+		server.Backups = httpserver.NewBackupManager(*backupDir, snapshotter)
+		server.Backups.Keep = *backupKeep
+		server.Backups.MaxAge = *backupMaxAge
+		if *backupInterval > 0 {
+			stopBackups := server.Backups.Schedule(*backupInterval)
+			defer stopBackups()
+		}
+	}
+
+	// the journal and webhook outbox hold league data too, so they are
+	// encrypted with the same key as the database
+	dbKey, err := httpserver.LoadDatabaseKey(*dbKeyFile)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if dbKey != nil && *storeKind == "events" {
+		fatalf("-store=events isn't encrypted, use -store=file with a database key")
+	}
+	if *webhooksFile != "" {
+		server.Webhooks, err = httpserver.NewEncryptedWebhookDispatcher(*webhooksFile, dbKey)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		server.Webhooks.AllowPrivateTargets = *webhooksAllowPrivate
+		server.Webhooks.Start()
+	}
 
-	server.ListenAndServe()
-	//if err := http.ListenAndServe(":5000", server); err != nil {
-	//	log.Fatalf("cound not listen on port 5000 %v", err)
-	//}
+	server.Journal, err = httpserver.NewEncryptedJournal(*journalFile, dbKey)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	go shutdownOnSignal(server, *shutdownTimeout)
 
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fatalf("cound not listen on %s %v", server.Addr, err)
+	}
+	// ListenAndServe returns as soon as shutdown starts, let open requests finish
+	<-server.Stopped()
 
+	if err := store.Close(); err != nil {
+		log.Printf("problem closing the store, %v", err)
+	}
+}
 
+// shutdownOnSignal gracefully stops the server on SIGINT or SIGTERM
+func shutdownOnSignal(server *httpserver.PlayerServer, timeout time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
 
+	log.Println("shutting down, draining for", server.DrainDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), server.DrainDelay+timeout)
+	defer cancel()
 
-}
\ No newline at end of file
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("problem shutting down server, %v", err)
+	}
+}